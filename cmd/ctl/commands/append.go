@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 本地文件路径 local_file_path 远程文件路径 remote_file_path
+// 输出 是否成功 result
+var appendCmd = &cobra.Command{
+	Use:   "Append [local_file_path] [remote_file_path]",
+	Short: "Append object to the end of remote file in SDSS cluster",
+	Long:  `将本地文件内容追加到分布式文件存储系统中已有文件的末尾`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Append [local_file_path] [remote_file_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.Append(args[0], args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(appendCmd)
+}
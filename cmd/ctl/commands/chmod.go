@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+	"strconv"
+)
+
+// 输入 八进制权限 mode 远程路径 remote_path
+// 输出 是否成功 result
+var chmodCmd = &cobra.Command{
+	Use:   "Chmod [mode] [remote_path]",
+	Short: "Change the permission bits of object in SDSS cluster",
+	Long:  `修改分布式文件存储系统中文件或目录的权限位，权限以八进制表示，如 755 或 1777`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Chmod [mode] [remote_path]")
+			os.Exit(1)
+		}
+
+		mode, err := strconv.ParseUint(args[0], 8, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid mode %v\n", args[0])
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err = client.Chmod(args[1], uint32(mode))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(chmodCmd)
+}
@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+	"strings"
+)
+
+// 输入 所有者与组 owner[:group] 远程路径 remote_path
+// 输出 是否成功 result
+var chownCmd = &cobra.Command{
+	Use:   "Chown [owner][:group] [remote_path]",
+	Short: "Change the owner and group of object in SDSS cluster",
+	Long:  `修改分布式文件存储系统中文件或目录的所有者与组，省略的部分保持不变`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Chown [owner][:group] [remote_path]")
+			os.Exit(1)
+		}
+
+		owner, group := args[0], ""
+		index := strings.Index(args[0], ":")
+		if index >= 0 {
+			owner, group = args[0][:index], args[0][index+1:]
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.Chown(args[1], owner, group)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(chownCmd)
+}
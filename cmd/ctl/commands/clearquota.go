@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 远程目录 remote_dir_path
+// 输出 是否成功 result
+var clearQuotaCmd = &cobra.Command{
+	Use:   "ClearQuota [remote_dir_path]",
+	Short: "Remove the quota of remote_dir_path",
+	Long:  `清除分布式文件存储系统中目录的配额`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: ClearQuota [remote_dir_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.ClearQuota(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(clearQuotaCmd)
+}
@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 目标远程文件路径 concat_target_path 源远程文件路径 concat_source_path...
+// 输出 是否成功 result
+var concatCmd = &cobra.Command{
+	Use:   "Concat [concat_target_path] [concat_source_path...]",
+	Short: "Concat source files to the end of target file and remove them",
+	Long:  `将源文件按顺序拼接到目标文件末尾并删除源文件，数据块直接移交给目标文件，不重写数据；除最后一个文件外，其余文件的大小须为块大小的整数倍`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Concat [concat_target_path] [concat_source_path...]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.Concat(args[0], args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(concatCmd)
+}
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+var showQuota bool
+
+// formatQuota shows the limit and what remains of it
+func formatQuota(limit, used int64) (string, string) {
+	if limit == 0 {
+		return "none", "inf"
+	}
+	return fmt.Sprint(limit), fmt.Sprint(limit - used)
+}
+
+// 输入 远程路径 remote_path
+// 输出 目录数 文件数 字节数 (及配额)
+var countCmd = &cobra.Command{
+	Use:   "Count [-q] [remote_path]",
+	Short: "Count the dirs, files and bytes under remote_path",
+	Long:  `统计分布式文件存储系统中路径下的目录数、文件数与字节数，-q 同时显示配额`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: Count [-q] [remote_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(true, token)
+		defer client.CloseClient()
+		count, err := client.Count(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		title := color.New(color.Bold, color.Underline)
+		if showQuota {
+			fileQuota, fileRemaining := formatQuota(count.FileQuota, count.Files)
+			byteQuota, byteRemaining := formatQuota(count.ByteQuota, count.SpaceConsumed)
+			title.Printf("%12v %12v %12v %12v %12v %12v %12v %v\n", "file quota", "remaining", "byte quota", "remaining",
+				"dirs", "files", "size (bytes)", "path")
+			fmt.Printf("%12v %12v %12v %12v %12v %12v %12v %v\n", fileQuota, fileRemaining, byteQuota, byteRemaining,
+				count.Dirs, count.Files, count.Size, args[0])
+		} else {
+			title.Printf("%12v %12v %12v %v\n", "dirs", "files", "size (bytes)", "path")
+			fmt.Printf("%12v %12v %12v %v\n", count.Dirs, count.Files, count.Size, args[0])
+		}
+	},
+}
+
+func init() {
+	countCmd.Flags().BoolVarP(&showQuota, "quota", "q", false, "show the quota of the dir")
+	rootCmd.AddCommand(countCmd)
+}
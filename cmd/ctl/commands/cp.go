@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 原远程文件路径 cp_src_path 目标远程文件路径 cp_dest_path
+// 输出 是否成功 result
+var cpCmd = &cobra.Command{
+	Use:   "Cp [cp_src_path] [cp_dest_path]",
+	Short: "Copy file from src_path to dest_path inside SDSS cluster",
+	Long:  `在分布式文件存储系统内部复制文件，新文件与原文件共享数据块，数据不经过客户端`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Cp [cp_src_path] [cp_dest_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.Copy(args[0], args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cpCmd)
+}
@@ -7,21 +7,26 @@ import (
 	"simple-distributed-storage-system/src/client"
 )
 
+var (
+	recursive bool
+	skipTrash bool
+)
+
 // 输入 需要删除的远程文件路径 remote_file_path
 // 输出 删除结果 result
 var deleteCmd = &cobra.Command{
-	Use:   "Delete [remote_file_path]",
+	Use:   "Delete [-r] [--skipTrash] [remote_file_path]",
 	Short: "Delete object from SDSS cluster",
 	Long:  `删除分布式文件存储系统中的文件`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
-			fmt.Fprintln(os.Stderr, "usage: Delete [remote_file_path]")
+			fmt.Fprintln(os.Stderr, "usage: Delete [-r] [--skipTrash] [remote_file_path]")
 			os.Exit(1)
 		}
 
-		client := client.NewClient(false)
+		client := client.NewClientWithToken(false, token)
 		defer client.CloseClient()
-		err := client.Remove(args[0])
+		err := client.Delete(args[0], recursive, skipTrash)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -30,5 +35,7 @@ var deleteCmd = &cobra.Command{
 }
 
 func init() {
+	deleteCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "delete dir with all its descendants")
+	deleteCmd.Flags().BoolVar(&skipTrash, "skipTrash", false, "delete at once instead of moving to trash")
 	rootCmd.AddCommand(deleteCmd)
 }
@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 无
+// 输出 是否成功 result
+var expungeCmd = &cobra.Command{
+	Use:   "Expunge",
+	Short: "Empty the trash of current user in SDSS cluster",
+	Long:  `永久删除当前用户回收站中的所有文件`,
+	Run: func(cmd *cobra.Command, args []string) {
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.Expunge()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(expungeCmd)
+}
@@ -19,7 +19,7 @@ var getCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		client := client.NewClient(true)
+		client := client.NewClientWithToken(true, token)
 		defer client.CloseClient()
 		err := client.Get(args[0], args[1])
 		if err != nil {
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 远程路径 remote_path 属性名 name
+// 输出 属性值 value
+var getXAttrCmd = &cobra.Command{
+	Use:   "GetXAttr [remote_path] [name]",
+	Short: "Get an extended attribute of object in SDSS cluster",
+	Long:  `获取分布式文件存储系统中文件或目录的扩展属性值`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: GetXAttr [remote_path] [name]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(true, token)
+		defer client.CloseClient()
+		value, err := client.GetXAttr(args[0], args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(value))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(getXAttrCmd)
+}
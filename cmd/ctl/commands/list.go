@@ -7,10 +7,10 @@ import (
 	"os"
 	"simple-distributed-storage-system/src/client"
 	"simple-distributed-storage-system/src/utils"
-	"sort"
-	"strings"
 )
 
+const listPageSize = 100
+
 // 输入 需要 ls 的远程路径 remote_dir_path
 // 输出 远程文件信息
 var listCmd = &cobra.Command{
@@ -23,22 +23,35 @@ var listCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		client := client.NewClient(true)
+		client := client.NewClientWithToken(true, token)
 		defer client.CloseClient()
-		infos, err := client.List(args[0])
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
-		}
 
-		sort.Slice(infos, func(i, j int) bool {
-			return strings.Compare(infos[i].Name, infos[j].Name) < 0
-		})
-		title := color.New(color.Bold, color.Underline)
-		gap := utils.Max(uint64(len(infos[len(infos)-1].Name)), uint64(len("name")))
-		title.Printf("%-*v %v\n", gap, "name", "size (bytes)")
-		for _, info := range infos {
-			fmt.Printf("%-*v %v\n", gap, info.Name, info.Size)
+		// page through the dir, children come sorted by name
+		cursor := ""
+		for {
+			infos, next, err := client.ListPage(args[0], cursor, listPageSize)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			gap := len("name")
+			for _, info := range infos {
+				gap = utils.Max(uint64(len(formatName(info))), uint64(gap))
+			}
+			if cursor == "" {
+				title := color.New(color.Bold, color.Underline)
+				title.Printf("%-*v %-10v %-8v %-8v %-12v %v\n", gap, "name", "mode", "owner", "group", "size (bytes)", "modified")
+			}
+			for _, info := range infos {
+				fmt.Printf("%-*v %-10v %-8v %-8v %-12v %v\n", gap, formatName(info), formatMode(info),
+					info.Owner, info.Group, info.Size, formatTime(info.Mtime))
+			}
+
+			if next == "" {
+				break
+			}
+			cursor = next
 		}
 	},
 }
@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 远程路径 remote_path
+// 输出 属性名列表
+var listXAttrsCmd = &cobra.Command{
+	Use:   "ListXAttrs [remote_path]",
+	Short: "List the extended attributes of object in SDSS cluster",
+	Long:  `获取分布式文件存储系统中文件或目录的扩展属性名列表`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: ListXAttrs [remote_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(true, token)
+		defer client.CloseClient()
+		names, err := client.ListXAttrs(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listXAttrsCmd)
+}
@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+	"strings"
+)
+
+// 输入 无
+// 输出 副本位于同一机架或可用区的块
+var misplacedCmd = &cobra.Command{
+	Use:   "Misplaced",
+	Short: "List the blocks whose replicas share a rack or a zone",
+	Long:  `列出分布式文件存储系统中副本集中在同一机架或可用区的块，而集群中还有其他机架或可用区可以存放它们`,
+	Run: func(cmd *cobra.Command, args []string) {
+		client := client.NewClientWithToken(true, token)
+		defer client.CloseClient()
+		blocks, err := client.FetchMisplacedBlocks()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		title := color.New(color.Bold, color.Underline)
+		title.Printf("%8v %-24v %-48v %v\n", "index", "domain", "datanodes", "path")
+		for _, block := range blocks {
+			fmt.Printf("%8v %-24v %-48v %v\n", block.Index, block.Domain, strings.Join(block.Addrs, ","), block.Path)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(misplacedCmd)
+}
@@ -19,7 +19,7 @@ var mkdirCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		client := client.NewClient(false)
+		client := client.NewClientWithToken(false, token)
 		defer client.CloseClient()
 		err := client.Mkdir(args[0])
 		if err != nil {
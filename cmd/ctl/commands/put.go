@@ -7,21 +7,28 @@ import (
 	"simple-distributed-storage-system/src/client"
 )
 
-// 输入 本地文件路径 local_file_path 远程文件路径 remote_file_path
+var putOptions client.PutOptions
+
+// 输入 副本数 replication 块大小 block_size 本地文件路径 local_file_path 远程文件路径 remote_file_path
 // 输出 是否成功 result
 var putCmd = &cobra.Command{
-	Use:   "Put [local_file_path] [remote_file_path]",
+	Use:   "Put [--replication N] [--block-size N] [local_file_path] [remote_file_path]",
 	Short: "Put object to remote SDSS cluster",
-	Long:  `将本地文件上传分布式文件存储系统`,
+	Long:  `将本地文件上传分布式文件存储系统，本地文件路径为 - 时从标准输入读取，副本数与块大小为 0 时使用集群默认值`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "usage: Put [local_file_path] [remote_file_path]")
+			fmt.Fprintln(os.Stderr, "usage: Put [--replication N] [--block-size N] [local_file_path] [remote_file_path]")
 			os.Exit(1)
 		}
 
-		client := client.NewClient(false)
+		client := client.NewClientWithToken(false, token)
 		defer client.CloseClient()
-		err := client.Put(args[0], args[1])
+		var err error
+		if args[0] == "-" {
+			err = client.PutStreamWithOptions(os.Stdin, args[1], putOptions)
+		} else {
+			err = client.PutWithOptions(args[0], args[1], putOptions)
+		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -30,5 +37,7 @@ var putCmd = &cobra.Command{
 }
 
 func init() {
+	putCmd.Flags().Uint32Var(&putOptions.Replication, "replication", 0, "replicas of each block, 0 for the default")
+	putCmd.Flags().Uint64Var(&putOptions.BlockSize, "block-size", 0, "size of each block in bytes, 0 for the default")
 	rootCmd.AddCommand(putCmd)
 }
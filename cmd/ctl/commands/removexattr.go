@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 远程路径 remote_path 属性名 name
+// 输出 是否成功 result
+var removeXAttrCmd = &cobra.Command{
+	Use:   "RemoveXAttr [remote_path] [name]",
+	Short: "Remove an extended attribute of object in SDSS cluster",
+	Long:  `删除分布式文件存储系统中文件或目录的扩展属性`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: RemoveXAttr [remote_path] [name]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.RemoveXAttr(args[0], args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(removeXAttrCmd)
+}
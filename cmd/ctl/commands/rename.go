@@ -7,21 +7,28 @@ import (
 	"simple-distributed-storage-system/src/client"
 )
 
+var overwrite bool
+
 // 输入 原远程路径 rename_src_path 目标远程路径 rename_dest_path
 // 输出 是否成功 result
 var renameCmd = &cobra.Command{
-	Use:   "Rename [rename_src_path] [rename_dest_path]",
+	Use:   "Rename [-f] [rename_src_path] [rename_dest_path]",
 	Short: "Rename object from src_path to dest_path",
 	Long:  `将分布式文件系统中的原始路径重命名为新的目标路径`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "usage: Rename [rename_src_path] [rename_dest_path]")
+			fmt.Fprintln(os.Stderr, "usage: Rename [-f] [rename_src_path] [rename_dest_path]")
 			os.Exit(1)
 		}
 
-		client := client.NewClient(false)
+		client := client.NewClientWithToken(false, token)
 		defer client.CloseClient()
-		err := client.Rename(args[0], args[1])
+		var err error
+		if overwrite {
+			err = client.RenameOverwrite(args[0], args[1])
+		} else {
+			err = client.Rename(args[0], args[1])
+		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -30,5 +37,6 @@ var renameCmd = &cobra.Command{
 }
 
 func init() {
+	renameCmd.Flags().BoolVarP(&overwrite, "force", "f", false, "overwrite the dest if it exists")
 	rootCmd.AddCommand(renameCmd)
 }
@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 回收站中的远程路径 trash_path
+// 输出 是否成功 result
+var restoreCmd = &cobra.Command{
+	Use:   "Restore [trash_path]",
+	Short: "Restore object from trash of SDSS cluster",
+	Long:  `将分布式文件存储系统回收站中的文件恢复到删除前的路径`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: Restore [trash_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.Restore(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"os"
+	"simple-distributed-storage-system/src/utils"
 )
 
+// token authenticates the commands, it is a static token or a jwt
+var token string
+
 var rootCmd = &cobra.Command{
 	Use:   "SDSS",
 	Short: "SDSS is a simple distributed storage system",
@@ -19,6 +23,10 @@ Complete documentation is available at https://github.com/HLRJ/simple-distribute
 	},
 }
 
+func init() {
+	rootCmd.PersistentFlags().StringVar(&token, "token", utils.CurrentToken(), "bearer token, defaults to $SDSS_TOKEN")
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 远程目录路径 remote_dir_path 纠删码策略 policy
+// 输出 是否成功 result
+var setECCmd = &cobra.Command{
+	Use:   "SetEC [remote_dir_path] [policy]",
+	Short: "Erasure code the files created under remote_dir_path with policy such as RS-6-3",
+	Long:  `设置分布式文件存储系统中目录的纠删码策略，如 RS-6-3，之后在该目录下创建的文件按策略切分为数据块与校验块存储在不同的数据节点上，省略策略则恢复继承上级目录的策略`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: SetEC [remote_dir_path] [policy]")
+			os.Exit(1)
+		}
+		policy := ""
+		if len(args) > 1 {
+			policy = args[1]
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.SetErasureCoding(args[0], policy)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(setECCmd)
+}
@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+var (
+	fileQuota int64
+	byteQuota int64
+)
+
+// 输入 文件数配额 files 字节数配额 bytes 远程目录 remote_dir_path
+// 输出 是否成功 result
+var setQuotaCmd = &cobra.Command{
+	Use:   "SetQuota [--files N] [--bytes N] [remote_dir_path]",
+	Short: "Limit the files and bytes under remote_dir_path",
+	Long:  `设置分布式文件存储系统中目录的配额，限制其下的文件数与包含副本在内的字节数，0 表示不限制`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: SetQuota [--files N] [--bytes N] [remote_dir_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.SetQuota(args[0], fileQuota, byteQuota)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	setQuotaCmd.Flags().Int64Var(&fileQuota, "files", 0, "max files under the dir, 0 for unlimited")
+	setQuotaCmd.Flags().Int64Var(&byteQuota, "bytes", 0, "max bytes with replicas under the dir, 0 for unlimited")
+	rootCmd.AddCommand(setQuotaCmd)
+}
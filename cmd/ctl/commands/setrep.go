@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+	"strconv"
+)
+
+// 输入 远程文件路径 remote_file_path 副本数 replication
+// 输出 是否成功 result
+var setRepCmd = &cobra.Command{
+	Use:   "SetRep [remote_file_path] [replication]",
+	Short: "Set the replicas of each block of remote_file_path",
+	Long:  `设置分布式文件存储系统中文件每个数据块的副本数，副本由名字节点在后台增加或删除`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: SetRep [remote_file_path] [replication]")
+			os.Exit(1)
+		}
+		replication, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err = client.SetReplication(args[0], uint32(replication))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(setRepCmd)
+}
@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+)
+
+// 输入 远程路径 remote_path 属性名 name 属性值 value
+// 输出 是否成功 result
+var setXAttrCmd = &cobra.Command{
+	Use:   "SetXAttr [remote_path] [name] [value]",
+	Short: "Set an extended attribute of object in SDSS cluster",
+	Long:  `设置分布式文件存储系统中文件或目录的扩展属性，已存在时覆盖原值`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: SetXAttr [remote_path] [name] [value]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.SetXAttr(args[0], args[1], []byte(args[2]))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(setXAttrCmd)
+}
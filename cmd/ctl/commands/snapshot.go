@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+	"simple-distributed-storage-system/src/protos"
+	"simple-distributed-storage-system/src/utils"
+	"time"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "Snapshot",
+	Short: "Manage read-only snapshots of dirs in SDSS cluster",
+	Long:  `管理分布式文件存储系统中目录的只读快照，快照内容可通过 <dir>/.snapshot/<name>/ 读取`,
+}
+
+// 输入 需要快照的远程目录 remote_dir_path 快照名 name (可选)
+// 输出 快照名
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create [remote_dir_path] [name]",
+	Short: "Create a snapshot of remote_dir_path",
+	Long:  `为分布式文件存储系统中的目录创建只读快照，未指定快照名时按当前时间生成`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: Snapshot create [remote_dir_path] [name]")
+			os.Exit(1)
+		}
+
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		name, err := client.CreateSnapshot(args[0], name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(name)
+	},
+}
+
+// 输入 远程目录 remote_dir_path 快照名 name
+// 输出 是否成功 result
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete [remote_dir_path] [name]",
+	Short: "Delete a snapshot of remote_dir_path",
+	Long:  `删除分布式文件存储系统中目录的快照，仅被该快照引用的数据块将被回收`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Snapshot delete [remote_dir_path] [name]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.DeleteSnapshot(args[0], args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// 输入 远程目录 remote_dir_path
+// 输出 快照列表
+var snapshotListCmd = &cobra.Command{
+	Use:   "list [remote_dir_path]",
+	Short: "List the snapshots of remote_dir_path",
+	Long:  `获取分布式文件存储系统中目录的快照列表`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: Snapshot list [remote_dir_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(true, token)
+		defer client.CloseClient()
+		snapshots, err := client.ListSnapshots(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		gap := len("name")
+		for _, snapshot := range snapshots {
+			gap = utils.Max(uint64(len(snapshot.Name)), uint64(gap))
+		}
+		title := color.New(color.Bold, color.Underline)
+		title.Printf("%-*v %v\n", gap, "name", "created")
+		for _, snapshot := range snapshots {
+			fmt.Printf("%-*v %v\n", gap, snapshot.Name, time.Unix(snapshot.Created, 0).Format(time.RFC3339))
+		}
+	},
+}
+
+// 输入 远程目录 remote_dir_path 起始快照 from 目标快照 to (可选，默认为当前目录)
+// 输出 差异列表
+var snapshotDiffCmd = &cobra.Command{
+	Use:   "diff [remote_dir_path] [from] [to]",
+	Short: "Show the changes of remote_dir_path between two snapshots",
+	Long:  `比较分布式文件存储系统中目录的两个快照，未指定目标快照时与当前目录比较`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Snapshot diff [remote_dir_path] [from] [to]")
+			os.Exit(1)
+		}
+
+		to := ""
+		if len(args) > 2 {
+			to = args[2]
+		}
+
+		client := client.NewClientWithToken(true, token)
+		defer client.CloseClient()
+		entries, err := client.SnapshotDiff(args[0], args[1], to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		for _, entry := range entries {
+			fmt.Printf("%v %v\n", diffMarks[entry.Type], entry.Path)
+		}
+	},
+}
+
+var diffMarks = map[protos.SnapshotDiffType]string{
+	protos.SnapshotDiffType_DIFF_CREATE: "+",
+	protos.SnapshotDiffType_DIFF_DELETE: "-",
+	protos.SnapshotDiffType_DIFF_MODIFY: "M",
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCmd.AddCommand(snapshotDeleteCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
+	snapshotCmd.AddCommand(snapshotDiffCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
@@ -7,8 +7,16 @@ import (
 	"os"
 	"simple-distributed-storage-system/src/client"
 	"simple-distributed-storage-system/src/utils"
+	"time"
 )
 
+const timeLayout = "2006-01-02 15:04:05"
+
+// formatTime renders unix nanoseconds in local time
+func formatTime(t int64) string {
+	return time.Unix(0, t).Format(timeLayout)
+}
+
 // 输入 需要获取的远程文件路径 remote_file_path
 // 输出 远程文件信息
 var statCmd = &cobra.Command{
@@ -21,7 +29,7 @@ var statCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		client := client.NewClient(true)
+		client := client.NewClientWithToken(true, token)
 		defer client.CloseClient()
 		info, err := client.Stat(args[0])
 		if err != nil {
@@ -29,10 +37,19 @@ var statCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// erasure coded files have no replicas
+		replication := fmt.Sprint(info.Replication)
+		if info.ErasureCoding != "" {
+			replication = info.ErasureCoding
+		}
+
 		title := color.New(color.Bold, color.Underline)
-		gap := utils.Max(uint64(len(info.Name)), uint64(len("name")))
-		title.Printf("%-*v %v\n", gap, "name", "size (bytes)")
-		fmt.Printf("%-*v %v\n", gap, info.Name, info.Size)
+		name := formatName(info)
+		gap := utils.Max(uint64(len(name)), uint64(len("name")))
+		title.Printf("%-*v %-10v %-8v %-8v %-12v %-11v %-10v %-19v %-19v %v\n", gap, "name", "mode", "owner", "group", "size (bytes)",
+			"replication", "block size", "created", "modified", "accessed")
+		fmt.Printf("%-*v %-10v %-8v %-8v %-12v %-11v %-10v %-19v %-19v %v\n", gap, name, formatMode(info),
+			info.Owner, info.Group, info.Size, replication, info.BlockSize, formatTime(info.Ctime), formatTime(info.Mtime), formatTime(info.Atime))
 	},
 }
 
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+	"simple-distributed-storage-system/src/protos"
+	"simple-distributed-storage-system/src/utils"
+)
+
+// formatMode renders the mode of an object like ls -l does, with l for symlinks
+func formatMode(info *protos.FileInfo) string {
+	mode := utils.FormatMode(utils.IsDir(info.Name), info.Mode)
+	if info.Target != "" {
+		mode = "l" + mode[1:]
+	}
+	return mode
+}
+
+// formatName renders the name of an object, with the target for symlinks
+func formatName(info *protos.FileInfo) string {
+	if info.Target != "" {
+		return fmt.Sprintf("%v -> %v", info.Name, info.Target)
+	}
+	return info.Name
+}
+
+// 输入 链接目标 target 远程链接路径 remote_link_path
+// 输出 是否成功 result
+var symlinkCmd = &cobra.Command{
+	Use:   "Symlink [target] [remote_link_path]",
+	Short: "Create a symbolic link in SDSS cluster",
+	Long:  `在分布式文件存储系统中创建指向目标路径的符号链接，相对路径的目标从链接所在目录开始解析`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Symlink [target] [remote_link_path]")
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err := client.CreateSymlink(args[0], args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(symlinkCmd)
+}
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+	"strconv"
+)
+
+// 输入 远程文件路径 remote_file_path 新长度 size
+// 输出 是否成功 result
+var truncateCmd = &cobra.Command{
+	Use:   "Truncate [remote_file_path] [size]",
+	Short: "Truncate file in SDSS cluster to size bytes",
+	Long:  `将分布式文件存储系统中的文件截断到指定长度`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: Truncate [remote_file_path] [size]")
+			os.Exit(1)
+		}
+		size, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err = client.Truncate(args[0], size)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(truncateCmd)
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"simple-distributed-storage-system/src/client"
+	"strconv"
+)
+
+// 输入 本地文件路径 local_file_path 远程文件路径 remote_file_path 偏移量 offset
+// 输出 是否成功 result
+var writeAtCmd = &cobra.Command{
+	Use:   "WriteAt [local_file_path] [remote_file_path] [offset]",
+	Short: "Overwrite remote file in SDSS cluster from offset with object",
+	Long:  `用本地文件内容从指定偏移量开始覆盖分布式文件存储系统中的文件，超出文件末尾的部分会扩展文件`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: WriteAt [local_file_path] [remote_file_path] [offset]")
+			os.Exit(1)
+		}
+		offset, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		client := client.NewClientWithToken(false, token)
+		defer client.CloseClient()
+		err = client.WriteAt(args[1], offset, data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(writeAtCmd)
+}
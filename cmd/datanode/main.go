@@ -3,12 +3,30 @@ package main
 import (
 	"context"
 	"flag"
+	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/datanode"
 )
 
-var addr = flag.String("addr", "localhost:9000", "Node host address")
+var (
+	addr          = flag.String("addr", "localhost:9000", "Node host address")
+	tokenFile     = flag.String("auth-token-file", consts.AuthTokenFile, "File of static tokens as token,user[,group...] lines")
+	jwtSecretFile = flag.String("auth-jwt-secret-file", consts.AuthJWTSecretFile, "File of the secret verifying HS256 jwt bearer tokens")
+	serviceToken  = flag.String("token", consts.ServiceToken, "Token to call namenode servers with")
+	zone          = flag.String("zone", "", "Zone of the node, replicas are spread across zones")
+	rack          = flag.String("rack", "", "Rack of the node in its zone, replicas are spread across racks")
+	capacity      = flag.Uint64("capacity", 0, "Bytes the node may hold, 0 for the size of its disk")
+	reserved      = flag.Uint64("reserved", 0, "Bytes of the capacity kept for other uses than blocks")
+)
 
 func main() {
 	flag.Parse()
-	datanode.NewDataNodeServer(*addr).Setup(context.Background())
+	consts.AuthTokenFile = *tokenFile
+	consts.AuthJWTSecretFile = *jwtSecretFile
+	consts.ServiceToken = *serviceToken
+	datanode.NewDataNodeServerWithOptions(*addr, datanode.Options{
+		Zone:     *zone,
+		Rack:     *rack,
+		Capacity: *capacity,
+		Reserved: *reserved,
+	}).Setup(context.Background())
 }
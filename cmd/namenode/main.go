@@ -3,15 +3,38 @@ package main
 import (
 	"context"
 	"flag"
+	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/namenode"
 )
 
 var (
-	addr      = flag.String("addr", "localhost:8000", "Node host address")
-	replicaID = flag.Uint64("replicaid", 1, "Replica ID to use")
+	addr           = flag.String("addr", "localhost:8000", "Node host address")
+	replicaID      = flag.Uint64("replicaid", 1, "Replica ID to use")
+	trashRetention = flag.Duration("trash-retention", consts.TrashRetention, "How long deleted paths stay in trash, 0 disables trash")
+	superUser      = flag.String("superuser", consts.SuperUser, "User bypassing permission checks")
+	superGroup     = flag.String("supergroup", consts.SuperGroup, "Group whose members bypass permission checks")
+	tokenFile      = flag.String("auth-token-file", consts.AuthTokenFile, "File of static tokens as token,user[,group...] lines")
+	jwtSecretFile  = flag.String("auth-jwt-secret-file", consts.AuthJWTSecretFile, "File of the secret verifying HS256 jwt bearer tokens")
+	serviceToken   = flag.String("token", consts.ServiceToken, "Token to call datanode servers with")
+	atimePrecision = flag.Duration("atime-precision", consts.AccessTimePrecision, "How stale the access time of a file may get, 0 disables it")
+	leaseDuration  = flag.Duration("lease-duration", consts.LeaseDuration, "How long a writer may go without renewing its lease")
+	blockSize      = flag.Uint64("block-size", consts.BlockSize, "Block size of files created without one")
+	placement      = flag.String("placement-policy", consts.PlacementPolicy, "Policy choosing the datanodes for replicas: least-loaded, random, capacity-weighted or consistent-hashing")
+	highWaterMark  = flag.Float64("high-water-mark", consts.HighWaterMark, "Fraction of its space a datanode may fill before it takes no more replicas")
 )
 
 func main() {
 	flag.Parse()
+	consts.TrashRetention = *trashRetention
+	consts.SuperUser = *superUser
+	consts.SuperGroup = *superGroup
+	consts.AuthTokenFile = *tokenFile
+	consts.AuthJWTSecretFile = *jwtSecretFile
+	consts.ServiceToken = *serviceToken
+	consts.AccessTimePrecision = *atimePrecision
+	consts.LeaseDuration = *leaseDuration
+	consts.BlockSize = *blockSize
+	consts.PlacementPolicy = *placement
+	consts.HighWaterMark = *highWaterMark
 	namenode.NewNameNodeServer(*addr, *replicaID).Setup(context.Background())
 }
@@ -2,23 +2,43 @@ package client
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/consts"
+	"simple-distributed-storage-system/src/erasure"
 	"simple-distributed-storage-system/src/protos"
 	"simple-distributed-storage-system/src/utils"
+	"sort"
+	"time"
 )
 
 type client struct {
 	blockSize uint64
 	readonly  bool
+	token     string // bearer token, empty if authentication is disabled
+	name      string // holder of the leases on the files being written
 	namenode  protos.NameNodeClient
 	conn      *utils.ConnHandler // for close
 }
 
-func (c *client) create(remotePath string, size uint64) error {
+// PutOptions chooses how a file is stored, the zero value leaves everything
+// to the defaults of the cluster
+type PutOptions struct {
+	Replication uint32 // replicas of each block
+	BlockSize   uint64 // size of each block but the last
+}
+
+// create makes a file, or a dir if remotePath ends with '/'
+func (c *client) create(remotePath string, size uint64, streaming bool, options PutOptions) error {
 	// create file
 	reply, err := c.namenode.Create(context.Background(), &protos.CreateRequest{
-		Path: remotePath,
-		Size: size,
+		Path:        remotePath,
+		Size:        size,
+		Streaming:   streaming,
+		ClientName:  c.name,
+		Replication: options.Replication,
+		BlockSize:   options.BlockSize,
 	})
 	if err != nil {
 		return err
@@ -30,18 +50,116 @@ func (c *client) create(remotePath string, size uint64) error {
 	return nil
 }
 
-func (c *client) open(remotePath string) (int, error) {
+// complete ends the construction of a file written by this client
+func (c *client) complete(remotePath string, size uint64) error {
+	_, err := c.namenode.Complete(context.Background(), &protos.CompleteRequest{
+		Path:       remotePath,
+		Size:       size,
+		ClientName: c.name,
+	})
+	return err
+}
+
+// keepLease renews the leases of this client in the background until stopped,
+// the namenode recovers files whose writers stop renewing
+func (c *client) keepLease() func() {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(consts.LeaseDuration / 3):
+				_, err := c.namenode.RenewLease(context.Background(), &protos.RenewLeaseRequest{ClientName: c.name})
+				if err != nil {
+					log.Warn(err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+func (c *client) open(remotePath string) (int, uint64, error) {
 	// open file
 	reply, err := c.namenode.Open(context.Background(), &protos.OpenRequest{
 		Path: remotePath,
 	})
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	// set block size
 	c.blockSize = reply.BlockSize
-	return int(reply.Blocks), nil
+	return int(reply.Blocks), reply.Size, nil
+}
+
+// readBlock reads the block at index of the file from any valid replica, and
+// returns the uuid of the block along
+func (c *client) readBlock(remotePath string, index int) ([]byte, []byte, error) {
+	// get block locs
+	reply, err := c.namenode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+		Path:  remotePath,
+		Index: uint64(index),
+		Type:  protos.FetchBlockAddrsRequestType_OP_GET,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if reply.ErasureCoding != nil {
+		data, err := c.readCells(reply)
+		return data, reply.Uuid, err
+	}
+
+	for _, addr := range reply.Addrs {
+		// connect to datanode and read data
+		datanode, conn, err := utils.ConnectToTargetDataNode(addr, c.token)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+
+		block, err := datanode.Read(context.Background(), &protos.ReadRequest{
+			Uuid:  reply.Uuid,
+			Token: reply.Token,
+		})
+		conn.Close()
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		return block.Data, reply.Uuid, nil
+	}
+	return nil, nil, errors.New("data corrupted")
+}
+
+// writeBlock writes the block at index of the file to every replica not yet
+// written, and tells the namenode which replicas made it
+func (c *client) writeBlock(remotePath string, index int, data []byte) error {
+	// get block locs
+	reply, err := c.namenode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+		Path:  remotePath,
+		Index: uint64(index),
+		Type:  protos.FetchBlockAddrsRequestType_OP_PUT,
+	})
+	if err != nil {
+		return err
+	}
+
+	validity := make(map[string]bool)
+	addrs, _ := c.writeReplicas(reply.Uuid, reply.Token, reply.Addrs, reply.Cells, reply.ErasureCoding, data)
+	for _, addr := range addrs {
+		validity[addr] = true
+	}
+
+	// notify validity
+	_, err = c.namenode.LocsValidityNotify(context.Background(), &protos.LocsValidityNotifyRequest{
+		Uuid:     reply.Uuid,
+		Validity: validity,
+	})
+	return err
 }
 
 func (c *client) testConnection() {
@@ -61,7 +179,7 @@ func (c *client) testConnection() {
 		}
 	}
 	if reconnect {
-		namenode, conn, err := utils.ConnectToNameNode(c.readonly)
+		namenode, conn, err := utils.ConnectToNameNode(c.readonly, c.token)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -69,3 +187,100 @@ func (c *client) testConnection() {
 		c.conn = conn
 	}
 }
+
+// writeReplicas writes a block to the datanodes with its block token and returns the addrs where it
+// made it, an erasure coded block is encoded and each addr gets the cell at
+// the same position in cells, the cells written are returned along
+func (c *client) writeReplicas(id []byte, token string, addrs []string, cells []uint32, policy *protos.ErasureCodingPolicy, data []byte) ([]string, []uint32) {
+	var encoded [][]byte
+	if policy != nil {
+		coder, err := erasure.NewCoder(int(policy.DataCells), int(policy.ParityCells))
+		if err != nil {
+			log.Warn(err)
+			return nil, nil
+		}
+		encoded = coder.Encode(data)
+	}
+
+	var written []string
+	var writtenCells []uint32
+	for i, addr := range addrs {
+		// connect to datanode and write data
+		datanode, conn, err := utils.ConnectToTargetDataNode(addr, c.token)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+
+		payload := data
+		if policy != nil {
+			payload = encoded[cells[i]]
+		}
+		_, err = datanode.Write(context.Background(), &protos.WriteRequest{
+			Uuid:  id,
+			Data:  payload,
+			Token: token,
+		})
+		conn.Close()
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		written = append(written, addr)
+		if policy != nil {
+			writtenCells = append(writtenCells, cells[i])
+		}
+	}
+	return written, writtenCells
+}
+
+// readCells reads enough cells of an erasure coded block to decode it, data
+// cells first since they need no decoding if all present
+func (c *client) readCells(reply *protos.FetchBlockAddrsReply) ([]byte, error) {
+	policy := reply.ErasureCoding
+	coder, err := erasure.NewCoder(int(policy.DataCells), int(policy.ParityCells))
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(reply.Addrs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return reply.Cells[order[i]] < reply.Cells[order[j]]
+	})
+
+	cells := make([][]byte, policy.DataCells+policy.ParityCells)
+	read := uint32(0)
+	for _, i := range order {
+		if read == policy.DataCells {
+			break
+		}
+		// connect to datanode and read data
+		datanode, conn, err := utils.ConnectToTargetDataNode(reply.Addrs[i], c.token)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+
+		cell, err := datanode.Read(context.Background(), &protos.ReadRequest{
+			Uuid:  reply.Uuid,
+			Token: reply.Token,
+		})
+		conn.Close()
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		cells[reply.Cells[i]] = cell.Data
+		read++
+	}
+
+	// rebuild the cells missing
+	err = coder.Reconstruct(cells)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%v, data corrupted", err))
+	}
+	return coder.Join(cells, reply.Size), nil
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	log "github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"simple-distributed-storage-system/src/protos"
 	"simple-distributed-storage-system/src/utils"
+	"time"
 )
 
 func (c *client) Get(remotePath, localPath string) error {
@@ -15,50 +16,21 @@ func (c *client) Get(remotePath, localPath string) error {
 
 	var data []byte
 
-	blocks, err := c.open(remotePath)
+	blocks, size, err := c.open(remotePath)
 	if err != nil {
 		return err
 	}
 
 	for i := 0; i < blocks; i++ {
-		// get block locs
-		reply, err := c.namenode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
-			Path:  remotePath,
-			Index: uint64(i),
-			Type:  protos.FetchBlockAddrsRequestType_OP_GET,
-		})
+		block, _, err := c.readBlock(remotePath, i)
 		if err != nil {
 			return err
 		}
-
-		success := false
-		for _, addr := range reply.Addrs {
-			// connect to datanode and read data
-			datanode, conn, err := utils.ConnectToTargetDataNode(addr)
-			if err != nil {
-				log.Warn(err)
-				continue
-			}
-
-			reply, err := datanode.Read(context.Background(), &protos.ReadRequest{
-				Uuid: reply.Uuid,
-			})
-
-			if err == nil {
-				success = true
-				data = append(data, reply.Data...)
-				conn.Close()
-				break
-			} else {
-				log.Warn(err)
-				conn.Close()
-				continue
-			}
-		}
-
-		if !success {
-			return errors.New("data corrupted")
-		}
+		data = append(data, block...)
+	}
+	// the last block may outlive a truncate
+	if uint64(len(data)) > size {
+		data = data[:size]
 	}
 
 	// write to local file
@@ -75,6 +47,17 @@ func (c *client) Get(remotePath, localPath string) error {
 }
 
 func (c *client) Put(localPath, remotePath string) error {
+	return c.PutWithOptions(localPath, remotePath, PutOptions{})
+}
+
+// PutWithReplication is Put keeping replication replicas of each block, 0
+// for the default of the cluster
+func (c *client) PutWithReplication(localPath, remotePath string, replication uint32) error {
+	return c.PutWithOptions(localPath, remotePath, PutOptions{Replication: replication})
+}
+
+// PutWithOptions is Put storing the file as options tell
+func (c *client) PutWithOptions(localPath, remotePath string, options PutOptions) error {
 	c.testConnection()
 
 	// read file
@@ -84,121 +67,316 @@ func (c *client) Put(localPath, remotePath string) error {
 	}
 
 	size := uint64(len(data))
-	err = c.create(remotePath, size)
+	err = c.create(remotePath, size, false, options)
 	if err != nil {
 		return err
 	}
+	stop := c.keepLease()
+	defer stop()
 
 	blocks := utils.CeilDiv(size, c.blockSize)
 	for i := 0; i < blocks; i++ {
-		// get block locs
-		reply, err := c.namenode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
-			Path:  remotePath,
-			Index: uint64(i),
-			Type:  protos.FetchBlockAddrsRequestType_OP_PUT,
-		})
+		err = c.writeBlock(remotePath, i, data[uint64(i)*c.blockSize:utils.Min(uint64(i+1)*c.blockSize, size)])
 		if err != nil {
 			return err
 		}
+	}
 
-		validity := make(map[string]bool)
+	return c.complete(remotePath, size)
+}
 
-		for _, addr := range reply.Addrs {
-			// connect to datanode and write data
-			success := true
-			datanode, conn, err := utils.ConnectToTargetDataNode(addr)
-			if err != nil {
-				log.Warn(err)
-				success = false
-				continue
-			}
+// PutStream writes everything read from r to a new remote file, blocks are
+// added one at a time so the size need not be known in advance
+func (c *client) PutStream(r io.Reader, remotePath string) error {
+	return c.PutStreamWithOptions(r, remotePath, PutOptions{})
+}
 
-			_, err = datanode.Write(context.Background(), &protos.WriteRequest{
-				Uuid: reply.Uuid,
-				Data: data[uint64(i)*c.blockSize : utils.Min(uint64(i+1)*c.blockSize, size)],
-			})
-			if err != nil {
-				log.Warn(err)
-				success = false
-			}
-			conn.Close()
+// PutStreamWithReplication is PutStream keeping replication replicas of each
+// block, 0 for the default of the cluster
+func (c *client) PutStreamWithReplication(r io.Reader, remotePath string, replication uint32) error {
+	return c.PutStreamWithOptions(r, remotePath, PutOptions{Replication: replication})
+}
 
-			if success {
-				validity[addr] = true
-			}
+// PutStreamWithOptions is PutStream storing the file as options tell
+func (c *client) PutStreamWithOptions(r io.Reader, remotePath string, options PutOptions) error {
+	c.testConnection()
+
+	err := c.create(remotePath, 0, true, options)
+	if err != nil {
+		return err
+	}
+	stop := c.keepLease()
+	defer stop()
+
+	size := uint64(0)
+	buf := make([]byte, c.blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if n == 0 {
+			break
 		}
 
-		// notify validity
-		_, err = c.namenode.LocsValidityNotify(context.Background(), &protos.LocsValidityNotifyRequest{
-			Uuid:     reply.Uuid,
-			Validity: validity,
+		reply, err := c.namenode.AddBlock(context.Background(), &protos.AddBlockRequest{
+			Path:       remotePath,
+			ClientName: c.name,
 		})
 		if err != nil {
 			return err
 		}
+		err = c.writeBlock(remotePath, int(reply.Index), buf[:n])
+		if err != nil {
+			return err
+		}
+		size += uint64(n)
+
+		if uint64(n) < c.blockSize {
+			break
+		}
 	}
 
-	return nil
+	return c.complete(remotePath, size)
 }
 
-func (c *client) Remove(remotePath string) error {
+// Append adds the content of the local file to the end of the remote file,
+// the partial last block is rewritten as a new block since other files and
+// snapshots may share it
+func (c *client) Append(localPath, remotePath string) error {
 	c.testConnection()
 
-	blocks, err := c.open(remotePath)
+	// read file
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	blocks, size, err := c.open(remotePath)
+	if err != nil {
+		return err
+	}
+
+	// the tail is read before the append replaces its block
+	var tail, tailId []byte
+	if size%c.blockSize != 0 {
+		tail, tailId, err = c.readBlock(remotePath, blocks-1)
+		if err != nil {
+			return err
+		}
+		tail = tail[:utils.Min(size%c.blockSize, uint64(len(tail)))]
+	}
+
+	reply, err := c.namenode.Append(context.Background(), &protos.AppendRequest{
+		Path:       remotePath,
+		Offset:     size,
+		Size:       uint64(len(data)),
+		ClientName: c.name,
+		Tail:       tailId,
+	})
 	if err != nil {
 		return err
 	}
+	stop := c.keepLease()
+	defer stop()
 
+	appended := uint64(len(data))
+	data = append(tail, data...)
+	blocks = utils.CeilDiv(uint64(len(data)), c.blockSize)
 	for i := 0; i < blocks; i++ {
-		// get block locs
-		reply, err := c.namenode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
-			Path:  remotePath,
-			Index: uint64(i),
-			Type:  protos.FetchBlockAddrsRequestType_OP_REMOVE,
-		})
+		err = c.writeBlock(remotePath, int(reply.Index)+i, data[uint64(i)*c.blockSize:utils.Min(uint64(i+1)*c.blockSize, uint64(len(data)))])
 		if err != nil {
 			return err
 		}
+	}
 
-		validity := make(map[string]bool)
+	return c.complete(remotePath, size+appended)
+}
 
-		for _, addr := range reply.Addrs {
-			// connect to datanode and remove data
-			success := true
-			datanode, conn, err := utils.ConnectToTargetDataNode(addr)
-			if err != nil {
-				log.Warn(err)
-				success = false
-				continue
-			}
+// WriteAt overwrites the remote file with data from offset on, the file grows
+// if data goes past its end, every block touched is written as a new block
+// and swapped in at once, so readers see either the old or the new blocks,
+// the namenode reclaims the new blocks if the write does not commit
+func (c *client) WriteAt(remotePath string, offset uint64, data []byte) error {
+	c.testConnection()
 
-			_, err = datanode.Remove(context.Background(), &protos.RemoveRequest{
-				Uuid: reply.Uuid,
-			})
-			if err != nil {
-				log.Warn(err)
-				success = false
-			}
-			conn.Close()
+	if len(data) == 0 {
+		return nil
+	}
+
+	reply, err := c.namenode.PrepareWrite(context.Background(), &protos.PrepareWriteRequest{
+		Path:   remotePath,
+		Offset: offset,
+		Size:   uint64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
 
-			if success {
-				validity[addr] = false
+	end := offset + uint64(len(data))
+	size := uint64(utils.Max(reply.FileSize, end))
+	var written []*protos.BlockAddrs
+	for i, block := range reply.Blocks {
+		index := reply.Index + uint64(i)
+		start := index * reply.BlockSize
+		buf := make([]byte, utils.Min(reply.BlockSize, size-start))
+
+		// keep the bytes of the old block the write does not cover
+		if start < reply.FileSize && (offset > start || end < start+uint64(len(buf))) {
+			old, _, err := c.readBlock(remotePath, int(index))
+			if err != nil {
+				return err
 			}
+			copy(buf, old[:utils.Min(uint64(len(old)), reply.FileSize-start)])
+		}
+		if offset > start {
+			copy(buf[offset-start:], data)
+		} else {
+			copy(buf, data[start-offset:])
 		}
 
-		// notify validity
-		_, err = c.namenode.LocsValidityNotify(context.Background(), &protos.LocsValidityNotifyRequest{
-			Uuid:     reply.Uuid,
-			Validity: validity,
-		})
-		if err != nil {
-			return err
+		addrs, cells := c.writeReplicas(block.Uuid, block.Token, block.Addrs, block.Cells, reply.ErasureCoding, buf)
+		if len(addrs) == 0 {
+			return errors.New("data corrupted")
 		}
+		written = append(written, &protos.BlockAddrs{Uuid: block.Uuid, Addrs: addrs, Cells: cells})
+	}
+
+	_, err = c.namenode.CommitWrite(context.Background(), &protos.CommitWriteRequest{
+		Path:     remotePath,
+		Offset:   offset,
+		Size:     uint64(len(data)),
+		FileSize: reply.FileSize,
+		Blocks:   written,
+	})
+	if err != nil {
+		return err
 	}
+	return nil
+}
 
+// Truncate cuts the remote file to size bytes
+func (c *client) Truncate(remotePath string, size uint64) error {
+	c.testConnection()
+
+	_, err := c.namenode.Truncate(context.Background(), &protos.TruncateRequest{
+		Path: remotePath,
+		Size: size,
+	})
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// Remove moves a file or an empty dir to trash
+func (c *client) Remove(remotePath string) error {
+	return c.Delete(remotePath, false, false)
+}
+
+// RemoveAll moves a file or a dir with all its descendants to trash
+func (c *client) RemoveAll(remotePath string) error {
+	return c.Delete(remotePath, true, false)
+}
+
+// Delete removes a path, blocks are reclaimed by the namenode once the path
+// leaves trash, or at once if skipTrash
+func (c *client) Delete(remotePath string, recursive, skipTrash bool) error {
+	c.testConnection()
+
+	_, err := c.namenode.Delete(context.Background(), &protos.DeleteRequest{
+		Path:      remotePath,
+		Recursive: recursive,
+		SkipTrash: skipTrash,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Restore moves a path in trash back to where it was deleted from
+func (c *client) Restore(trashPath string) error {
+	origin, ok := utils.TrashOrigin(trashPath)
+	if !ok {
+		return errors.New(fmt.Sprintf("path %v is not in trash", trashPath))
+	}
+	return c.Rename(trashPath, origin)
+}
+
+// Expunge permanently removes everything in the trash of the current user
+func (c *client) Expunge() error {
+	c.testConnection()
+
+	_, err := c.namenode.Expunge(context.Background(), &protos.ExpungeRequest{})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateSnapshot freezes the dir under name, or under a name made from the
+// current time if empty, and returns the name
+func (c *client) CreateSnapshot(remotePath, name string) (string, error) {
+	c.testConnection()
+
+	if !utils.IsDir(remotePath) {
+		return "", errors.New(fmt.Sprintf("path %v is not dir", remotePath))
+	}
+
+	reply, err := c.namenode.CreateSnapshot(context.Background(), &protos.CreateSnapshotRequest{
+		Path: remotePath,
+		Name: name,
+	})
+	if err != nil {
+		return "", err
+	}
+	return reply.Name, nil
+}
+
+func (c *client) DeleteSnapshot(remotePath, name string) error {
+	c.testConnection()
+
+	_, err := c.namenode.DeleteSnapshot(context.Background(), &protos.DeleteSnapshotRequest{
+		Path: remotePath,
+		Name: name,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListSnapshots returns the snapshots of the dir, oldest first
+func (c *client) ListSnapshots(remotePath string) ([]*protos.SnapshotInfo, error) {
+	c.testConnection()
+
+	reply, err := c.namenode.ListSnapshots(context.Background(), &protos.ListSnapshotsRequest{Path: remotePath})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Snapshots, nil
+}
+
+// SnapshotDiff returns the changes of the dir from snapshot from to snapshot
+// to, or to the current dir if to is empty
+func (c *client) SnapshotDiff(remotePath, from, to string) ([]*protos.SnapshotDiffEntry, error) {
+	c.testConnection()
+
+	reply, err := c.namenode.SnapshotDiff(context.Background(), &protos.SnapshotDiffRequest{
+		Path: remotePath,
+		From: from,
+		To:   to,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Entries, nil
+}
+
 func (c *client) Stat(remotePath string) (*protos.FileInfo, error) {
 	c.testConnection()
 
@@ -220,33 +398,292 @@ func (c *client) Mkdir(remotePath string) error {
 		return errors.New(fmt.Sprintf("path %v is not dir", remotePath))
 	}
 
-	err := c.create(remotePath, 0)
+	err := c.create(remotePath, 0, false, PutOptions{})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Rename moves a file or a dir with all its descendants, it fails if the dest exists
 func (c *client) Rename(remotePathSrc, remotePathDest string) error {
+	return c.rename(remotePathSrc, remotePathDest, false)
+}
+
+// RenameOverwrite moves a file or a dir, replacing the dest file or empty dir if it exists
+func (c *client) RenameOverwrite(remotePathSrc, remotePathDest string) error {
+	return c.rename(remotePathSrc, remotePathDest, true)
+}
+
+func (c *client) rename(remotePathSrc, remotePathDest string, overwrite bool) error {
 	c.testConnection()
 
-	_, err := c.namenode.Rename(context.Background(), &protos.RenameRequest{OldPath: remotePathSrc, NewPath: remotePathDest})
+	_, err := c.namenode.Rename(context.Background(), &protos.RenameRequest{
+		OldPath:   remotePathSrc,
+		NewPath:   remotePathDest,
+		Overwrite: overwrite,
+	})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *client) List(remotePath string) ([]*protos.FileInfo, error) {
+// Chmod sets the permission bits and the sticky bit of a file or a dir
+func (c *client) Chmod(remotePath string, mode uint32) error {
+	c.testConnection()
+
+	_, err := c.namenode.SetPermission(context.Background(), &protos.SetPermissionRequest{
+		Path: remotePath,
+		Mode: mode,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Chown sets the owner and the group of a file or a dir, empty ones are unchanged
+func (c *client) Chown(remotePath, owner, group string) error {
+	c.testConnection()
+
+	_, err := c.namenode.SetOwner(context.Background(), &protos.SetOwnerRequest{
+		Path:  remotePath,
+		Owner: owner,
+		Group: group,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetTimes sets the modification and access times of a file or a dir, zero
+// times are kept
+func (c *client) SetTimes(remotePath string, mtime, atime time.Time) error {
+	c.testConnection()
+
+	request := &protos.SetTimesRequest{Path: remotePath, Mtime: -1, Atime: -1}
+	if !mtime.IsZero() {
+		request.Mtime = mtime.UnixNano()
+	}
+	if !atime.IsZero() {
+		request.Atime = atime.UnixNano()
+	}
+	_, err := c.namenode.SetTimes(context.Background(), request)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetXAttr attaches the named value to a file or a dir, replacing the old value if any
+func (c *client) SetXAttr(remotePath, name string, value []byte) error {
+	c.testConnection()
+
+	_, err := c.namenode.SetXAttr(context.Background(), &protos.SetXAttrRequest{
+		Path:  remotePath,
+		Name:  name,
+		Value: value,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *client) GetXAttr(remotePath, name string) ([]byte, error) {
+	c.testConnection()
+
+	reply, err := c.namenode.GetXAttr(context.Background(), &protos.GetXAttrRequest{
+		Path: remotePath,
+		Name: name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Value, nil
+}
+
+// ListXAttrs returns the names of the xattrs in sorted order
+func (c *client) ListXAttrs(remotePath string) ([]string, error) {
+	c.testConnection()
+
+	reply, err := c.namenode.ListXAttrs(context.Background(), &protos.ListXAttrsRequest{Path: remotePath})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Names, nil
+}
+
+func (c *client) RemoveXAttr(remotePath, name string) error {
+	c.testConnection()
+
+	_, err := c.namenode.RemoveXAttr(context.Background(), &protos.RemoveXAttrRequest{
+		Path: remotePath,
+		Name: name,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Copy makes remotePathDest a copy of remotePathSrc inside the cluster, both
+// files share the blocks so no data goes through the client
+func (c *client) Copy(remotePathSrc, remotePathDest string) error {
+	c.testConnection()
+
+	_, err := c.namenode.Copy(context.Background(), &protos.CopyRequest{
+		SrcPath: remotePathSrc,
+		DstPath: remotePathDest,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetReplication changes the number of replicas of each block of the remote
+// file, the namenode adds or removes the replicas in the background
+func (c *client) SetReplication(remotePath string, replication uint32) error {
+	c.testConnection()
+
+	_, err := c.namenode.SetReplication(context.Background(), &protos.SetReplicationRequest{
+		Path:        remotePath,
+		Replication: replication,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetErasureCoding makes the files created under the remote dir afterwards
+// erasure coded with policy such as RS-6-3, an empty policy makes them take
+// the policy of the dir above again
+func (c *client) SetErasureCoding(remoteDir string, policy string) error {
+	c.testConnection()
+
+	_, err := c.namenode.SetErasureCoding(context.Background(), &protos.SetErasureCodingRequest{
+		Path:   remoteDir,
+		Policy: policy,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Concat appends remotePathSources in order to remotePathTarget and removes
+// them, the target takes over their blocks so no data is rewritten
+func (c *client) Concat(remotePathTarget string, remotePathSources []string) error {
+	c.testConnection()
+
+	_, err := c.namenode.Concat(context.Background(), &protos.ConcatRequest{
+		Target:  remotePathTarget,
+		Sources: remotePathSources,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateSymlink links remoteLinkPath to target, a relative target is resolved
+// against the dir of the link
+func (c *client) CreateSymlink(target, remoteLinkPath string) error {
+	c.testConnection()
+
+	_, err := c.namenode.CreateSymlink(context.Background(), &protos.CreateSymlinkRequest{
+		Target: target,
+		Path:   remoteLinkPath,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetQuota limits the files and the bytes with replicas under the dir, 0 for unlimited
+func (c *client) SetQuota(remotePath string, files, bytes int64) error {
 	c.testConnection()
 
 	if !utils.IsDir(remotePath) {
-		return nil, errors.New(fmt.Sprintf("path %v is not dir", remotePath))
+		return errors.New(fmt.Sprintf("path %v is not dir", remotePath))
 	}
 
-	reply, err := c.namenode.FetchFileInfo(context.Background(), &protos.FetchFileInfoRequest{Path: remotePath})
+	_, err := c.namenode.SetQuota(context.Background(), &protos.SetQuotaRequest{
+		Path:  remotePath,
+		Files: files,
+		Bytes: bytes,
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *client) ClearQuota(remotePath string) error {
+	return c.SetQuota(remotePath, 0, 0)
+}
+
+// Count returns the dirs, files and bytes under the path and the quota of the dir
+func (c *client) Count(remotePath string) (*protos.CountReply, error) {
+	c.testConnection()
+
+	reply, err := c.namenode.Count(context.Background(), &protos.CountRequest{Path: remotePath})
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// FetchMisplacedBlocks returns the blocks whose replicas share a rack or a
+// zone although the cluster has more of them
+func (c *client) FetchMisplacedBlocks() ([]*protos.MisplacedBlock, error) {
+	c.testConnection()
+
+	reply, err := c.namenode.FetchMisplacedBlocks(context.Background(), &protos.FetchMisplacedBlocksRequest{})
 	if err != nil {
 		return nil, err
 	}
-	return reply.Infos, nil
+	return reply.Blocks, nil
+}
+
+// List returns all the direct children of the dir in sorted order
+func (c *client) List(remotePath string) ([]*protos.FileInfo, error) {
+	var infos []*protos.FileInfo
+	cursor := ""
+	for {
+		page, next, err := c.ListPage(remotePath, cursor, 0)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, page...)
+		if next == "" {
+			return infos, nil
+		}
+		cursor = next
+	}
+}
+
+// ListPage returns at most limit children of the dir after cursor, and the
+// cursor for the next page, which is empty when the listing is complete
+func (c *client) ListPage(remotePath, cursor string, limit uint32) ([]*protos.FileInfo, string, error) {
+	c.testConnection()
+
+	if !utils.IsDir(remotePath) {
+		return nil, "", errors.New(fmt.Sprintf("path %v is not dir", remotePath))
+	}
+
+	reply, err := c.namenode.FetchFileInfo(context.Background(), &protos.FetchFileInfoRequest{
+		Path:   remotePath,
+		Cursor: cursor,
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return reply.Infos, reply.NextCursor, nil
 }
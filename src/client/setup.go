@@ -1,19 +1,30 @@
 package client
 
 import (
+	"fmt"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"simple-distributed-storage-system/src/utils"
 )
 
+// NewClient connects with the token in SDSS_TOKEN if any
 func NewClient(readonly bool) *client {
+	return NewClientWithToken(readonly, utils.CurrentToken())
+}
+
+// NewClientWithToken connects with the bearer token, which may be a static
+// token or a jwt
+func NewClientWithToken(readonly bool, token string) *client {
 	// connect to namenode
-	namenode, conn, err := utils.ConnectToNameNode(readonly)
+	namenode, conn, err := utils.ConnectToNameNode(readonly, token)
 	if err != nil {
 		log.Panic(err)
 	}
 	return &client{
 		blockSize: 0,
 		readonly:  readonly,
+		token:     token,
+		name:      fmt.Sprintf("%v-%v", utils.CurrentUser(), uuid.New()),
 		namenode:  namenode,
 		conn:      conn,
 	}
@@ -1,5 +1,7 @@
 package consts
 
+import "time"
+
 var (
 	NameNodeServerAddrs = []string{
 		"localhost:8000",
@@ -12,4 +14,32 @@ var (
 		"localhost:8900",
 	}
 	RaftPersistenceDataDir = "data"
+	// TrashRetention is how long deleted paths stay in trash, 0 disables trash
+	TrashRetention = 24 * time.Hour
+	// SuperUser and the members of SuperGroup bypass every permission check
+	SuperUser  = "root"
+	SuperGroup = "supergroup"
+	// authentication is disabled unless a token file or a jwt secret file is set
+	AuthTokenFile     = ""
+	AuthJWTSecretFile = ""
+	// ServiceToken authenticates the calls between servers as ServiceUser,
+	// who may do what only servers do besides the superuser
+	ServiceToken = ""
+	ServiceUser  = "sdss"
+	// BlockTokenLifetime is how long the block tokens namenode servers issue
+	// let clients read or write blocks on datanode servers
+	BlockTokenLifetime = 10 * time.Minute
+	// AccessTimePrecision is how stale the access time of a file may get, 0 disables it
+	AccessTimePrecision = time.Hour
+	// LeaseDuration is how long a writer keeps a file under construction
+	// without renewing its lease before the leader recovers the file
+	LeaseDuration = time.Minute
+	// BlockSize is the block size of files created without one
+	BlockSize uint64 = 40960
+	// PlacementPolicy chooses the datanodes for replicas, one of least-loaded,
+	// random, capacity-weighted and consistent-hashing
+	PlacementPolicy = "least-loaded"
+	// HighWaterMark is the fraction of its space a datanode may fill before
+	// it takes no more replicas
+	HighWaterMark = 0.9
 )
@@ -1,16 +1,92 @@
 package datanode
 
 import (
+	"os"
 	"simple-distributed-storage-system/src/protos"
+	"sync/atomic"
+	"syscall"
 )
 
 type datanodeServer struct {
 	protos.UnimplementedDataNodeServer
 	addr        string
-	blockSize   uint64
 	blockNumber uint64
+	// failure domains reported to namenode servers, replicas are spread
+	// across them
+	zone string
+	rack string
+	// bytes the datanode may hold, 0 for the size of its disk, and bytes of
+	// it kept for other uses than blocks
+	capacity uint64
+	reserved uint64
+	used     uint64 // bytes of the blocks, updated atomically
 }
 
 func (s *datanodeServer) localFileSystemRoot() string {
 	return "/tmp/gfs/chunks/" + s.addr + "/"
 }
+
+// diskUsage is the space of the datanode reported to namenode servers
+type diskUsage struct {
+	capacity uint64
+	used     uint64 // by blocks
+	free     uint64 // for more blocks
+	reserved uint64
+}
+
+// countUsed counts the bytes of the blocks left in the local file system by
+// earlier runs, later writes and removes keep the count
+func (s *datanodeServer) countUsed() error {
+	entries, err := os.ReadDir(s.localFileSystemRoot())
+	if err != nil {
+		return err
+	}
+	var used uint64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		used += uint64(info.Size())
+	}
+	atomic.StoreUint64(&s.used, used)
+	return nil
+}
+
+// sizeOf is the bytes of the block file, 0 if there is none
+func sizeOf(filepath string) uint64 {
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return 0
+	}
+	return uint64(info.Size())
+}
+
+// usage measures the blocks in the local file system against the disk
+// holding them
+func (s *datanodeServer) usage() (diskUsage, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(s.localFileSystemRoot(), &stat)
+	if err != nil {
+		return diskUsage{}, err
+	}
+
+	u := diskUsage{capacity: s.capacity, used: atomic.LoadUint64(&s.used), reserved: s.reserved}
+	if u.capacity == 0 {
+		u.capacity = stat.Blocks * uint64(stat.Bsize)
+	}
+
+	// the disk may fill up with other files before the capacity is used
+	u.free = stat.Bavail * uint64(stat.Bsize)
+	if u.free > u.reserved {
+		u.free -= u.reserved
+	} else {
+		u.free = 0
+	}
+	if u.used+u.reserved >= u.capacity {
+		u.free = 0
+	} else if u.free > u.capacity-u.reserved-u.used {
+		u.free = u.capacity - u.reserved - u.used
+	}
+	return u, nil
+}
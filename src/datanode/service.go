@@ -2,12 +2,41 @@ package datanode
 
 import (
 	"context"
+	"fmt"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"os"
+	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/protos"
+	"simple-distributed-storage-system/src/utils"
+	"sync/atomic"
 )
 
+// isService tells whether the caller is one of the servers, every caller is
+// unless the servers authenticate each other
+func isService(ctx context.Context) bool {
+	return consts.ServiceToken == "" || utils.UserFromContext(ctx) == consts.ServiceUser
+}
+
+// checkBlockToken lets the servers, and clients with a block token issued by
+// namenode servers, do op on the block
+func checkBlockToken(ctx context.Context, id uuid.UUID, token, op string) error {
+	if isService(ctx) {
+		return nil
+	}
+	bin, err := id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	err = utils.VerifyBlockToken([]byte(consts.ServiceToken), token, bin, op)
+	if err != nil {
+		return status.Error(codes.PermissionDenied, fmt.Sprintf("%v, cannot %v block %v", err, op, id))
+	}
+	return nil
+}
+
 // Read 读文件
 func (s *datanodeServer) Read(ctx context.Context, req *protos.ReadRequest) (*protos.ReadReply, error) {
 	id := uuid.New()
@@ -16,6 +45,11 @@ func (s *datanodeServer) Read(ctx context.Context, req *protos.ReadRequest) (*pr
 		log.Panic(err)
 	}
 
+	err = checkBlockToken(ctx, id, req.Token, utils.BlockRead)
+	if err != nil {
+		return nil, err
+	}
+
 	filepath := s.localFileSystemRoot() + id.String()
 	log.Infof("datanode server %v start to read the file: %v", s.addr, filepath)
 	data, err := os.ReadFile(filepath)
@@ -34,10 +68,17 @@ func (s *datanodeServer) Write(ctx context.Context, req *protos.WriteRequest) (*
 		log.Panic(err)
 	}
 
+	err = checkBlockToken(ctx, id, req.Token, utils.BlockWrite)
+	if err != nil {
+		return nil, err
+	}
+
 	data := req.Data
 	filepath := s.localFileSystemRoot() + id.String()
 	log.Infof("datanode server %v start to write the file: %v", s.addr, filepath)
 
+	// a block written again replaces the old data
+	old := sizeOf(filepath)
 	file, err := os.Create(filepath)
 	if err != nil {
 		log.Panic(err)
@@ -49,12 +90,24 @@ func (s *datanodeServer) Write(ctx context.Context, req *protos.WriteRequest) (*
 		log.Panic(err)
 	}
 	s.blockNumber++
+	atomic.AddUint64(&s.used, uint64(len(data))-old)
 	return &protos.WriteReply{}, nil
 }
 
 // HeartBeat 返回心跳包
 func (s *datanodeServer) HeartBeat(ctx context.Context, in *protos.HeartBeatRequest) (*protos.HeartBeatReply, error) {
-	return &protos.HeartBeatReply{BlockNumber: s.blockNumber}, nil
+	// the space goes unreported rather than the datanode taken for dead
+	u, err := s.usage()
+	if err != nil {
+		log.Warn(err)
+	}
+	return &protos.HeartBeatReply{
+		BlockNumber: s.blockNumber,
+		Capacity:    u.capacity,
+		Used:        u.used,
+		Free:        u.free,
+		Reserved:    u.reserved,
+	}, nil
 }
 
 // Remove 删除文件
@@ -65,13 +118,24 @@ func (s *datanodeServer) Remove(ctx context.Context, req *protos.RemoveRequest)
 		log.Panic(err)
 	}
 
+	// blocks are reclaimed by namenode servers only
+	if !isService(ctx) {
+		return nil, status.Error(codes.PermissionDenied, fmt.Sprintf("only the servers can remove block %v", id))
+	}
+
 	filepath := s.localFileSystemRoot() + id.String()
 	log.Infof("datanode server %v start to remove the file: %v", s.addr, filepath)
 
+	size := sizeOf(filepath)
 	err = os.Remove(filepath)
+	if os.IsNotExist(err) {
+		// never written, namenode servers reclaim such replicas too
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("block %v not found", id))
+	}
 	if err != nil {
 		log.Panic(err)
 	}
 	s.blockNumber--
+	atomic.AddUint64(&s.used, -size)
 	return &protos.RemoveReply{}, nil
 }
@@ -8,15 +8,40 @@ import (
 	"google.golang.org/grpc"
 	"net"
 	"os"
+	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/protos"
 	"simple-distributed-storage-system/src/utils"
 	"time"
 )
 
 func NewDataNodeServer(addr string) *datanodeServer {
+	return NewDataNodeServerWithTopology(addr, "", "")
+}
+
+// NewDataNodeServerWithTopology makes a datanode server in the rack of the
+// zone, namenode servers put those left empty in a default one
+func NewDataNodeServerWithTopology(addr, zone, rack string) *datanodeServer {
+	return NewDataNodeServerWithOptions(addr, Options{Zone: zone, Rack: rack})
+}
+
+// Options of a datanode server
+type Options struct {
+	Zone string
+	Rack string
+	// Capacity is the bytes the datanode may hold, 0 for the size of its disk
+	Capacity uint64
+	// Reserved is the bytes of the capacity kept for other uses than blocks
+	Reserved uint64
+}
+
+func NewDataNodeServerWithOptions(addr string, options Options) *datanodeServer {
 	return &datanodeServer{
 		addr:        addr,
 		blockNumber: 0,
+		zone:        options.Zone,
+		rack:        options.Rack,
+		capacity:    options.Capacity,
+		reserved:    options.Reserved,
 	}
 }
 
@@ -26,6 +51,10 @@ func (s *datanodeServer) Setup(ctx context.Context) {
 	if err != nil {
 		log.Panic(err)
 	}
+	err = s.countUsed()
+	if err != nil {
+		log.Panic(err)
+	}
 	// zipkin
 	tracer, r, err := utils.NewZipkinTracer(utils.ZIPKIN_HTTP_ENDPOINT, fmt.Sprintf("DataNode-Server-%s", s.addr), s.addr)
 	defer r.Close()
@@ -39,7 +68,12 @@ func (s *datanodeServer) Setup(ctx context.Context) {
 		log.Panic(err)
 	}
 
-	server := grpc.NewServer(grpc.StatsHandler(zipkingrpc.NewServerHandler(tracer)))
+	auth, err := utils.NewAuthenticator()
+	if err != nil {
+		log.Panic(err)
+	}
+	server := grpc.NewServer(grpc.StatsHandler(zipkingrpc.NewServerHandler(tracer)), grpc.UnaryInterceptor(utils.AuthInterceptor(auth)),
+		grpc.MaxRecvMsgSize(utils.MaxMessageSize), grpc.MaxSendMsgSize(utils.MaxMessageSize))
 	protos.RegisterDataNodeServer(server, s)
 
 	go func() {
@@ -52,11 +86,15 @@ func (s *datanodeServer) Setup(ctx context.Context) {
 	// connect to namenode
 retry:
 	retries := 0
-	namenode, conn, err := utils.ConnectToNameNode(false)
+	namenode, conn, err := utils.ConnectToNameNode(false, consts.ServiceToken)
 	if err != nil {
 		log.Panic(err)
 	}
-	reply, err := namenode.RegisterDataNode(context.Background(), &protos.RegisterDataNodeRequest{Address: s.addr})
+	_, err = namenode.RegisterDataNode(context.Background(), &protos.RegisterDataNodeRequest{
+		Address: s.addr,
+		Zone:    s.zone,
+		Rack:    s.rack,
+	})
 	if err != nil {
 		conn.Close()
 		log.Warn(err)
@@ -68,9 +106,6 @@ retry:
 		goto retry
 	}
 
-	// set block size
-	s.blockSize = reply.BlockSize
-
 	// blocked here
 	select {
 	case <-ctx.Done():
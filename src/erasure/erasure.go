@@ -0,0 +1,139 @@
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxCells bounds the data and parity cells of a block group, every cell
+// needs its own element of GF(2^8)
+const MaxCells = 256
+
+// Coder splits a block group into data cells and computes parity cells with
+// Reed-Solomon codes, any data cells of them rebuild the others
+type Coder struct {
+	data   int
+	parity int
+	// rows of the encoding matrix for the parity cells, the rows for the data
+	// cells form the identity matrix
+	rows [][]byte
+}
+
+func NewCoder(data, parity int) (*Coder, error) {
+	if data < 1 || parity < 1 || data+parity > MaxCells {
+		return nil, errors.New(fmt.Sprintf("cannot code %v data cells with %v parity cells", data, parity))
+	}
+
+	// a cauchy matrix keeps every square submatrix of the encoding matrix
+	// invertible, so any data cells are enough to decode
+	rows := make([][]byte, parity)
+	for i := range rows {
+		rows[i] = make([]byte, data)
+		for j := range rows[i] {
+			rows[i][j] = inv(byte(data+i) ^ byte(j))
+		}
+	}
+	return &Coder{data: data, parity: parity, rows: rows}, nil
+}
+
+// row returns the row of the encoding matrix for the cell at index
+func (c *Coder) row(index int) []byte {
+	if index >= c.data {
+		return c.rows[index-c.data]
+	}
+	row := make([]byte, c.data)
+	row[index] = 1
+	return row
+}
+
+// Encode splits data into data cells padded with zeros and appends the
+// parity cells
+func (c *Coder) Encode(data []byte) [][]byte {
+	size := (len(data) + c.data - 1) / c.data
+	cells := make([][]byte, c.data+c.parity)
+	for i := range cells {
+		cells[i] = make([]byte, size)
+		if i < c.data && i*size < len(data) {
+			copy(cells[i], data[i*size:])
+		}
+	}
+	for i := c.data; i < len(cells); i++ {
+		c.combine(cells[i], c.rows[i-c.data], cells[:c.data])
+	}
+	return cells
+}
+
+// Reconstruct fills in the cells which are nil, at least data cells of the
+// same size must be present
+func (c *Coder) Reconstruct(cells [][]byte) error {
+	if len(cells) != c.data+c.parity {
+		return errors.New(fmt.Sprintf("block group has %v cells, not %v", len(cells), c.data+c.parity))
+	}
+
+	// decode from the first data cells present
+	var present []int
+	for i, cell := range cells {
+		if cell == nil {
+			continue
+		}
+		if len(present) != 0 && len(cell) != len(cells[present[0]]) {
+			return errors.New(fmt.Sprintf("cell #%v has %v bytes, not %v", i, len(cell), len(cells[present[0]])))
+		}
+		if len(present) < c.data {
+			present = append(present, i)
+		}
+	}
+	if len(present) < c.data {
+		return errors.New(fmt.Sprintf("only %v cells are left, %v are needed", len(present), c.data))
+	}
+	size := len(cells[present[0]])
+
+	matrix := make([][]byte, c.data)
+	sources := make([][]byte, c.data)
+	for i, index := range present {
+		matrix[i] = c.row(index)
+		sources[i] = cells[index]
+	}
+	decode, err := invert(matrix)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < c.data; i++ {
+		if cells[i] == nil {
+			cells[i] = make([]byte, size)
+			c.combine(cells[i], decode[i], sources)
+		}
+	}
+	for i := c.data; i < len(cells); i++ {
+		if cells[i] == nil {
+			cells[i] = make([]byte, size)
+			c.combine(cells[i], c.rows[i-c.data], cells[:c.data])
+		}
+	}
+	return nil
+}
+
+// Join concatenates the data cells and cuts the padding off at size
+func (c *Coder) Join(cells [][]byte, size uint64) []byte {
+	var data []byte
+	for _, cell := range cells[:c.data] {
+		data = append(data, cell...)
+	}
+	if uint64(len(data)) > size {
+		data = data[:size]
+	}
+	return data
+}
+
+// combine sets dst to the sum of the sources weighted by coefficients
+func (c *Coder) combine(dst []byte, coefficients []byte, sources [][]byte) {
+	for j, coefficient := range coefficients {
+		if coefficient == 0 {
+			continue
+		}
+		for b, value := range sources[j] {
+			dst[b] ^= mul(coefficient, value)
+		}
+	}
+}
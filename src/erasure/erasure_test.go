@@ -0,0 +1,101 @@
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// drops calls f with every set of at most max of the n cells
+func drops(n, max int, f func(dropped []int)) {
+	var walk func(start int, dropped []int)
+	walk = func(start int, dropped []int) {
+		f(dropped)
+		if len(dropped) == max {
+			return
+		}
+		for i := start; i < n; i++ {
+			walk(i+1, append(dropped, i))
+		}
+	}
+	walk(0, nil)
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, policy := range []struct{ data, parity int }{{1, 1}, {2, 1}, {3, 2}, {4, 2}, {6, 3}, {10, 4}} {
+		coder, err := NewCoder(policy.data, policy.parity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int{1, policy.data, 1000, 4096 + 3} {
+			data := make([]byte, size)
+			r.Read(data)
+			cells := coder.Encode(data)
+			if len(cells) != policy.data+policy.parity {
+				t.Fatalf("%v+%v: encoded %v cells", policy.data, policy.parity, len(cells))
+			}
+
+			drops(len(cells), policy.parity, func(dropped []int) {
+				left := make([][]byte, len(cells))
+				for i, cell := range cells {
+					left[i] = append([]byte(nil), cell...)
+				}
+				for _, i := range dropped {
+					left[i] = nil
+				}
+
+				err := coder.Reconstruct(left)
+				if err != nil {
+					t.Fatalf("%v+%v: dropping %v of %v bytes: %v", policy.data, policy.parity, dropped, size, err)
+				}
+				for i := range cells {
+					if !bytes.Equal(left[i], cells[i]) {
+						t.Fatalf("%v+%v: dropping %v of %v bytes rebuilds cell #%v wrong", policy.data, policy.parity, dropped, size, i)
+					}
+				}
+				if !bytes.Equal(coder.Join(left, uint64(size)), data) {
+					t.Fatalf("%v+%v: dropping %v of %v bytes joins wrong data", policy.data, policy.parity, dropped, size)
+				}
+			})
+		}
+	}
+}
+
+func TestNewCoder(t *testing.T) {
+	for _, policy := range []struct{ data, parity int }{{0, 1}, {1, 0}, {MaxCells, 1}} {
+		_, err := NewCoder(policy.data, policy.parity)
+		if err == nil {
+			t.Fatalf("%v+%v: should be error", policy.data, policy.parity)
+		}
+	}
+}
+
+func TestReconstructErrors(t *testing.T) {
+	coder, err := NewCoder(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 300)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	// too few cells
+	cells := coder.Encode(data)
+	cells[0], cells[2], cells[4] = nil, nil, nil
+	if coder.Reconstruct(cells) == nil {
+		t.Fatal("3 of 5 cells dropped, should be error")
+	}
+
+	// mismatched cell sizes
+	cells = coder.Encode(data)
+	cells[1] = cells[1][:len(cells[1])-1]
+	if coder.Reconstruct(cells) == nil {
+		t.Fatal("cells of different sizes, should be error")
+	}
+
+	// not a block group of the coder
+	cells = coder.Encode(data)
+	if coder.Reconstruct(cells[:4]) == nil {
+		t.Fatal("4 cells for 3+2, should be error")
+	}
+}
@@ -0,0 +1,78 @@
+package erasure
+
+import "errors"
+
+// arithmetic in GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1,
+// addition is xor
+var (
+	expTable [2 * 255]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// inv returns the multiplicative inverse of a, which must not be 0
+func inv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// invert returns the inverse of the square matrix by gauss-jordan elimination
+func invert(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+	// work on [matrix | identity] until the left half is the identity
+	work := make([][]byte, n)
+	for i := range work {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], matrix[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := inv(work[col][col])
+		for j := range work[col] {
+			work[col][j] = mul(work[col][j], scale)
+		}
+		for i := 0; i < n; i++ {
+			factor := work[i][col]
+			if i == col || factor == 0 {
+				continue
+			}
+			for j := range work[i] {
+				work[i][j] ^= mul(factor, work[col][j])
+			}
+		}
+	}
+
+	inverse := make([][]byte, n)
+	for i := range inverse {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
@@ -0,0 +1,95 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"simple-distributed-storage-system/src/utils"
+)
+
+// checkAppend returns the file to append to, which must still have the size
+// and the partial last block the client has seen
+func (st *namenodeState) checkAppend(path string, offset, size uint64, tail uuid.UUID) (*fileInfo, error) {
+	if utils.IsDir(path) {
+		return nil, errors.New(fmt.Sprintf("cannot append to dir %v", path))
+	}
+	if size == 0 {
+		return nil, errors.New(fmt.Sprintf("nothing to append to %v", path))
+	}
+	info, _, err := st.resolve(path, resolveOptions{follow: true})
+	if err != nil {
+		return nil, err
+	}
+	if info.UnderConstruction {
+		return nil, underConstruction(path)
+	}
+	if info.Size != offset {
+		return nil, errors.New(fmt.Sprintf("file %v has %v bytes, not %v, it has changed", path, info.Size, offset))
+	}
+	// the tail is rewritten from what the holder has read of it
+	if info.Size%info.blockSize() != 0 && info.Ids[len(info.Ids)-1] != tail {
+		return nil, errors.New(fmt.Sprintf("last block of %v is %v, not %v, it has changed", path, info.Ids[len(info.Ids)-1], tail))
+	}
+
+	err = st.checkQuota(st.Inodes[info.Parent], usage{Bytes: spaceOf(info, info.Size+size) - spaceConsumed(info)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// applyAppend holds the new blocks of the file aside, the first of them
+// replaces the partial last block, the file is under construction until the
+// holder completes it and readers see the old blocks until then
+func (st *namenodeState) applyAppend(c *appendCommand) error {
+	info, err := st.checkAppend(c.Path, c.Offset, c.Size, c.Tail)
+	if err != nil {
+		return err
+	}
+
+	st.rewant(info, func() {
+		info.UnderConstruction = true
+		info.Holder = c.Holder
+	})
+	info.Appending = c.Ids
+	st.addBlocks(info, c.Ids, c.Locs, false)
+	return nil
+}
+
+// appendIndex is the index of the first block an append in progress replaces
+func (info *fileInfo) appendIndex() int {
+	return int(info.Size / info.blockSize())
+}
+
+// writing returns the blocks the file under construction will have once
+// completed, which are the blocks being written to
+func (info *fileInfo) writing() []uuid.UUID {
+	if len(info.Appending) == 0 {
+		return info.Ids
+	}
+	index := info.appendIndex()
+	return append(info.Ids[:index:index], info.Appending...)
+}
+
+// swapAppended puts the blocks of the completed append in place of the ones
+// they replace, which other files and snapshots may still refer to
+func (st *namenodeState) swapAppended(info *fileInfo) {
+	if len(info.Appending) == 0 {
+		return
+	}
+	ids := info.writing()
+	for _, id := range info.Ids[info.appendIndex():] {
+		st.releaseBlock(info, id)
+	}
+	info.Ids = ids
+	info.Appending = nil
+}
+
+// dropAppended gives up the blocks of an append in progress, the file keeps
+// its old blocks
+func (st *namenodeState) dropAppended(info *fileInfo) {
+	for _, id := range info.Appending {
+		st.releaseBlock(info, id)
+	}
+	info.Appending = nil
+}
@@ -0,0 +1,934 @@
+package namenode
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/utils"
+)
+
+// commandVersion must be bumped whenever the encoding or the semantics of an
+// existing command changes, replicas refuse to apply commands from the future
+const commandVersion uint32 = 9
+
+type commandType uint32
+
+const (
+	commandCreateFile commandType = iota + 1
+	commandRename
+	commandRegisterDataNode
+	commandRemoveDataNode
+	commandMigrateReplicas
+	commandSetReplicaValidity
+	commandDelete
+	commandReclaimBlocks
+	commandMoveToTrash
+	commandCreateSnapshot
+	commandDeleteSnapshot
+	commandSetPermission
+	commandSetOwner
+	commandSetQuota
+	commandSetTimes
+	commandSetXAttr
+	commandRemoveXAttr
+	commandCreateSymlink
+	commandCopy
+	commandAppend
+	commandWrite
+	commandTruncate
+	commandAddBlock
+	commandComplete
+	commandRecoverLease
+	commandConcat
+	commandSetReplication
+	commandReplicate
+	commandSetErasureCoding
+	commandPrepareWrite
+	commandAbortWrite
+	commandSetAccessTimes
+)
+
+func (t commandType) String() string {
+	switch t {
+	case commandCreateFile:
+		return "CreateFile"
+	case commandRename:
+		return "Rename"
+	case commandRegisterDataNode:
+		return "RegisterDataNode"
+	case commandRemoveDataNode:
+		return "RemoveDataNode"
+	case commandMigrateReplicas:
+		return "MigrateReplicas"
+	case commandSetReplicaValidity:
+		return "SetReplicaValidity"
+	case commandDelete:
+		return "Delete"
+	case commandReclaimBlocks:
+		return "ReclaimBlocks"
+	case commandMoveToTrash:
+		return "MoveToTrash"
+	case commandCreateSnapshot:
+		return "CreateSnapshot"
+	case commandDeleteSnapshot:
+		return "DeleteSnapshot"
+	case commandSetPermission:
+		return "SetPermission"
+	case commandSetOwner:
+		return "SetOwner"
+	case commandSetQuota:
+		return "SetQuota"
+	case commandSetTimes:
+		return "SetTimes"
+	case commandSetXAttr:
+		return "SetXAttr"
+	case commandRemoveXAttr:
+		return "RemoveXAttr"
+	case commandCreateSymlink:
+		return "CreateSymlink"
+	case commandCopy:
+		return "Copy"
+	case commandAppend:
+		return "Append"
+	case commandWrite:
+		return "Write"
+	case commandTruncate:
+		return "Truncate"
+	case commandAddBlock:
+		return "AddBlock"
+	case commandComplete:
+		return "Complete"
+	case commandRecoverLease:
+		return "RecoverLease"
+	case commandConcat:
+		return "Concat"
+	case commandSetReplication:
+		return "SetReplication"
+	case commandReplicate:
+		return "Replicate"
+	case commandSetErasureCoding:
+		return "SetErasureCoding"
+	case commandPrepareWrite:
+		return "PrepareWrite"
+	case commandAbortWrite:
+		return "AbortWrite"
+	case commandSetAccessTimes:
+		return "SetAccessTimes"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint32(t))
+	}
+}
+
+// results of applying a command, carried in sm.Result.Value
+const (
+	resultApplied uint64 = iota
+	resultRejected
+)
+
+// command is the unit replicated through raft, only the payload matching Type is set
+type command struct {
+	Version uint32
+	Type    commandType
+
+	CreateFile         *createFileCommand
+	Rename             *renameCommand
+	RegisterDataNode   *registerDataNodeCommand
+	RemoveDataNode     *removeDataNodeCommand
+	MigrateReplicas    *migrateReplicasCommand
+	SetReplicaValidity *setReplicaValidityCommand
+	Delete             *deleteCommand
+	ReclaimBlocks      *reclaimBlocksCommand
+	MoveToTrash        *moveToTrashCommand
+	CreateSnapshot     *createSnapshotCommand
+	DeleteSnapshot     *deleteSnapshotCommand
+	SetPermission      *setPermissionCommand
+	SetOwner           *setOwnerCommand
+	SetQuota           *setQuotaCommand
+	SetTimes           *setTimesCommand
+	SetXAttr           *setXAttrCommand
+	RemoveXAttr        *removeXAttrCommand
+	CreateSymlink      *createSymlinkCommand
+	Copy               *copyCommand
+	Append             *appendCommand
+	Write              *writeCommand
+	Truncate           *truncateCommand
+	AddBlock           *addBlockCommand
+	Complete           *completeCommand
+	RecoverLease       *recoverLeaseCommand
+	Concat             *concatCommand
+	SetReplication     *setReplicationCommand
+	Replicate          *replicateCommand
+	SetErasureCoding   *setErasureCodingCommand
+	PrepareWrite       *prepareWriteCommand
+	AbortWrite         *abortWriteCommand
+	SetAccessTimes     *setAccessTimesCommand
+}
+
+type createFileCommand struct {
+	Path  string
+	Owner string
+	Time  int64 // unix nanoseconds after the leader clock
+	Size  uint64
+	Ids   []uuid.UUID
+	Locs  [][]int // locs for each uuid in Ids
+
+	Replication int       // 0 for dir, symlink and erasure coded file
+	BlockSize   uint64    // 0 for dir and symlink
+	EC          *ecPolicy // Locs hold the cells in order if set
+
+	// files are completed by the holder of the lease, see completeCommand
+	UnderConstruction bool
+	Holder            string
+}
+
+type renameCommand struct {
+	OldPath   string
+	NewPath   string
+	Overwrite bool
+	Time      int64
+}
+
+type registerDataNodeCommand struct {
+	Loc      int
+	Addr     string
+	Topology topology
+}
+
+type replicaMove struct {
+	Id   uuid.UUID
+	From int
+	To   int
+}
+
+type removeDataNodeCommand struct {
+	Loc   int
+	Moves []replicaMove
+}
+
+type migrateReplicasCommand struct {
+	Moves []replicaMove
+}
+
+type setReplicaValidityCommand struct {
+	Id       uuid.UUID
+	Validity map[int]bool
+}
+
+type deleteCommand struct {
+	Path      string
+	Recursive bool
+	Time      int64
+}
+
+type replicaRef struct {
+	Id   uuid.UUID
+	Loc  int
+	Cell int // for erasure coded blocks only
+}
+
+type reclaimBlocksCommand struct {
+	Replicas []replicaRef
+}
+
+type moveToTrashCommand struct {
+	Path       string
+	Recursive  bool
+	User       string
+	Checkpoint string // name of the trash dir, decided by the leader clock
+	Time       int64
+}
+
+type createSnapshotCommand struct {
+	Path    string
+	Name    string
+	Created int64
+}
+
+type deleteSnapshotCommand struct {
+	Path string
+	Name string
+}
+
+type setPermissionCommand struct {
+	Path string
+	Mode uint32
+}
+
+type setOwnerCommand struct {
+	Path  string
+	Owner string // unchanged if empty
+	Group string // unchanged if empty
+}
+
+// setTimesCommand keeps the times which are negative
+type setTimesCommand struct {
+	Path  string
+	Mtime int64
+	Atime int64
+}
+
+// setAccessTimesCommand records the reads of files batched by the leader
+type setAccessTimesCommand struct {
+	Atimes map[string]int64 // path -> access time
+}
+
+type setXAttrCommand struct {
+	Path  string
+	Name  string
+	Value []byte
+}
+
+type removeXAttrCommand struct {
+	Path string
+	Name string
+}
+
+// appendCommand replaces the blocks from the one holding Offset with Ids
+type appendCommand struct {
+	Path   string
+	Holder string
+	Time   int64
+	Offset uint64    // size of the file before appending
+	Size   uint64    // bytes appended
+	Tail   uuid.UUID // the partial last block the holder has read, uuid.Nil if none
+	Ids    []uuid.UUID
+	Locs   [][]int
+}
+
+// prepareWriteCommand issues Ids for the blocks covering [Offset, Offset+Size)
+// of the file, to be written at Locs and committed by a writeCommand
+type prepareWriteCommand struct {
+	Path     string
+	Time     int64
+	Offset   uint64
+	Size     uint64
+	FileSize uint64
+	Ids      []uuid.UUID
+	Locs     [][]int
+}
+
+// writeCommand replaces the blocks covering [Offset, Offset+Size) of the file
+// with Ids, whose replicas at Locs have been written
+type writeCommand struct {
+	Path     string
+	Time     int64
+	Offset   uint64
+	Size     uint64
+	FileSize uint64 // size of the file the write was prepared against
+	Ids      []uuid.UUID
+	Locs     [][]int
+}
+
+// abortWriteCommand gives up the prepared blocks of a write which is rejected
+// or has not been committed in time
+type abortWriteCommand struct {
+	Path string // only the blocks prepared for the file are given up if set
+	Ids  []uuid.UUID
+}
+
+// truncateCommand drops the bytes of the file past Size, Ids holds the copy
+// of a shortened last block written at Locs
+type truncateCommand struct {
+	Path string
+	Time int64
+	Size uint64
+	Ids  []uuid.UUID
+	Locs [][]int
+}
+
+type addBlockCommand struct {
+	Path   string
+	Holder string
+	Id     uuid.UUID
+	Locs   []int
+}
+
+type completeCommand struct {
+	Path   string
+	Holder string
+	Time   int64
+	Size   uint64
+}
+
+// recoverLeaseCommand completes a file whose writer is gone with the first
+// Keep blocks
+type recoverLeaseCommand struct {
+	Path string
+	Time int64
+	Keep int
+	Size uint64
+}
+
+type setReplicationCommand struct {
+	Path        string
+	Replication int
+}
+
+// replicateCommand records the replicas the leader has copied to fix the
+// blocks having fewer replicas than wanted, and the extra replicas to drop
+type replicateCommand struct {
+	Added   []replicaRef
+	Dropped []replicaRef
+}
+
+// setErasureCodingCommand clears the policy of the dir if EC is nil
+type setErasureCodingCommand struct {
+	Path string
+	EC   *ecPolicy
+}
+
+// concatCommand moves the blocks of Sources in order to the end of Target
+type concatCommand struct {
+	Target  string
+	Sources []string
+	Time    int64
+}
+
+type copyCommand struct {
+	SrcPath string
+	DstPath string
+	Owner   string
+	Time    int64
+}
+
+type createSymlinkCommand struct {
+	Path   string
+	Target string
+	Owner  string
+	Time   int64
+}
+
+// setQuotaCommand clears the quota if both limits are 0
+type setQuotaCommand struct {
+	Path  string
+	Files int64
+	Bytes int64
+}
+
+func newCreateFileCommand(c *createFileCommand) *command {
+	return &command{Version: commandVersion, Type: commandCreateFile, CreateFile: c}
+}
+
+func newRenameCommand(c *renameCommand) *command {
+	return &command{Version: commandVersion, Type: commandRename, Rename: c}
+}
+
+func newRegisterDataNodeCommand(c *registerDataNodeCommand) *command {
+	return &command{Version: commandVersion, Type: commandRegisterDataNode, RegisterDataNode: c}
+}
+
+func newRemoveDataNodeCommand(c *removeDataNodeCommand) *command {
+	return &command{Version: commandVersion, Type: commandRemoveDataNode, RemoveDataNode: c}
+}
+
+func newMigrateReplicasCommand(c *migrateReplicasCommand) *command {
+	return &command{Version: commandVersion, Type: commandMigrateReplicas, MigrateReplicas: c}
+}
+
+func newSetReplicaValidityCommand(c *setReplicaValidityCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetReplicaValidity, SetReplicaValidity: c}
+}
+
+func newDeleteCommand(c *deleteCommand) *command {
+	return &command{Version: commandVersion, Type: commandDelete, Delete: c}
+}
+
+func newReclaimBlocksCommand(c *reclaimBlocksCommand) *command {
+	return &command{Version: commandVersion, Type: commandReclaimBlocks, ReclaimBlocks: c}
+}
+
+func newMoveToTrashCommand(c *moveToTrashCommand) *command {
+	return &command{Version: commandVersion, Type: commandMoveToTrash, MoveToTrash: c}
+}
+
+func newCreateSnapshotCommand(c *createSnapshotCommand) *command {
+	return &command{Version: commandVersion, Type: commandCreateSnapshot, CreateSnapshot: c}
+}
+
+func newDeleteSnapshotCommand(c *deleteSnapshotCommand) *command {
+	return &command{Version: commandVersion, Type: commandDeleteSnapshot, DeleteSnapshot: c}
+}
+
+func newSetPermissionCommand(c *setPermissionCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetPermission, SetPermission: c}
+}
+
+func newSetOwnerCommand(c *setOwnerCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetOwner, SetOwner: c}
+}
+
+func newSetQuotaCommand(c *setQuotaCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetQuota, SetQuota: c}
+}
+
+func newSetTimesCommand(c *setTimesCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetTimes, SetTimes: c}
+}
+
+func newSetAccessTimesCommand(c *setAccessTimesCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetAccessTimes, SetAccessTimes: c}
+}
+
+func newSetXAttrCommand(c *setXAttrCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetXAttr, SetXAttr: c}
+}
+
+func newRemoveXAttrCommand(c *removeXAttrCommand) *command {
+	return &command{Version: commandVersion, Type: commandRemoveXAttr, RemoveXAttr: c}
+}
+
+func newAppendCommand(c *appendCommand) *command {
+	return &command{Version: commandVersion, Type: commandAppend, Append: c}
+}
+
+func newPrepareWriteCommand(c *prepareWriteCommand) *command {
+	return &command{Version: commandVersion, Type: commandPrepareWrite, PrepareWrite: c}
+}
+
+func newAbortWriteCommand(c *abortWriteCommand) *command {
+	return &command{Version: commandVersion, Type: commandAbortWrite, AbortWrite: c}
+}
+
+func newWriteCommand(c *writeCommand) *command {
+	return &command{Version: commandVersion, Type: commandWrite, Write: c}
+}
+
+func newTruncateCommand(c *truncateCommand) *command {
+	return &command{Version: commandVersion, Type: commandTruncate, Truncate: c}
+}
+
+func newAddBlockCommand(c *addBlockCommand) *command {
+	return &command{Version: commandVersion, Type: commandAddBlock, AddBlock: c}
+}
+
+func newCompleteCommand(c *completeCommand) *command {
+	return &command{Version: commandVersion, Type: commandComplete, Complete: c}
+}
+
+func newRecoverLeaseCommand(c *recoverLeaseCommand) *command {
+	return &command{Version: commandVersion, Type: commandRecoverLease, RecoverLease: c}
+}
+
+func newConcatCommand(c *concatCommand) *command {
+	return &command{Version: commandVersion, Type: commandConcat, Concat: c}
+}
+
+func newSetReplicationCommand(c *setReplicationCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetReplication, SetReplication: c}
+}
+
+func newReplicateCommand(c *replicateCommand) *command {
+	return &command{Version: commandVersion, Type: commandReplicate, Replicate: c}
+}
+
+func newSetErasureCodingCommand(c *setErasureCodingCommand) *command {
+	return &command{Version: commandVersion, Type: commandSetErasureCoding, SetErasureCoding: c}
+}
+
+func newCopyCommand(c *copyCommand) *command {
+	return &command{Version: commandVersion, Type: commandCopy, Copy: c}
+}
+
+func newCreateSymlinkCommand(c *createSymlinkCommand) *command {
+	return &command{Version: commandVersion, Type: commandCreateSymlink, CreateSymlink: c}
+}
+
+func encodeCommand(cmd *command) []byte {
+	w := new(bytes.Buffer)
+	encoder := gob.NewEncoder(w)
+	err := encoder.Encode(cmd)
+	if err != nil {
+		log.Panic(err)
+	}
+	return w.Bytes()
+}
+
+func decodeCommand(data []byte) *command {
+	r := bytes.NewBuffer(data)
+	decoder := gob.NewDecoder(r)
+	var cmd command
+	err := decoder.Decode(&cmd)
+	if err != nil {
+		log.Panic(err)
+	}
+	return &cmd
+}
+
+// apply mutates the state according to the command, it must be deterministic
+// since every replica applies the same sequence of commands
+func (st *namenodeState) apply(cmd *command) error {
+	if cmd.Version > commandVersion {
+		// applying a partial understanding of the command would diverge replicas
+		log.Panicf("unsupported command version %v, current version %v", cmd.Version, commandVersion)
+	}
+
+	switch cmd.Type {
+	case commandCreateFile:
+		return st.applyCreateFile(cmd.CreateFile)
+	case commandRename:
+		return st.applyRename(cmd.Rename)
+	case commandRegisterDataNode:
+		return st.applyRegisterDataNode(cmd.RegisterDataNode)
+	case commandRemoveDataNode:
+		return st.applyRemoveDataNode(cmd.RemoveDataNode)
+	case commandMigrateReplicas:
+		st.applyMoves(cmd.MigrateReplicas.Moves)
+		return nil
+	case commandSetReplicaValidity:
+		return st.applySetReplicaValidity(cmd.SetReplicaValidity)
+	case commandDelete:
+		return st.applyDelete(cmd.Delete)
+	case commandReclaimBlocks:
+		st.applyReclaimBlocks(cmd.ReclaimBlocks)
+		return nil
+	case commandMoveToTrash:
+		return st.applyMoveToTrash(cmd.MoveToTrash)
+	case commandCreateSnapshot:
+		return st.applyCreateSnapshot(cmd.CreateSnapshot)
+	case commandDeleteSnapshot:
+		return st.applyDeleteSnapshot(cmd.DeleteSnapshot)
+	case commandSetPermission:
+		return st.applySetPermission(cmd.SetPermission)
+	case commandSetOwner:
+		return st.applySetOwner(cmd.SetOwner)
+	case commandSetQuota:
+		return st.applySetQuota(cmd.SetQuota)
+	case commandSetTimes:
+		return st.applySetTimes(cmd.SetTimes)
+	case commandSetXAttr:
+		return st.applySetXAttr(cmd.SetXAttr)
+	case commandRemoveXAttr:
+		return st.applyRemoveXAttr(cmd.RemoveXAttr)
+	case commandCreateSymlink:
+		return st.applyCreateSymlink(cmd.CreateSymlink)
+	case commandCopy:
+		return st.applyCopy(cmd.Copy)
+	case commandAppend:
+		return st.applyAppend(cmd.Append)
+	case commandWrite:
+		return st.applyWrite(cmd.Write)
+	case commandTruncate:
+		return st.applyTruncate(cmd.Truncate)
+	case commandAddBlock:
+		return st.applyAddBlock(cmd.AddBlock)
+	case commandComplete:
+		return st.applyComplete(cmd.Complete)
+	case commandRecoverLease:
+		return st.applyRecoverLease(cmd.RecoverLease)
+	case commandConcat:
+		return st.applyConcat(cmd.Concat)
+	case commandSetReplication:
+		return st.applySetReplication(cmd.SetReplication)
+	case commandReplicate:
+		return st.applyReplicate(cmd.Replicate)
+	case commandSetErasureCoding:
+		return st.applySetErasureCoding(cmd.SetErasureCoding)
+	case commandPrepareWrite:
+		return st.applyPrepareWrite(cmd.PrepareWrite)
+	case commandAbortWrite:
+		st.applyAbortWrite(cmd.AbortWrite)
+		return nil
+	case commandSetAccessTimes:
+		st.applySetAccessTimes(cmd.SetAccessTimes)
+		return nil
+	default:
+		log.Panicf("unknown command type %v", cmd.Type)
+	}
+	return nil
+}
+
+// checkCreate returns the dir to create path in, file tells the size and the
+// layout of the file to take up quota for
+func (st *namenodeState) checkCreate(path string, file *fileInfo) (*fileInfo, error) {
+	_, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	err = checkName(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// check dir existence
+	parent, err := st.lookupParent(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%v, create path %v fails", err, path))
+	}
+
+	// check path existence
+	_, ok := parent.Children[baseName(path)]
+	if ok {
+		return nil, errors.New(fmt.Sprintf("path %v already exists", path))
+	}
+
+	if !utils.IsDir(path) {
+		err = st.checkQuota(parent, usage{Files: 1, Bytes: spaceConsumed(file)}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parent, nil
+}
+
+func (st *namenodeState) applyCreateFile(c *createFileCommand) error {
+	parent, err := st.checkCreate(c.Path, &fileInfo{Size: c.Size, Replication: c.Replication, EC: c.EC})
+	if err != nil {
+		return err
+	}
+
+	info := st.newInode(parent, baseName(c.Path), utils.IsDir(c.Path), c.Owner, c.Time)
+	info.Ids = c.Ids
+	info.Size = c.Size
+	info.Replication = c.Replication
+	info.BlockSize = c.BlockSize
+	info.EC = c.EC
+	info.UnderConstruction = c.UnderConstruction
+	info.Holder = c.Holder
+	info.Pending = c.UnderConstruction
+	// the file was linked empty
+	st.addBytes(parent, spaceConsumed(info))
+	st.addBlocks(info, c.Ids, c.Locs, false)
+	return nil
+}
+
+// addBlocks registers new blocks of the file, valid tells if their replicas
+// are written, the locs of an erasure coded block hold its cells in order
+func (st *namenodeState) addBlocks(info *fileInfo, ids []uuid.UUID, locs [][]int, valid bool) {
+	for i, id := range ids {
+		block := &blockInfo{
+			Locs:  make(map[int]bool),
+			Refs:  1,
+			Wants: map[int]int{info.want(): 1},
+			EC:    info.EC,
+		}
+		if info.EC != nil {
+			block.Cells = make(map[int]int)
+		}
+		for cell, loc := range locs[i] {
+			block.Locs[loc] = valid
+			if info.EC != nil {
+				block.Cells[loc] = cell
+			}
+		}
+		st.UUIDToLocs[id] = block
+	}
+}
+
+// checkRename returns the inode to move, the dir to move it into and the
+// inode it replaces if any
+func (st *namenodeState) checkRename(oldPath, newPath string, overwrite bool) (*fileInfo, *fileInfo, *fileInfo, error) {
+	if oldPath == "/" || newPath == "/" {
+		return nil, nil, nil, errors.New("cannot rename root dir")
+	}
+	if utils.IsDir(oldPath) != utils.IsDir(newPath) {
+		return nil, nil, nil, errors.New(fmt.Sprintf("cannot rename %v to %v, both should be file or dir", oldPath, newPath))
+	}
+
+	// check path existence
+	info, err := st.lookup(oldPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	_, err = splitPath(newPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	err = checkName(newPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	parent, err := st.lookupParent(newPath)
+	if err != nil {
+		return nil, nil, nil, errors.New(fmt.Sprintf("%v, rename %v to %v fails", err, oldPath, newPath))
+	}
+
+	// a dir cannot be moved into its own subtree
+	for dir := parent; info.IsDir; dir = st.Inodes[dir.Parent] {
+		if dir.Inode == info.Inode {
+			return nil, nil, nil, errors.New(fmt.Sprintf("cannot move %v into itself", oldPath))
+		}
+		if dir.Inode == rootInode {
+			break
+		}
+	}
+
+	id, ok := parent.Children[baseName(newPath)]
+	if !ok || id == info.Inode {
+		err = st.checkQuota(parent, st.usageOf(info), st.Inodes[info.Parent])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return info, parent, nil, nil
+	}
+
+	// newPath is taken
+	replaced := st.Inodes[id]
+	if !overwrite {
+		return nil, nil, nil, errors.New(fmt.Sprintf("path %v already exists", newPath))
+	}
+	if replaced.IsDir != info.IsDir {
+		return nil, nil, nil, errors.New(fmt.Sprintf("cannot overwrite %v with %v", childPath(parentDir(newPath), replaced), oldPath))
+	}
+	if replaced.IsDir && len(replaced.Children) != 0 {
+		return nil, nil, nil, errors.New(fmt.Sprintf("dir %v not empty", newPath))
+	}
+	if st.hasSnapshots(replaced) {
+		return nil, nil, nil, errors.New(fmt.Sprintf("dir %v has snapshots", newPath))
+	}
+
+	delta, replacedUsage := st.usageOf(info), st.usageOf(replaced)
+	delta.Files -= replacedUsage.Files
+	delta.Bytes -= replacedUsage.Bytes
+	err = st.checkQuota(parent, delta, st.Inodes[info.Parent])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return info, parent, replaced, nil
+}
+
+func (st *namenodeState) applyRename(c *renameCommand) error {
+	info, parent, replaced, err := st.checkRename(c.OldPath, c.NewPath, c.Overwrite)
+	if err != nil {
+		return err
+	}
+
+	if replaced != nil {
+		st.unlink(replaced)
+		st.removeSubtree(replaced)
+	}
+
+	// descendants move along with the dir
+	st.Inodes[info.Parent].Mtime = c.Time
+	st.unlink(info)
+	info.Name = baseName(c.NewPath)
+	st.link(parent, info)
+	parent.Mtime = c.Time
+	return nil
+}
+
+func (st *namenodeState) applyRegisterDataNode(c *registerDataNodeCommand) error {
+	st.LocToInfo[c.Loc] = locInfo{
+		Addr:     c.Addr,
+		Topology: c.Topology,
+	}
+	if c.Loc >= st.MaxLoc {
+		st.MaxLoc = c.Loc + 1
+	}
+	return nil
+}
+
+func (st *namenodeState) applyRemoveDataNode(c *removeDataNodeCommand) error {
+	st.applyMoves(c.Moves)
+	delete(st.LocToInfo, c.Loc)
+	return nil
+}
+
+func (st *namenodeState) applyMoves(moves []replicaMove) {
+	for _, move := range moves {
+		block, ok := st.UUIDToLocs[move.Id]
+		if !ok {
+			// removed while migrating
+			continue
+		}
+		delete(block.Locs, move.From)
+		block.Locs[move.To] = true
+		if block.EC != nil {
+			block.Cells[move.To] = block.Cells[move.From]
+			delete(block.Cells, move.From)
+		}
+	}
+}
+
+func (st *namenodeState) applySetReplicaValidity(c *setReplicaValidityCommand) error {
+	block, ok := st.UUIDToLocs[c.Id]
+	if !ok {
+		return errors.New(fmt.Sprintf("uuid %v not exists", c.Id))
+	}
+	for loc, validity := range c.Validity {
+		_, ok := block.Locs[loc]
+		if ok {
+			block.Locs[loc] = validity
+		}
+	}
+	return nil
+}
+
+func (st *namenodeState) checkDelete(path string, recursive bool) (*fileInfo, error) {
+	if path == "/" {
+		return nil, errors.New("cannot delete root dir")
+	}
+	info, err := st.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir && len(info.Children) != 0 && !recursive {
+		return nil, errors.New(fmt.Sprintf("dir %v not empty", path))
+	}
+	if st.hasSnapshots(info) {
+		return nil, errors.New(fmt.Sprintf("dir %v has snapshots, delete them first", path))
+	}
+	return info, nil
+}
+
+func (st *namenodeState) applyDelete(c *deleteCommand) error {
+	info, err := st.checkDelete(c.Path, c.Recursive)
+	if err != nil {
+		return err
+	}
+
+	st.Inodes[info.Parent].Mtime = c.Time
+	st.unlink(info)
+	st.removeSubtree(info)
+	return nil
+}
+
+// removeSubtree drops the inodes under info and hands their blocks over to reclaim
+func (st *namenodeState) removeSubtree(info *fileInfo) {
+	for _, child := range info.Children {
+		st.removeSubtree(st.Inodes[child])
+	}
+	for _, id := range info.Ids {
+		st.releaseBlock(info, id)
+	}
+	st.dropAppended(info)
+	delete(st.Inodes, info.Inode)
+}
+
+// releaseBlock drops the reference of the file to the block, which is handed
+// over to reclaim once nothing refers to it
+func (st *namenodeState) releaseBlock(info *fileInfo, id uuid.UUID) {
+	block, ok := st.UUIDToLocs[id]
+	if !ok {
+		return
+	}
+	block.want(info.want(), -1)
+	block.Refs--
+	if block.Refs <= 0 {
+		delete(st.UUIDToLocs, id)
+		// extra replicas dropped earlier may still be reclaiming
+		locsInfo, ok := st.Reclaims[id]
+		if !ok {
+			st.Reclaims[id] = block.Locs
+			return
+		}
+		for loc, valid := range block.Locs {
+			locsInfo[loc] = valid
+		}
+	}
+}
+
+func (st *namenodeState) applyReclaimBlocks(c *reclaimBlocksCommand) {
+	for _, replica := range c.Replicas {
+		locsInfo, ok := st.Reclaims[replica.Id]
+		if !ok {
+			continue
+		}
+		delete(locsInfo, replica.Loc)
+		if len(locsInfo) == 0 {
+			delete(st.Reclaims, replica.Id)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"simple-distributed-storage-system/src/utils"
+)
+
+// checkConcatFile returns a file taking part in a concat, which must be a
+// plain file with all its blocks written
+func (st *namenodeState) checkConcatFile(path string) (*fileInfo, error) {
+	if utils.IsDir(path) {
+		return nil, errors.New(fmt.Sprintf("cannot concat dir %v", path))
+	}
+	info, err := st.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Target != "" {
+		return nil, errors.New(fmt.Sprintf("cannot concat symlink %v", path))
+	}
+	if info.UnderConstruction {
+		return nil, underConstruction(path)
+	}
+	return info, nil
+}
+
+// checkConcat returns the target and the sources of a concat, the blocks are
+// chained as they are, so the files must have the same block size and erasure
+// coding and every file but the last must fill all its blocks
+func (st *namenodeState) checkConcat(target string, sources []string) (*fileInfo, []*fileInfo, error) {
+	if len(sources) == 0 {
+		return nil, nil, errors.New(fmt.Sprintf("nothing to concat to %v", target))
+	}
+	info, err := st.checkConcatFile(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := map[uint64]bool{info.Inode: true}
+	var srcs []*fileInfo
+	for i, path := range append([]string{target}, sources...) {
+		src := info
+		if i != 0 {
+			src, err = st.checkConcatFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if seen[src.Inode] {
+				return nil, nil, errors.New(fmt.Sprintf("file %v appears more than once in concat to %v", path, target))
+			}
+			seen[src.Inode] = true
+			if src.blockSize() != info.blockSize() {
+				return nil, nil, errors.New(fmt.Sprintf("file %v has blocks of %v bytes, not %v like %v", path, src.blockSize(), info.blockSize(), target))
+			}
+			if src.layout() != info.layout() {
+				return nil, nil, errors.New(fmt.Sprintf("file %v is stored with %v, not %v like %v", path, src.layout(), info.layout(), target))
+			}
+			srcs = append(srcs, src)
+		}
+		if i != len(sources) && src.Size%src.blockSize() != 0 {
+			return nil, nil, errors.New(fmt.Sprintf("file %v has a partial last block, only the last file of a concat may", path))
+		}
+	}
+
+	// the sources leave the quotas above their dirs and take the replication
+	// of the target
+	size := info.Size
+	for _, src := range srcs {
+		size += src.Size
+	}
+	err = st.ancestors(st.Inodes[info.Parent], func(ancestor *fileInfo) error {
+		if ancestor.Quota == nil {
+			return nil
+		}
+		delta := usage{Bytes: spaceOf(info, size) - spaceConsumed(info)}
+		for _, src := range srcs {
+			if st.isAncestor(ancestor, src) {
+				delta.Bytes -= spaceConsumed(src)
+			}
+		}
+		return st.checkQuotaOf(ancestor, delta)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, srcs, nil
+}
+
+// applyConcat moves the blocks of the sources to the end of the target and
+// removes the sources, the blocks keep their references and no data moves
+func (st *namenodeState) applyConcat(c *concatCommand) error {
+	info, srcs, err := st.checkConcat(c.Target, c.Sources)
+	if err != nil {
+		return err
+	}
+
+	for _, src := range srcs {
+		st.Inodes[src.Parent].Mtime = c.Time
+		st.unlink(src)
+		delete(st.Inodes, src.Inode)
+
+		// the blocks are wanted as the target wants its own
+		st.wantBlocks(src, src.Ids, -1)
+		info.Ids = append(info.Ids, src.Ids...)
+		st.wantBlocks(info, src.Ids, 1)
+		st.addBytes(st.Inodes[info.Parent], spaceOf(info, info.Size+src.Size)-spaceConsumed(info))
+		info.Size += src.Size
+	}
+	info.Mtime = c.Time
+	return nil
+}
@@ -0,0 +1,109 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"simple-distributed-storage-system/src/utils"
+)
+
+func underConstruction(path string) error {
+	return errors.New(fmt.Sprintf("file %v is under construction", path))
+}
+
+// checkVisible hides a file from readers until its creation is completed, so
+// a put is all or nothing to them
+func checkVisible(path string, info *fileInfo) error {
+	if info.Pending {
+		return errors.New(fmt.Sprintf("path %v not exists", path))
+	}
+	return nil
+}
+
+// checkUnderConstruction returns the file still being written by holder
+func (st *namenodeState) checkUnderConstruction(path, holder string) (*fileInfo, error) {
+	if utils.IsDir(path) {
+		return nil, errors.New(fmt.Sprintf("path %v is not file", path))
+	}
+	info, err := st.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.UnderConstruction {
+		return nil, errors.New(fmt.Sprintf("file %v is not under construction", path))
+	}
+	if info.Holder != holder {
+		return nil, errors.New(fmt.Sprintf("file %v is leased to %v, not %v", path, info.Holder, holder))
+	}
+	return info, nil
+}
+
+// checkAddBlock returns the file to add a block to, the quota is checked as
+// if the blocks were full since the size is only known once completed
+func (st *namenodeState) checkAddBlock(path, holder string) (*fileInfo, error) {
+	info, err := st.checkUnderConstruction(path, holder)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.Appending) != 0 {
+		return nil, errors.New(fmt.Sprintf("blocks of the append to %v are all added at once", path))
+	}
+	full := uint64(len(info.Ids)+1) * info.blockSize()
+	err = st.checkQuota(st.Inodes[info.Parent], usage{Bytes: spaceOf(info, full) - spaceConsumed(info)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (st *namenodeState) applyAddBlock(c *addBlockCommand) error {
+	info, err := st.checkAddBlock(c.Path, c.Holder)
+	if err != nil {
+		return err
+	}
+	info.Ids = append(info.Ids, c.Id)
+	st.addBlocks(info, info.Ids[len(info.Ids)-1:], [][]int{c.Locs}, false)
+	return nil
+}
+
+// checkComplete returns the file to complete, size must fall in its last
+// block and every block must have a written replica
+func (st *namenodeState) checkComplete(path string, size uint64, holder string) (*fileInfo, error) {
+	info, err := st.checkUnderConstruction(path, holder)
+	if err != nil {
+		return nil, err
+	}
+	ids := info.writing()
+	if utils.CeilDiv(size, info.blockSize()) != len(ids) {
+		return nil, errors.New(fmt.Sprintf("file %v has %v blocks, which cannot hold %v bytes", path, len(ids), size))
+	}
+	for i, id := range ids {
+		block, ok := st.UUIDToLocs[id]
+		if !ok || !block.valid() {
+			return nil, errors.New(fmt.Sprintf("block #%v of file %v has no written replica", i, path))
+		}
+	}
+	return info, nil
+}
+
+func (st *namenodeState) applyComplete(c *completeCommand) error {
+	info, err := st.checkComplete(c.Path, c.Size, c.Holder)
+	if err != nil {
+		return err
+	}
+	st.swapAppended(info)
+	st.settle(info, c.Size, c.Time)
+	return nil
+}
+
+// settle ends the construction of the file with its final size and shows it
+// to readers, a streaming create only takes up quota from now on
+func (st *namenodeState) settle(info *fileInfo, size uint64, now int64) {
+	st.addBytes(st.Inodes[info.Parent], spaceOf(info, size)-spaceConsumed(info))
+	info.Size = size
+	info.Mtime = now
+	st.rewant(info, func() {
+		info.UnderConstruction = false
+		info.Holder = ""
+		info.Pending = false
+	})
+}
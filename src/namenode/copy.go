@@ -0,0 +1,48 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"simple-distributed-storage-system/src/utils"
+)
+
+// checkCopy returns the file to copy and the dir to copy it into, the source
+// may be reached through symlinks or in a snapshot
+func (st *namenodeState) checkCopy(srcPath, dstPath string) (*fileInfo, *fileInfo, error) {
+	if utils.IsDir(srcPath) || utils.IsDir(dstPath) {
+		return nil, nil, errors.New(fmt.Sprintf("cannot copy %v to %v, both should be file", srcPath, dstPath))
+	}
+	info, _, err := st.resolve(srcPath, resolveOptions{snapshots: true, follow: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.UnderConstruction {
+		return nil, nil, underConstruction(srcPath)
+	}
+	parent, err := st.checkCreate(dstPath, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, parent, nil
+}
+
+// applyCopy links a new file to the blocks of the source, the blocks are
+// reclaimed once neither of them refers to the blocks any more
+func (st *namenodeState) applyCopy(c *copyCommand) error {
+	src, parent, err := st.checkCopy(c.SrcPath, c.DstPath)
+	if err != nil {
+		return err
+	}
+
+	info := st.newInode(parent, baseName(c.DstPath), false, c.Owner, c.Time)
+	info.Ids = append([]uuid.UUID(nil), src.Ids...)
+	info.Size = src.Size
+	info.Replication = src.Replication
+	info.BlockSize = src.BlockSize
+	info.EC = src.EC
+	// the file was linked empty
+	st.addBytes(parent, spaceConsumed(info))
+	st.referBlocks(info)
+	return nil
+}
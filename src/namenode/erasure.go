@@ -0,0 +1,278 @@
+package namenode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/consts"
+	"simple-distributed-storage-system/src/erasure"
+	"simple-distributed-storage-system/src/protos"
+	"simple-distributed-storage-system/src/utils"
+	"sort"
+)
+
+// ecPolicy stores each block of a file as a group of Data cells and Parity
+// cells on distinct datanodes instead of replicas, any Data cells of a group
+// rebuild the block
+type ecPolicy struct {
+	Data   int
+	Parity int
+}
+
+func (p *ecPolicy) String() string {
+	return fmt.Sprintf("RS-%d-%d", p.Data, p.Parity)
+}
+
+// width is the number of cells in a block group
+func (p *ecPolicy) width() int {
+	return p.Data + p.Parity
+}
+
+// parseECPolicy parses a policy such as RS-6-3, an empty name stands for no
+// policy
+func parseECPolicy(path, name string) (*ecPolicy, error) {
+	if name == "" {
+		return nil, nil
+	}
+	var p ecPolicy
+	var rest string
+	n, _ := fmt.Sscanf(name, "RS-%d-%d%s", &p.Data, &p.Parity, &rest)
+	if n != 2 || name != p.String() {
+		return nil, errors.New(fmt.Sprintf("erasure coding policy %v of %v should be like RS-6-3", name, path))
+	}
+	_, err := erasure.NewCoder(p.Data, p.Parity)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%v, erasure coding policy %v of %v is invalid", err, name, path))
+	}
+	return &p, nil
+}
+
+// ecPolicyOf returns the policy for new files in dir, which is the one of
+// the closest dir above having a policy, nil if the files are replicated
+func (st *namenodeState) ecPolicyOf(dir *fileInfo) *ecPolicy {
+	for {
+		if dir.EC != nil {
+			return dir.EC
+		}
+		if dir.Inode == rootInode {
+			return nil
+		}
+		dir = st.Inodes[dir.Parent]
+	}
+}
+
+// width is the number of datanodes each block of the file is stored on
+func (info *fileInfo) width() int {
+	if info.EC != nil {
+		return info.EC.width()
+	}
+	return info.replication()
+}
+
+// layout tells how the blocks of the file are stored, replicated or the
+// erasure coding policy
+func (info *fileInfo) layout() string {
+	if info.EC != nil {
+		return info.EC.String()
+	}
+	return "replication"
+}
+
+// blockLength is the number of bytes in the block at index
+func (info *fileInfo) blockLength(index uint64) uint64 {
+	return uint64(utils.Min(info.blockSize(), info.Size-index*info.blockSize()))
+}
+
+func newErasureCodingPolicy(p *ecPolicy) *protos.ErasureCodingPolicy {
+	return &protos.ErasureCodingPolicy{DataCells: uint32(p.Data), ParityCells: uint32(p.Parity)}
+}
+
+// cellLocs orders the locs a client has written by the cells they hold, a
+// block group is only written with all its cells
+func cellLocs(policy *ecPolicy, locs []int, cells []uint32) ([]int, error) {
+	if len(locs) != policy.width() || len(cells) != len(locs) {
+		return nil, errors.New(fmt.Sprintf("%v of %v cells are written", len(locs), policy.width()))
+	}
+	ordered := make([]int, policy.width())
+	written := make([]bool, policy.width())
+	for i, cell := range cells {
+		if int(cell) >= policy.width() || written[cell] {
+			return nil, errors.New(fmt.Sprintf("cell #%v is out of range or written twice", cell))
+		}
+		ordered[cell] = locs[i]
+		written[cell] = true
+	}
+	return ordered, nil
+}
+
+func (st *namenodeState) checkSetErasureCoding(path string) (*fileInfo, error) {
+	if !utils.IsDir(path) {
+		return nil, errors.New(fmt.Sprintf("cannot set erasure coding of file %v, only of dirs", path))
+	}
+	return st.lookup(path)
+}
+
+// applySetErasureCoding only affects the files created afterwards, files
+// keep the layout they are written with
+func (st *namenodeState) applySetErasureCoding(c *setErasureCodingCommand) error {
+	info, err := st.checkSetErasureCoding(c.Path)
+	if err != nil {
+		return err
+	}
+	info.EC = c.EC
+	return nil
+}
+
+// planRebuild plans to re-encode the cells of a block group which are lost or
+// were never written from the cells left, and to put them on other datanodes
+func (s *namenodeServer) planRebuild(id uuid.UUID, block *blockInfo) (replicationTask, bool) {
+	cells := make(map[int]int) // cell -> loc holding it
+	for loc, validity := range block.Locs {
+		_, registered := s.state.LocToInfo[loc]
+		if validity && registered {
+			cells[block.Cells[loc]] = loc
+		}
+	}
+	if len(cells) == block.EC.width() {
+		return replicationTask{}, false
+	}
+	if len(cells) < block.EC.Data {
+		log.Warnf("uuid %v has %v cells left, %v are needed to rebuild it", id, len(cells), block.EC.Data)
+		return replicationTask{}, false
+	}
+
+	var candidates []int
+	for _, loc := range s.fetchAllLocs() {
+		_, ok := block.Locs[loc]
+		_, reclaiming := s.state.Reclaims[id][loc]
+		_, registered := s.state.LocToInfo[loc]
+		if !ok && !reclaiming && registered {
+			candidates = append(candidates, loc)
+		}
+	}
+	lost := block.EC.width() - len(cells)
+	if len(candidates) < lost {
+		log.Warnf("uuid %v has %v cells lost, only %v datanodes can take them", id, lost, len(candidates))
+		return replicationTask{}, false
+	}
+	var placed []int
+	for _, loc := range cells {
+		placed = append(placed, loc)
+	}
+	locs, err := s.fetchReplicationLocs(id, candidates, lost, placed)
+	if err != nil {
+		log.Warn(err)
+		return replicationTask{}, false
+	}
+
+	task := replicationTask{id: id, ec: block.EC, cells: make(map[int]string)}
+	for cell, loc := range cells {
+		task.cells[cell] = s.state.LocToInfo[loc].Addr
+	}
+	for cell := 0; cell < block.EC.width(); cell++ {
+		if _, ok := cells[cell]; ok {
+			continue
+		}
+		task.to = append(task.to, replicaRef{Id: id, Loc: locs[0], Cell: cell})
+		task.addrs = append(task.addrs, s.state.LocToInfo[locs[0]].Addr)
+		locs = locs[1:]
+	}
+	return task, true
+}
+
+// rebuild re-encodes the lost cells of the task and returns those put
+func (t replicationTask) rebuild() []replicaRef {
+	bin, err := t.id.MarshalBinary()
+	if err != nil {
+		log.Panic(err)
+	}
+	data, err := readCells(bin, t.ec, t.cells)
+	if err != nil {
+		log.Warn(err)
+		log.Warnf("unable to rebuild uuid %v", t.id)
+		return nil
+	}
+
+	var added []replicaRef
+	for i, replica := range t.to {
+		log.Infof("uuid %v -> rebuild cell #%v at loc %v", t.id, replica.Cell, replica.Loc)
+
+		datanode, conn, err := utils.ConnectToTargetDataNode(t.addrs[i], consts.ServiceToken)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		_, err = datanode.Write(context.Background(), &protos.WriteRequest{Uuid: bin, Data: data[replica.Cell]})
+		conn.Close()
+		if err != nil {
+			log.Warn(err)
+			log.Warnf("unable to rebuild cell #%v of uuid %v at loc %v", replica.Cell, t.id, replica.Loc)
+			continue
+		}
+		added = append(added, replica)
+	}
+	return added
+}
+
+// rebuiltCells returns the old locs of the rebuilt cells, which are of no use
+// any more
+func (st *namenodeState) rebuiltCells(added []replicaRef) []replicaRef {
+	var dropped []replicaRef
+	for _, replica := range added {
+		block, ok := st.UUIDToLocs[replica.Id]
+		if !ok || block.EC == nil {
+			continue
+		}
+		for loc, cell := range block.Cells {
+			if cell == replica.Cell && loc != replica.Loc {
+				dropped = append(dropped, replicaRef{Id: replica.Id, Loc: loc, Cell: cell})
+			}
+		}
+	}
+	return dropped
+}
+
+// readCells reads the cells of a block group from the datanodes holding them
+// and rebuilds all the others, data cells are read first since they need no
+// decoding if all present
+func readCells(id []byte, policy *ecPolicy, addrs map[int]string) ([][]byte, error) {
+	coder, err := erasure.NewCoder(policy.Data, policy.Parity)
+	if err != nil {
+		return nil, err
+	}
+
+	var order []int
+	for cell := range addrs {
+		order = append(order, cell)
+	}
+	sort.Ints(order)
+
+	cells := make([][]byte, policy.width())
+	read := 0
+	for _, cell := range order {
+		if read == policy.Data {
+			break
+		}
+		datanode, conn, err := utils.ConnectToTargetDataNode(addrs[cell], consts.ServiceToken)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		reply, err := datanode.Read(context.Background(), &protos.ReadRequest{Uuid: id})
+		conn.Close()
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		cells[cell] = reply.Data
+		read++
+	}
+
+	err = coder.Reconstruct(cells)
+	if err != nil {
+		return nil, err
+	}
+	return cells, nil
+}
@@ -0,0 +1,170 @@
+package namenode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/consts"
+	"simple-distributed-storage-system/src/utils"
+	"time"
+)
+
+const leaseRecoveryDuration = 5
+
+// renewLease records that the holder is still writing, leases only live on
+// the leader and every holder gets a fresh one when the leader changes
+func (s *namenodeServer) renewLease(holder string) {
+	s.leases[holder] = time.Now()
+}
+
+// leaseHolder names the holder of the leases of a client after the user it
+// writes as, so the client name of another user holds none of them
+func leaseHolder(c caller, clientName string) string {
+	return c.User + "/" + clientName
+}
+
+// recoverable returns how many leading blocks of a file under construction
+// can be kept and the size they hold, a block is kept if some replica of it
+// is written, the last block of a streaming create is dropped since its
+// length is unknown, an append is dropped as a whole so the file keeps its
+// old content, and so is a put, which readers see all or nothing
+func (st *namenodeState) recoverable(info *fileInfo) (int, uint64) {
+	if len(info.Appending) != 0 {
+		return len(info.Ids), info.Size
+	}
+	// only a streaming create leaves the size unknown
+	if info.Pending && info.Size != 0 {
+		return 0, 0
+	}
+
+	keep := 0
+	for _, id := range info.Ids {
+		block, ok := st.UUIDToLocs[id]
+		if !ok || !block.valid() {
+			break
+		}
+		keep++
+	}
+
+	// the size is known unless the file is streamed
+	if info.Size != 0 || len(info.Ids) == 0 {
+		return keep, uint64(utils.Min(info.Size, uint64(keep)*info.blockSize()))
+	}
+	if keep == len(info.Ids) {
+		keep--
+	}
+	return keep, uint64(keep) * info.blockSize()
+}
+
+// valid tells if the block can be read, which takes a written replica or
+// enough written cells to decode
+func (b *blockInfo) valid() bool {
+	need := 1
+	if b.EC != nil {
+		need = b.EC.Data
+	}
+	for _, validity := range b.Locs {
+		if validity {
+			need--
+		}
+	}
+	return need <= 0
+}
+
+func (st *namenodeState) applyRecoverLease(c *recoverLeaseCommand) error {
+	info, err := st.lookup(c.Path)
+	if err != nil {
+		return err
+	}
+	if !info.UnderConstruction {
+		return errors.New(fmt.Sprintf("file %v is not under construction", c.Path))
+	}
+	if c.Keep > len(info.Ids) || utils.CeilDiv(c.Size, info.blockSize()) != c.Keep {
+		return errors.New(fmt.Sprintf("file %v cannot keep %v blocks with %v bytes", c.Path, c.Keep, c.Size))
+	}
+
+	st.dropAppended(info)
+	for _, id := range info.Ids[c.Keep:] {
+		st.releaseBlock(info, id)
+	}
+	info.Ids = info.Ids[:c.Keep:c.Keep]
+	st.settle(info, c.Size, c.Time)
+	return nil
+}
+
+// expiredLeases returns the paths of the files whose writers have not renewed
+// their leases in time, a holder seen for the first time starts a lease now
+func (s *namenodeServer) expiredLeases(now time.Time) []string {
+	var paths []string
+	for _, info := range s.state.Inodes {
+		if !info.UnderConstruction {
+			continue
+		}
+		renewed, ok := s.leases[info.Holder]
+		if !ok {
+			s.leases[info.Holder] = now
+			continue
+		}
+		if now.Sub(renewed) > consts.LeaseDuration {
+			paths = append(paths, s.state.pathOf(info))
+		}
+	}
+	return paths
+}
+
+func (s *namenodeServer) leaseTicker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("namenode server %v stop lease recovery", s.addr)
+			return
+
+		case <-time.After(leaseRecoveryDuration * time.Second):
+			if !s.isLeader() {
+				break // not return
+			}
+
+			s.mu.Lock()
+			if s.catchUp() == nil {
+				now := time.Now()
+				for _, path := range s.expiredLeases(now) {
+					s.recoverLease(path, now)
+				}
+				s.abortWrite("", s.expiredWrites(now))
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// recoverLease completes the file with the blocks its writer has managed to
+// write, or abandons a created file if there are none to keep, the blocks of
+// an abandoned file are reclaimed along
+func (s *namenodeServer) recoverLease(path string, now time.Time) {
+	info, err := s.state.lookup(path)
+	if err != nil {
+		return
+	}
+	keep, size := s.state.recoverable(info)
+
+	if keep == 0 && len(info.Appending) == 0 {
+		log.Infof("namenode server %v abandon %v leased to %v", s.addr, path, info.Holder)
+		err = s.syncPropose(newDeleteCommand(&deleteCommand{
+			Path: path,
+			Time: now.UnixNano(),
+		}))
+	} else {
+		log.Infof("namenode server %v recover %v leased to %v with %v blocks and %v bytes",
+			s.addr, path, info.Holder, keep, size)
+		err = s.syncPropose(newRecoverLeaseCommand(&recoverLeaseCommand{
+			Path: path,
+			Time: now.UnixNano(),
+			Keep: keep,
+			Size: size,
+		}))
+	}
+	if err != nil {
+		log.Warnf("namenode server %v cannot recover %v, %v", s.addr, path, err)
+	}
+}
@@ -8,42 +8,161 @@ import (
 	"github.com/google/uuid"
 	"github.com/lni/dragonboat/v4"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/protos"
 	"simple-distributed-storage-system/src/utils"
-	"sort"
 	"sync"
 	"time"
 )
 
 // TODO: intro configuration file
 const (
-	replicaFactor     = 3
-	heartbeatDuration = 2
-	syncReadDuration  = 2
-
-	blockSize uint64 = 40960
+	replicaFactor       = 3 // replication of files created without one
+	maxReplication      = 32
+	heartbeatDuration   = 2
+	syncReadDuration    = 2
+	listLimit           = 1000
+	reclaimDuration     = 2
+	reclaimBatch        = 1000
+	replicationDuration = 2
+	replicationBatch    = 100
+
+	defaultBlockSize uint64 = 40960 // block size of files stored before it was chosen per file
 )
 
+// fileInfo is the inode of a file or a dir in the namespace tree
 type fileInfo struct {
-	Ids  []uuid.UUID
-	Size uint64
+	Inode    uint64
+	Parent   uint64
+	Name     string
+	IsDir    bool
+	Children map[string]uint64 // name -> inode, for dir only
+	Names    []string          // names of Children in sorted order, for dir only
+
+	Owner string
+	Group string
+	Mode  uint32 // permission bits and sticky bit
+
+	Quota *quota // for dir only, nil if unlimited
+
+	// erasure coding of the file, or of the files created under the dir, nil
+	// if replicated or inherited from the dir above, see ecPolicyOf
+	EC *ecPolicy
+
+	XAttrs map[string][]byte
+
+	Target string // for symlink only, a symlink is a file with a target
+
+	// unix nanoseconds after the leader clock
+	Ctime int64 // created
+	Mtime int64 // content or entries modified
+	Atime int64 // last read, see consts.AccessTimePrecision
+
+	Ids         []uuid.UUID
+	Size        uint64
+	Replication int    // replicas wanted for each block, see replication, 0 if erasure coded
+	BlockSize   uint64 // see blockSize
+
+	// being written by Holder, the size is settled once completed
+	UnderConstruction bool
+	Holder            string
+	// created and not completed yet, readers cannot see the file
+	Pending bool
+	// blocks of an append in progress from appendIndex on, see writing
+	Appending []uuid.UUID
+}
+
+type blockInfo struct {
+	Locs map[int]bool // loc -> validity of the replica
+	Refs int          // files and snapshots referring to the block
+	// what the files and snapshots referring to the block want of it, see want
+	Wants map[int]int // want -> references
+
+	// for erasure coded blocks, each loc holds a different cell of the group
+	EC    *ecPolicy
+	Cells map[int]int // loc -> index of the cell, parity cells after data cells
 }
 
 type locInfo struct {
-	Addr   string
-	Blocks uint64
+	Addr     string
+	Blocks   uint64
+	Topology topology
+}
+
+// diskUsage is the disk space of a datanode as reported in its last heartbeat
+type diskUsage struct {
+	Capacity uint64
+	Used     uint64
+	Free     uint64
+	Reserved uint64
 }
 
 type namenodeState struct {
 	MaxLoc     int
 	LocToInfo  map[int]locInfo
-	FileToInfo map[string]fileInfo
-	UUIDToLocs map[uuid.UUID]map[int]bool
+	MaxInode   uint64
+	Inodes     map[uint64]*fileInfo
+	UUIDToLocs map[uuid.UUID]*blockInfo
+	// blocks of deleted files still to be removed from datanodes
+	Reclaims map[uuid.UUID]map[int]bool
+	// blocks issued to writes and not committed yet
+	Prepared map[uuid.UUID]*preparedBlock
+	// dir inode -> snapshot name -> snapshot
+	Snapshots map[uint64]map[string]*snapshot
+}
+
+func newNamenodeState() namenodeState {
+	state := namenodeState{
+		LocToInfo:  make(map[int]locInfo),
+		MaxInode:   rootInode,
+		Inodes:     make(map[uint64]*fileInfo),
+		UUIDToLocs: make(map[uuid.UUID]*blockInfo),
+		Reclaims:   make(map[uuid.UUID]map[int]bool),
+		Prepared:   make(map[uuid.UUID]*preparedBlock),
+		Snapshots:  make(map[uint64]map[string]*snapshot),
+	}
+	// setup root path
+	state.Inodes[rootInode] = &fileInfo{
+		Inode:    rootInode,
+		Parent:   rootInode,
+		IsDir:    true,
+		Children: make(map[string]uint64),
+		Owner:    consts.SuperUser,
+		Group:    consts.SuperGroup,
+		Mode:     rootMode,
+	}
+	return state
+}
+
+// makeMaps restores the maps gob leaves nil when they were encoded empty
+func (st *namenodeState) makeMaps() {
+	if st.LocToInfo == nil {
+		st.LocToInfo = make(map[int]locInfo)
+	}
+	if st.Inodes == nil {
+		st.Inodes = make(map[uint64]*fileInfo)
+	}
+	if st.UUIDToLocs == nil {
+		st.UUIDToLocs = make(map[uuid.UUID]*blockInfo)
+	}
+	if st.Reclaims == nil {
+		st.Reclaims = make(map[uuid.UUID]map[int]bool)
+	}
+	if st.Snapshots == nil {
+		st.Snapshots = make(map[uint64]map[string]*snapshot)
+	}
+	if st.Prepared == nil {
+		st.Prepared = make(map[uuid.UUID]*preparedBlock)
+	}
 }
 
 type registrationInfo struct {
-	context bool
-	addr    string
+	context  bool
+	addr     string
+	topology topology
+	usage    diskUsage // asked for before registering
 }
 
 type namenodeServer struct {
@@ -54,8 +173,16 @@ type namenodeServer struct {
 	replicaID uint64
 	nh        *dragonboat.NodeHost
 	state     namenodeState
+	leading   bool                 // whether state has caught up since becoming leader
+	leases    map[string]time.Time // lease holder -> last renewal, kept by the leader only
+	// loc -> disk space, kept by the leader only and across catching up, a
+	// datanode is unknown until it reports to this replica
+	usage map[int]diskUsage
+	// inode -> last read not yet recorded, kept by the leader only
+	atimes map[uint64]int64
 
 	registrationInfo registrationInfo
+	placement        PlacementPolicy
 }
 
 func (s *namenodeServer) syncRead(ctx context.Context) {
@@ -71,6 +198,10 @@ func (s *namenodeServer) syncRead(ctx context.Context) {
 				break // not return
 			}
 
+			s.mu.Lock()
+			s.leading = false
+			s.mu.Unlock()
+
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 			result, err := s.nh.SyncRead(ctx, sharedID, []byte{})
 			cancel()
@@ -86,18 +217,55 @@ func (s *namenodeServer) syncRead(ctx context.Context) {
 	}
 }
 
-func (s *namenodeServer) syncPropose() {
+// syncPropose replicates the command and, once committed, applies it to the
+// local state, so the effect of an RPC only becomes visible after it is durable
+func (s *namenodeServer) syncPropose(cmd *command) error {
 	cs := s.nh.GetNoOPSession(sharedID)
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	_, err := s.nh.SyncPropose(ctx, cs, s.encodeState())
+	result, err := s.nh.SyncPropose(ctx, cs, encodeCommand(cmd))
 	cancel()
 
 	if err != nil {
-		// TODO: forced synchronization
+		// the command may still be committed later, catch up before the next one
 		log.Warnf("namenode server %v sync propose returned error %v", s.addr, err)
-	} else {
-		log.Infof("namenode server %v successfully syncing propose", s.addr)
+		s.leading = false
+		return err
+	}
+	if result.Value == resultRejected {
+		log.Warnf("namenode server %v command %v rejected, %s", s.addr, cmd.Type, result.Data)
+		return errors.New(string(result.Data))
+	}
+
+	err = s.state.apply(cmd)
+	if err != nil {
+		// the state machine accepted the command, so the local state has diverged
+		log.Warnf("namenode server %v failed to apply committed command %v locally, %v", s.addr, cmd.Type, err)
+		s.leading = false
 	}
+	log.Infof("namenode server %v successfully syncing propose %v", s.addr, cmd.Type)
+	return nil
+}
+
+// catchUp refreshes the local state once after this replica becomes leader,
+// so that commands are validated against everything committed before its term
+func (s *namenodeServer) catchUp() error {
+	if s.leading {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	result, err := s.nh.SyncRead(ctx, sharedID, []byte{})
+	cancel()
+	if err != nil {
+		log.Warnf("namenode server %v cannot catch up, %v", s.addr, err)
+		return err
+	}
+
+	s.decodeState(result.([]byte))
+	s.leading = true
+	// renewals seen in an earlier term are stale, writers get a fresh lease
+	s.leases = make(map[string]time.Time)
+	return nil
 }
 
 func (s *namenodeServer) isLeader() bool {
@@ -111,17 +279,6 @@ func (s *namenodeServer) isLeader() bool {
 	return false
 }
 
-func (s *namenodeServer) encodeState() []byte {
-	w := new(bytes.Buffer)
-	encoder := gob.NewEncoder(w)
-	err := encoder.Encode(s.state)
-	if err != nil {
-		log.Panic(err)
-	}
-	log.Infof("namenode server %v encoded state", s.addr)
-	return w.Bytes()
-}
-
 func (s *namenodeServer) decodeState(data []byte) {
 	log.Infof("namenode server %v decode state", s.addr)
 	r := bytes.NewBuffer(data)
@@ -131,6 +288,7 @@ func (s *namenodeServer) decodeState(data []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
+	state.makeMaps()
 	s.state = state
 }
 
@@ -143,44 +301,98 @@ func (s *namenodeServer) isDataNodeExist(addr string) (int, error) {
 	return 0, errors.New("not found")
 }
 
-func (s *namenodeServer) fetchLocs(locs []int, count int) ([]int, error) {
-	if len(locs) < count {
-		return nil, errors.New("insufficient locs")
+// datanodeOf describes the datanode at loc to the placement policy, false if
+// it is not registered
+func (s *namenodeServer) datanodeOf(loc int) (Datanode, bool) {
+	usage := s.usage[loc]
+	info, ok := s.state.LocToInfo[loc]
+	if !ok && s.registrationInfo.context && loc == s.state.MaxLoc {
+		info, ok = locInfo{Addr: s.registrationInfo.addr, Topology: s.registrationInfo.topology}, true
+		usage = s.registrationInfo.usage
 	}
-
-	type tmpLocInfo struct {
-		loc    int
-		blocks uint64
+	if !ok {
+		return Datanode{}, false
 	}
+	return Datanode{
+		Loc:      loc,
+		Addr:     info.Addr,
+		Blocks:   info.Blocks,
+		Zone:     info.Topology.Zone,
+		Rack:     info.Topology.Rack,
+		Capacity: usage.Capacity,
+		Used:     usage.Used,
+		Free:     usage.Free,
+		Reserved: usage.Reserved,
+	}, true
+}
 
-	var tmp []tmpLocInfo
+// datanodesOf describes the datanodes at locs, unregistered ones are left out
+// unless strict
+func (s *namenodeServer) datanodesOf(locs []int, strict bool) ([]Datanode, error) {
+	var datanodes []Datanode
 	for _, loc := range locs {
-		info, ok := s.state.LocToInfo[loc]
+		d, ok := s.datanodeOf(loc)
 		if ok {
-			tmp = append(tmp, tmpLocInfo{
-				loc:    loc,
-				blocks: info.Blocks,
-			})
-		} else {
-			if !s.registrationInfo.context || loc != s.state.MaxLoc {
-				return nil, errors.New("broken invariant")
-			}
-			tmp = append(tmp, tmpLocInfo{
-				loc:    loc,
-				blocks: 0,
-			})
+			datanodes = append(datanodes, d)
+		} else if strict {
+			return nil, errors.New("broken invariant")
 		}
 	}
+	return datanodes, nil
+}
 
-	sort.Slice(tmp, func(i, j int) bool {
-		return tmp[i].blocks < tmp[j].blocks
-	})
+func locsOf(datanodes []Datanode) []int {
+	var locs []int
+	for _, d := range datanodes {
+		locs = append(locs, d.Loc)
+	}
+	return locs
+}
 
-	var res []int
-	for i := 0; i < count; i++ {
-		res = append(res, tmp[i].loc)
+// fetchLocs chooses count of locs for the replicas of the new block id
+func (s *namenodeServer) fetchLocs(id uuid.UUID, count int) ([]int, error) {
+	return s.fetchReplicationLocs(id, s.fetchAllLocs(), count, nil)
+}
+
+// fetchReplicationLocs chooses count of the candidate locs for more replicas
+// of the block id, placed are the locs already holding some, datanodes filled
+// above the high-water mark take no more, nor do datanodes yet to tell this
+// replica their disk space, see reportUsage
+func (s *namenodeServer) fetchReplicationLocs(id uuid.UUID, locs []int, count int, placed []int) ([]int, error) {
+	datanodes, err := s.datanodesOf(locs, true)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []Datanode
+	for _, d := range datanodes {
+		if !s.reported(d.Loc) {
+			log.Infof("datanode %v has not told its disk space, skip it", d.Addr)
+			continue
+		}
+		if d.fill() > consts.HighWaterMark {
+			log.Infof("datanode %v is %.0f%% full, skip it", d.Addr, 100*d.fill())
+			continue
+		}
+		candidates = append(candidates, d)
+	}
+	if len(candidates) < count {
+		return nil, errors.New("insufficient locs")
 	}
-	return res, nil
+	if len(placed) == 0 {
+		return locsOf(s.placement.ChooseWriteTargets(id, candidates, count)), nil
+	}
+	replicas, _ := s.datanodesOf(placed, false)
+	return locsOf(s.placement.ChooseReplicationTargets(id, candidates, count, replicas)), nil
+}
+
+// fetchExcessLocs chooses count of the locs holding replicas of the block id
+// to drop
+func (s *namenodeServer) fetchExcessLocs(id uuid.UUID, locs []int, count int) ([]int, error) {
+	replicas, err := s.datanodesOf(locs, true)
+	if err != nil {
+		return nil, err
+	}
+	return locsOf(s.placement.ChooseExcessReplicas(id, replicas, count)), nil
 }
 
 func (s *namenodeServer) fetchAllLocs() []int {
@@ -195,64 +407,102 @@ func (s *namenodeServer) fetchAllLocs() []int {
 	return locs
 }
 
+// removeDataNodeServer migrates the replicas of the datanode to others and
+// removes it, the namenode is unlocked while the datanodes copy, false if it
+// is kept since some of its replicas cannot be migrated
 func (s *namenodeServer) removeDataNodeServer(loc int, addr string) bool {
 	log.Infof("namenode server %v trying to remove datanode server %v with loc %v", s.addr, addr, loc)
 
 	// start data migration
-	if s.dataMigration(loc) {
-		// delete addr <-> loc
-		delete(s.state.LocToInfo, loc)
-		log.Infof("namenode server %v successfully removing datanode server %v with loc %v", s.addr, addr, loc)
+	copies, planned := s.planMigration(loc)
+	registration := s.registrationInfo
+	s.registrationInfo = registrationInfo{}
+	s.mu.Unlock()
+	copies, copied := migrate(copies)
+	s.mu.Lock()
+	s.registrationInfo = registration
+	if s.catchUp() != nil {
+		return false
+	}
+	if _, ok := s.state.LocToInfo[loc]; !ok {
+		// removed meanwhile
 		return true
 	}
 
+	moves, covered := s.checkMigration(loc, copies)
+	if planned && copied && covered {
+		// delete addr <-> loc
+		err := s.syncPropose(newRemoveDataNodeCommand(&removeDataNodeCommand{
+			Loc:   loc,
+			Moves: moves,
+		}))
+		if err == nil {
+			log.Infof("namenode server %v successfully removing datanode server %v with loc %v", s.addr, addr, loc)
+			return true
+		}
+	} else if len(moves) != 0 {
+		// keep the replicas already copied
+		_ = s.syncPropose(newMigrateReplicasCommand(&migrateReplicasCommand{Moves: moves}))
+	}
+
 	log.Warnf("namenode server %v cannot remove datanode server %v with loc %v", s.addr, addr, loc)
 	return false
 }
 
-func (s *namenodeServer) dataMigration(loc int) bool {
+// replicaCopy is a replica of a datanode being removed to copy to another one
+type replicaCopy struct {
+	move replicaMove
+	from string // addr of a datanode holding a valid replica
+	to   string
+}
+
+// planMigration plans to copy each valid replica at loc to another datanode,
+// false if some of them cannot be
+func (s *namenodeServer) planMigration(loc int) ([]replicaCopy, bool) {
 	log.Infof("namenode server %v start data migration for loc %v", s.addr, loc)
-	failed := false
+	var copies []replicaCopy
 
-	for id, locsInfo := range s.state.UUIDToLocs {
+	for id, block := range s.state.UUIDToLocs {
+		if block.EC != nil {
+			// no other loc holds the same cell, lost cells are re-encoded
+			// in the background, see planRebuild
+			continue
+		}
+		locsInfo := block.Locs
 		valid, ok := locsInfo[loc]
 		if ok && valid {
 			log.Infof("uuid %v -> locs %v contains %v", id, locsInfo, loc)
 
 			// fetch candidates as to
-			var candidates []int
-			for candidate := range s.fetchAllLocs() {
+			var candidates, placed []int
+			for _, candidate := range s.fetchAllLocs() {
 				_, ok := locsInfo[candidate]
 				if !ok {
 					candidates = append(candidates, candidate)
 				}
 			}
+			for other := range locsInfo {
+				if other != loc {
+					placed = append(placed, other)
+				}
+			}
 
 			log.Infof("uuid %v -> fetch candidates %v", id, candidates)
 
-			// random choose one
-			res, err := s.fetchLocs(candidates, 1)
+			// away from the other replicas
+			res, err := s.fetchReplicationLocs(id, candidates, 1, placed)
 			if err != nil {
 				log.Warn(err)
 				log.Warnf("unable to migrate data for %v", id)
-				failed = true
-				break
+				return copies, false
 			}
 
 			// get addr
 			toLoc := res[0]
-			var toAddr string
-			if toLoc == s.state.MaxLoc {
-				toAddr = s.registrationInfo.addr
-			} else {
-				info, ok := s.state.LocToInfo[toLoc]
-				if !ok {
-					log.Warnf("unable to migrate data for %v", id)
-					failed = true
-					break
-				} else {
-					toAddr = info.Addr
-				}
+			to, ok := s.datanodeOf(toLoc)
+			if !ok {
+				log.Warnf("unable to migrate data for %v", id)
+				return copies, false
 			}
 
 			// fetch candidates as from
@@ -265,126 +515,271 @@ func (s *namenodeServer) dataMigration(loc int) bool {
 			}
 			if fromLoc == -1 {
 				log.Warnf("unable to migrate data for %v", id)
-				failed = true
-				break
+				return copies, false
 			}
 
 			// get addr
-			var fromAddr string
 			info, ok := s.state.LocToInfo[fromLoc]
 			if !ok {
 				log.Warnf("unable to migrate data for %v", id)
-				failed = true
-				break
-			} else {
-				fromAddr = info.Addr
+				return copies, false
 			}
 
-			log.Infof("uuid %v -> copy from %v to %v", id, fromAddr, toAddr)
+			copies = append(copies, replicaCopy{
+				move: replicaMove{
+					Id:   id,
+					From: loc,
+					To:   toLoc,
+				},
+				from: info.Addr,
+				to:   to.Addr,
+			})
+		}
+	}
 
-			// connect to datanode server and read data
-			datanode, conn, err := utils.ConnectToTargetDataNode(fromAddr)
-			if err != nil {
-				log.Warn(err)
-				log.Warnf("unable to migrate data for %v", id)
-				failed = true
-				break
-			}
+	return copies, true
+}
 
-			bin, err := id.MarshalBinary()
-			if err != nil {
-				log.Warn(err)
-				log.Warnf("unable to migrate data for %v", id)
-				failed = true
-				break
-			}
-			reply, err := datanode.Read(context.Background(), &protos.ReadRequest{Uuid: bin})
-			if err != nil {
-				log.Warn(err)
-				log.Warnf("unable to migrate data for %v", id)
-				failed = true
-				break
+// migrate copies the replicas without the namenode locked and returns those
+// copied, false if some copy fails
+func migrate(copies []replicaCopy) ([]replicaCopy, bool) {
+	for i, c := range copies {
+		err := copyReplica(c.move.Id, c.from, c.to)
+		if err != nil {
+			log.Warn(err)
+			log.Warnf("unable to migrate data for %v", c.move.Id)
+			return copies[:i], false
+		}
+	}
+	return copies, true
+}
+
+// checkMigration returns the moves of the copies still valid once the
+// namenode is locked again, false if the datanode at loc holds valid replicas
+// left behind by them, such as those written while copying
+func (s *namenodeServer) checkMigration(loc int, copies []replicaCopy) ([]replicaMove, bool) {
+	var moves []replicaMove
+	moved := make(map[uuid.UUID]bool)
+	for _, c := range copies {
+		block, ok := s.state.UUIDToLocs[c.move.Id]
+		if !ok || !block.Locs[loc] {
+			// removed or dropped while copying
+			continue
+		}
+		_, taken := block.Locs[c.move.To]
+		to, ok := s.datanodeOf(c.move.To)
+		if taken || !ok || to.Addr != c.to {
+			continue
+		}
+		moves = append(moves, c.move)
+		moved[c.move.Id] = true
+	}
+	for id, block := range s.state.UUIDToLocs {
+		if block.EC == nil && block.Locs[loc] && !moved[id] {
+			return moves, false
+		}
+	}
+	return moves, true
+}
+
+func (s *namenodeServer) heartbeatTicker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("namenode server %v stop heartbeat", s.addr)
+			return
+
+		case <-time.After(heartbeatDuration * time.Second):
+			if !s.isLeader() {
+				break // not return
 			}
-			conn.Close()
 
-			// connect to datanode server and write data
-			datanode, conn, err = utils.ConnectToTargetDataNode(toAddr)
-			if err != nil {
-				log.Warn(err)
-				log.Warnf("unable to migrate data for %v", id)
-				failed = true
+			s.mu.Lock()
+			if s.catchUp() != nil {
+				s.mu.Unlock()
 				break
 			}
+			log.Infof("namenode server %v start heartbeat ticker", s.addr)
 
-			_, err = datanode.Write(context.Background(), &protos.WriteRequest{Uuid: bin, Data: reply.Data})
-			if err != nil {
-				log.Warn(err)
-				log.Warnf("unable to migrate data for %v", id)
-				failed = true
-				break
+			addrs := make(map[int]string)
+			for loc, info := range s.state.LocToInfo {
+				addrs[loc] = info.Addr
 			}
-			conn.Close()
+			unreachable, err := s.heartbeats(addrs)
+			if err == nil {
+				for loc, addr := range unreachable {
+					// delete unreachable datanode server
+					if s.registered(loc, addr) {
+						s.removeDataNodeServer(loc, addr) // passive remove
+					}
+				}
+			}
+
+			s.mu.Unlock()
+		}
+	}
+}
 
-			// modify uuidToDataNodeLocsInfo
-			delete(s.state.UUIDToLocs[id], loc)
-			s.state.UUIDToLocs[id][toLoc] = true
+// heartbeats asks the datanodes at the locs of addrs for their blocks and
+// disk space, the namenode is unlocked while waiting for them and caught up
+// again to keep what the datanodes still registered tell, the unreachable
+// ones are returned
+func (s *namenodeServer) heartbeats(addrs map[int]string) (map[int]string, error) {
+	replies := make(map[int]*protos.HeartBeatReply)
+	unreachable := make(map[int]string)
+	s.mu.Unlock()
+	for loc, addr := range addrs {
+		reply, err := heartbeat(addr)
+		if err != nil {
+			log.Warn(err)
+			log.Infof("namenode server %v find datanode %v unreachable", s.addr, addr)
+			unreachable[loc] = addr
+			continue
 		}
+		replies[loc] = reply
+	}
+	s.mu.Lock()
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
 	}
 
-	return !failed
+	for loc, reply := range replies {
+		if !s.registered(loc, addrs[loc]) {
+			continue
+		}
+		// update block number and disk space
+		info := s.state.LocToInfo[loc]
+		info.Blocks = reply.BlockNumber
+		s.state.LocToInfo[loc] = info
+		s.usage[loc] = usageOf(reply)
+	}
+	return unreachable, nil
 }
 
-func (s *namenodeServer) heartbeatTicker(ctx context.Context) {
+// reportUsage asks the datanodes yet to tell this replica their disk space,
+// such as after it becomes leader, so that they can take replicas, it is
+// called before locking to place replicas
+func (s *namenodeServer) reportUsage() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.catchUp() != nil {
+		return
+	}
+
+	unreported := make(map[int]string)
+	for loc, info := range s.state.LocToInfo {
+		if !s.reported(loc) {
+			unreported[loc] = info.Addr
+		}
+	}
+	if len(unreported) != 0 {
+		_, _ = s.heartbeats(unreported)
+	}
+}
+
+// reported returns whether the datanode at loc has told its disk space
+func (s *namenodeServer) reported(loc int) bool {
+	if s.registrationInfo.context && loc == s.state.MaxLoc {
+		return true
+	}
+	_, ok := s.usage[loc]
+	return ok
+}
+
+// registered returns whether the datanode at addr is still registered at loc
+func (s *namenodeServer) registered(loc int, addr string) bool {
+	info, ok := s.state.LocToInfo[loc]
+	return ok && info.Addr == addr
+}
+
+// heartbeat asks the datanode at addr for its blocks and disk space
+func heartbeat(addr string) (*protos.HeartBeatReply, error) {
+	datanode, conn, err := utils.ConnectToTargetDataNode(addr, consts.ServiceToken)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return datanode.HeartBeat(context.Background(), &protos.HeartBeatRequest{})
+}
+
+func usageOf(reply *protos.HeartBeatReply) diskUsage {
+	return diskUsage{
+		Capacity: reply.Capacity,
+		Used:     reply.Used,
+		Free:     reply.Free,
+		Reserved: reply.Reserved,
+	}
+}
+
+func (s *namenodeServer) reclaimTicker(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Infof("namenode server %v stop heartbeat", s.addr)
+			log.Infof("namenode server %v stop reclaim", s.addr)
 			return
 
-		case <-time.After(heartbeatDuration * time.Second):
+		case <-time.After(reclaimDuration * time.Second):
 			if !s.isLeader() {
 				break // not return
 			}
 
 			s.mu.Lock()
-			log.Infof("namenode server %v start heartbeat ticker", s.addr)
+			if s.catchUp() == nil && len(s.state.Reclaims) != 0 {
+				s.reclaimBlocks()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
 
-			locs := s.fetchAllLocs()
-			for _, loc := range locs {
-				info, ok := s.state.LocToInfo[loc]
-				if ok {
-					addr := info.Addr
-					datanode, conn, err := utils.ConnectToTargetDataNode(addr)
-					if err != nil {
-						// delete unreachable datanode server
-						log.Warn(err)
-						log.Infof("namenode server %v find datanode %v unreachable", s.addr, addr)
-						if s.removeDataNodeServer(loc, addr) { // passive remove
-							s.syncPropose()
-						}
-						continue
-					}
+// reclaimBlocks removes the replicas of deleted blocks from datanodes, the
+// replicas that cannot be removed now are retried on the next tick
+func (s *namenodeServer) reclaimBlocks() {
+	log.Infof("namenode server %v start reclaiming %v blocks", s.addr, len(s.state.Reclaims))
 
-					reply, err := datanode.HeartBeat(context.Background(), &protos.HeartBeatRequest{})
-					if err != nil {
-						// delete unreachable datanode server
-						log.Warn(err)
-						log.Infof("namenode server %v find datanode %v unreachable", s.addr, addr)
-						if s.removeDataNodeServer(loc, addr) { // passive remove
-							s.syncPropose()
-						}
-					} else {
-						// update block number
-						s.state.LocToInfo[loc] = locInfo{
-							Addr:   addr,
-							Blocks: reply.BlockNumber,
-						}
-					}
-					conn.Close()
-				}
+	var reclaimed []replicaRef
+	for id, locsInfo := range s.state.Reclaims {
+		if len(reclaimed) >= reclaimBatch {
+			break
+		}
+
+		bin, err := id.MarshalBinary()
+		if err != nil {
+			log.Panic(err)
+		}
+
+		// invalid replicas may have been written without being confirmed
+		for loc := range locsInfo {
+			info, ok := s.state.LocToInfo[loc]
+			if !ok {
+				// the datanode has gone with its data
+				reclaimed = append(reclaimed, replicaRef{Id: id, Loc: loc})
+				continue
 			}
 
-			s.mu.Unlock()
+			datanode, conn, err := utils.ConnectToTargetDataNode(info.Addr, consts.ServiceToken)
+			if err != nil {
+				log.Warn(err)
+				continue
+			}
+			_, err = datanode.Remove(context.Background(), &protos.RemoveRequest{Uuid: bin})
+			conn.Close()
+			if status.Code(err) == codes.NotFound {
+				// nothing written
+				err = nil
+			}
+			if err != nil {
+				log.Warn(err)
+				log.Warnf("unable to reclaim uuid %v at loc %v", id, loc)
+				continue
+			}
+			reclaimed = append(reclaimed, replicaRef{Id: id, Loc: loc})
 		}
 	}
+
+	if len(reclaimed) != 0 {
+		_ = s.syncPropose(newReclaimBlocksCommand(&reclaimBlocksCommand{Replicas: reclaimed}))
+	}
 }
@@ -0,0 +1,324 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"simple-distributed-storage-system/src/utils"
+	"sort"
+	"strings"
+)
+
+const (
+	rootInode uint64 = 1
+
+	// snapshotDirName is the reserved name to reach the snapshots of a dir
+	snapshotDirName = ".snapshot"
+
+	// maxSymlinks bounds the symlinks a path may go through
+	maxSymlinks = 32
+)
+
+// splitPath splits an absolute path into the names of its components, the
+// root path has no component
+func splitPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New(fmt.Sprintf("path %v is not absolute", path))
+	}
+	trimmed := strings.TrimSuffix(path[1:], "/")
+	if trimmed == "" {
+		return []string{}, nil
+	}
+	names := strings.Split(trimmed, "/")
+	for _, name := range names {
+		if name == "" || name == "." || name == ".." {
+			return nil, errors.New(fmt.Sprintf("path %v is invalid", path))
+		}
+	}
+	return names, nil
+}
+
+func parentDir(path string) string {
+	var index int
+	if !utils.IsDir(path) {
+		index = strings.LastIndex(path, "/")
+	} else {
+		index = strings.LastIndex(path[:len(path)-1], "/") // remove last '/'
+	}
+	// include '/'
+	return path[:index+1]
+}
+
+// baseName returns the last component of the path, without '/'
+func baseName(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// childPath builds the path of a child of dir, dirs always end with '/'
+func childPath(dir string, child *fileInfo) string {
+	if child.IsDir {
+		return dir + child.Name + "/"
+	}
+	return dir + child.Name
+}
+
+// lookup walks from the root to the inode of path in the live namespace, a
+// path ending with '/' only matches a dir and any other path only matches a
+// file or a symlink, which is not followed
+func (st *namenodeState) lookup(path string) (*fileInfo, error) {
+	info, _, err := st.resolve(path, resolveOptions{})
+	return info, err
+}
+
+// lookupRead is lookup for reads, which may also go into a snapshot through
+// <dir>/.snapshot/<name>/, children of the inode live in the returned inodes
+func (st *namenodeState) lookupRead(path string) (*fileInfo, map[uint64]*fileInfo, error) {
+	return st.resolve(path, resolveOptions{snapshots: true})
+}
+
+type resolveOptions struct {
+	snapshots bool // may go into <dir>/.snapshot/<name>/
+	follow    bool // follows a symlink at the end of a file path too
+	traverse  func(dir *fileInfo) error
+}
+
+// resolve walks the path, following the symlinks along the way, and calls
+// traverse on each dir it goes through if set
+func (st *namenodeState) resolve(path string, opts resolveOptions) (*fileInfo, map[uint64]*fileInfo, error) {
+	names, err := splitPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	links := 0
+	visited := make(map[string]bool)
+	snapshots := opts.snapshots
+	inodes := st.Inodes
+	info := inodes[rootInode]
+	for i := 0; i < len(names); i++ {
+		if !info.IsDir {
+			return nil, nil, errors.New(fmt.Sprintf("path %v not exists", path))
+		}
+		if opts.traverse != nil {
+			err = opts.traverse(info)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		// snapshots cannot be nested
+		if snapshots && names[i] == snapshotDirName && i+1 < len(names) {
+			snap, ok := st.Snapshots[info.Inode][names[i+1]]
+			if !ok {
+				return nil, nil, errors.New(fmt.Sprintf("path %v not exists", path))
+			}
+			snapshots = false
+			inodes = snap.Inodes
+			info = inodes[snap.Root]
+			i++
+			continue
+		}
+
+		id, ok := info.Children[names[i]]
+		if !ok {
+			return nil, nil, errors.New(fmt.Sprintf("path %v not exists", path))
+		}
+		child := inodes[id]
+
+		if child.Target != "" && (i+1 < len(names) || utils.IsDir(path) || opts.follow) {
+			// the same link with the same remainder would go round forever
+			key := fmt.Sprintf("%v:%v", id, strings.Join(names[i+1:], "/"))
+			if visited[key] {
+				return nil, nil, errors.New(fmt.Sprintf("path %v runs into a symlink loop", path))
+			}
+			visited[key] = true
+			links++
+			if links > maxSymlinks {
+				return nil, nil, errors.New(fmt.Sprintf("path %v goes through more than %v symlinks", path, maxSymlinks))
+			}
+
+			// start over from the root with the target in place of the link
+			names = append(linkTarget(names[:i], child.Target), names[i+1:]...)
+			snapshots = opts.snapshots
+			inodes = st.Inodes
+			info = inodes[rootInode]
+			i = -1
+			continue
+		}
+		info = child
+	}
+
+	if info.IsDir != utils.IsDir(path) {
+		return nil, nil, errors.New(fmt.Sprintf("path %v not exists", path))
+	}
+	return info, inodes, nil
+}
+
+// linkTarget returns the names of the target of a symlink in dir, a relative
+// target starts from dir
+func linkTarget(dir []string, target string) []string {
+	var names []string
+	if !strings.HasPrefix(target, "/") {
+		names = append(names, dir...)
+	}
+	for _, name := range strings.Split(target, "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			if len(names) != 0 {
+				names = names[:len(names)-1]
+			}
+		default:
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkName rejects the names reserved by the namespace
+func checkName(path string) error {
+	if baseName(path) == snapshotDirName {
+		return errors.New(fmt.Sprintf("name %v is reserved", snapshotDirName))
+	}
+	return nil
+}
+
+// lookupParent returns the dir which path should be a child of
+func (st *namenodeState) lookupParent(path string) (*fileInfo, error) {
+	if path == "/" {
+		return nil, errors.New("root dir has no parent")
+	}
+	dir := parentDir(path)
+	parent, err := st.lookup(dir)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("parent dir %v not exists", dir))
+	}
+	return parent, nil
+}
+
+// newInode allocates the next inode id and links the inode under parent, the
+// inode belongs to owner and to the group of parent
+func (st *namenodeState) newInode(parent *fileInfo, name string, isDir bool, owner string, now int64) *fileInfo {
+	st.MaxInode++
+	info := &fileInfo{
+		Inode:  st.MaxInode,
+		Parent: parent.Inode,
+		Name:   name,
+		IsDir:  isDir,
+		Owner:  owner,
+		Group:  parent.Group,
+		Mode:   defaultFileMode,
+		Ctime:  now,
+		Mtime:  now,
+		Atime:  now,
+	}
+	if isDir {
+		info.Mode = defaultDirMode
+	}
+	st.Inodes[info.Inode] = info
+	st.link(parent, info)
+	parent.Mtime = now
+	return info
+}
+
+func (st *namenodeState) link(parent, info *fileInfo) {
+	if parent.Children == nil {
+		parent.Children = make(map[string]uint64)
+	}
+	_, ok := parent.Children[info.Name]
+	if !ok {
+		parent.Names = insertName(parent.Names, info.Name)
+	}
+	parent.Children[info.Name] = info.Inode
+	info.Parent = parent.Inode
+	st.addUsage(parent, info, 1)
+}
+
+func (st *namenodeState) unlink(info *fileInfo) {
+	parent := st.Inodes[info.Parent]
+	delete(parent.Children, info.Name)
+	parent.Names = removeName(parent.Names, info.Name)
+	st.addUsage(parent, info, -1)
+}
+
+// insertName adds name to the sorted names
+func insertName(names []string, name string) []string {
+	i := sort.SearchStrings(names, name)
+	names = append(names, "")
+	copy(names[i+1:], names[i:])
+	names[i] = name
+	return names
+}
+
+// removeName takes name out of the sorted names
+func removeName(names []string, name string) []string {
+	i := sort.SearchStrings(names, name)
+	if i == len(names) || names[i] != name {
+		return names
+	}
+	return append(names[:i], names[i+1:]...)
+}
+
+// pathOf builds the path of an inode in the live namespace
+func (st *namenodeState) pathOf(info *fileInfo) string {
+	if info.Inode == rootInode {
+		return "/"
+	}
+	return st.pathOf(st.Inodes[info.Parent]) + childPath("", info)
+}
+
+// listChildren returns at most limit children of dir whose names sort after
+// cursor, and the cursor for the next page, which is empty at the end, files
+// still being created are left out
+func listChildren(inodes map[uint64]*fileInfo, dir *fileInfo, cursor string, limit int) ([]*fileInfo, string) {
+	names := dir.Names
+	i := sort.Search(len(names), func(i int) bool {
+		return names[i] > cursor
+	})
+
+	var children []*fileInfo
+	for ; i < len(names); i++ {
+		child := inodes[dir.Children[names[i]]]
+		if child.Pending {
+			continue
+		}
+		if len(children) == limit {
+			// another page follows
+			return children, names[i-1]
+		}
+		children = append(children, child)
+	}
+	return children, ""
+}
+
+// mkdirAll returns the dir of path, creating the missing dirs owned by owner along the way
+func (st *namenodeState) mkdirAll(path, owner string, now int64) (*fileInfo, error) {
+	names, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// check before creating anything, a rejected command must leave no trace
+	info := st.Inodes[rootInode]
+	for i, name := range names {
+		id, ok := info.Children[name]
+		if !ok {
+			break
+		}
+		info = st.Inodes[id]
+		if !info.IsDir {
+			return nil, errors.New(fmt.Sprintf("path %v is not dir", "/"+strings.Join(names[:i+1], "/")))
+		}
+	}
+
+	info = st.Inodes[rootInode]
+	for _, name := range names {
+		id, ok := info.Children[name]
+		if ok {
+			info = st.Inodes[id]
+		} else {
+			info = st.newInode(info, name, true, owner, now)
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,200 @@
+package namenode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"simple-distributed-storage-system/src/consts"
+	"simple-distributed-storage-system/src/utils"
+	"strings"
+)
+
+// access bits, shifted to the owner, group or other class of the mode
+const (
+	permRead  uint32 = 4
+	permWrite uint32 = 2
+	permExec  uint32 = 1
+	permAll          = permRead | permWrite | permExec
+
+	defaultFileMode uint32 = 0644
+	defaultDirMode  uint32 = 0755
+
+	// everyone may create top-level dirs and the trash dir of their own, like /tmp
+	rootMode      = utils.ModeSticky | 0777
+	trashRootMode = utils.ModeSticky | 0777
+	trashDirMode  = 0700
+)
+
+// caller is the identity an rpc is served for
+type caller struct {
+	User   string
+	Groups []string
+}
+
+func callerFromContext(ctx context.Context) caller {
+	return caller{
+		User:   utils.UserFromContext(ctx),
+		Groups: utils.GroupsFromContext(ctx),
+	}
+}
+
+func (c caller) inGroup(group string) bool {
+	for _, g := range c.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func (c caller) isSuper() bool {
+	return c.User == consts.SuperUser || c.inGroup(consts.SuperGroup)
+}
+
+// isService tells whether the caller is one of the servers
+func (c caller) isService() bool {
+	return c.User == consts.ServiceUser || c.isSuper()
+}
+
+func (c caller) isOwner(info *fileInfo) bool {
+	return c.isSuper() || c.User == info.Owner
+}
+
+// permitted tells whether the mode class of the caller grants access to the inode
+func (c caller) permitted(info *fileInfo, access uint32) bool {
+	if c.isSuper() {
+		return true
+	}
+	mode := info.Mode
+	if c.User == info.Owner {
+		mode >>= 6
+	} else if c.inGroup(info.Group) {
+		mode >>= 3
+	}
+	return mode&access == access
+}
+
+func permissionDenied(c caller, path string) error {
+	return errors.New(fmt.Sprintf("permission denied, user %v cannot access %v", c.User, path))
+}
+
+// checkPermission requires exec on every dir along the path, then access on
+// the inode of path itself, snapshots are checked with their frozen permissions
+func (st *namenodeState) checkPermission(c caller, path string, access uint32) (*fileInfo, error) {
+	return st.checkAccess(c, path, access, false)
+}
+
+// checkPermissionFollow is checkPermission on the target if path is a symlink
+func (st *namenodeState) checkPermissionFollow(c caller, path string, access uint32) (*fileInfo, error) {
+	return st.checkAccess(c, path, access, true)
+}
+
+func (st *namenodeState) checkAccess(c caller, path string, access uint32, follow bool) (*fileInfo, error) {
+	info, _, err := st.resolve(path, resolveOptions{
+		snapshots: true,
+		follow:    follow,
+		traverse: func(dir *fileInfo) error {
+			if !c.permitted(dir, permExec) {
+				return permissionDenied(c, path)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !c.permitted(info, access) {
+		return nil, permissionDenied(c, path)
+	}
+	return info, nil
+}
+
+// checkRemovePermission requires write on the parent of path to unlink it,
+// and only the owners may unlink from a sticky dir
+func (st *namenodeState) checkRemovePermission(c caller, path string) error {
+	parent, err := st.checkPermission(c, parentDir(path), permWrite|permExec)
+	if err != nil {
+		return err
+	}
+	info, err := st.lookup(path)
+	if err != nil {
+		return err
+	}
+	if parent.Mode&utils.ModeSticky != 0 && !c.isOwner(parent) && !c.isOwner(info) {
+		return permissionDenied(c, path)
+	}
+	return nil
+}
+
+// checkSubtreePermission requires full access on every non-empty dir in the
+// subtree, whose entries go along with it
+func (st *namenodeState) checkSubtreePermission(c caller, path string, info *fileInfo) error {
+	if !info.IsDir || len(info.Children) == 0 {
+		return nil
+	}
+	if !c.permitted(info, permAll) {
+		return permissionDenied(c, path)
+	}
+	for _, child := range info.Children {
+		childInfo := st.Inodes[child]
+		err := st.checkSubtreePermission(c, childPath(path, childInfo), childInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockToken lets the bearer do op on the block at datanode servers, which
+// take no tokens unless the servers authenticate each other
+func blockToken(id []byte, op string) string {
+	if consts.ServiceToken == "" {
+		return ""
+	}
+	return utils.IssueBlockToken([]byte(consts.ServiceToken), id, op, consts.BlockTokenLifetime)
+}
+
+// checkBlockPermission requires the service, write on a file being written
+// to the block, or the lease of such a file to report replicas of the block
+func (st *namenodeState) checkBlockPermission(c caller, id uuid.UUID) error {
+	if c.isService() {
+		return nil
+	}
+	for _, info := range st.Inodes {
+		if !containsId(info.writing(), id) {
+			continue
+		}
+		if info.UnderConstruction && strings.HasPrefix(info.Holder, leaseHolder(c, "")) {
+			return nil
+		}
+		_, err := st.checkPermission(c, st.pathOf(info), permWrite)
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("user %v cannot report replicas of uuid %v", c.User, id))
+}
+
+func (st *namenodeState) applySetPermission(c *setPermissionCommand) error {
+	info, err := st.lookup(c.Path)
+	if err != nil {
+		return err
+	}
+	info.Mode = c.Mode & (utils.ModeSticky | utils.ModePerm)
+	return nil
+}
+
+func (st *namenodeState) applySetOwner(c *setOwnerCommand) error {
+	info, err := st.lookup(c.Path)
+	if err != nil {
+		return err
+	}
+	if c.Owner != "" {
+		info.Owner = c.Owner
+	}
+	if c.Group != "" {
+		info.Group = c.Group
+	}
+	return nil
+}
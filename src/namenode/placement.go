@@ -0,0 +1,240 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// names of the built-in placement policies
+const (
+	leastLoadedPlacement       = "least-loaded"
+	randomPlacement            = "random"
+	capacityWeightedPlacement  = "capacity-weighted"
+	consistentHashingPlacement = "consistent-hashing"
+)
+
+// virtualNodes is the number of points of each datanode on the hash ring
+const virtualNodes = 64
+
+// Datanode is what a placement policy knows of a datanode, the disk space is
+// all 0 until the datanode reports it in a heartbeat
+type Datanode struct {
+	Loc      int
+	Addr     string
+	Blocks   uint64
+	Zone     string
+	Rack     string
+	Capacity uint64
+	Used     uint64
+	Free     uint64
+	Reserved uint64
+}
+
+func (d Datanode) topology() topology {
+	return topology{Zone: d.Zone, Rack: d.Rack}
+}
+
+func (d Datanode) reported() bool {
+	return d.Capacity != 0
+}
+
+// fill is the fraction of the space for blocks which is taken, by blocks or
+// by other files on the disk, 0 if not reported yet
+func (d Datanode) fill() float64 {
+	if !d.reported() {
+		return 0
+	}
+	if d.Capacity <= d.Reserved {
+		return 1
+	}
+	return 1 - float64(d.Free)/float64(d.Capacity-d.Reserved)
+}
+
+// PlacementPolicy chooses the datanodes holding the replicas of a block
+type PlacementPolicy interface {
+	// ChooseWriteTargets chooses count of the candidates for the replicas of a
+	// new block
+	ChooseWriteTargets(id uuid.UUID, candidates []Datanode, count int) []Datanode
+	// ChooseReplicationTargets chooses count of the candidates for more
+	// replicas of a block already on the placed datanodes
+	ChooseReplicationTargets(id uuid.UUID, candidates []Datanode, count int, placed []Datanode) []Datanode
+	// ChooseExcessReplicas chooses count of the replicas of a block to delete
+	ChooseExcessReplicas(id uuid.UUID, replicas []Datanode, count int) []Datanode
+}
+
+// newPlacementPolicy returns the built-in policy of the name
+func newPlacementPolicy(name string) (PlacementPolicy, error) {
+	switch name {
+	case leastLoadedPlacement:
+		return rankedPolicy{rank: rankLeastLoaded}, nil
+	case randomPlacement:
+		return rankedPolicy{rank: rankRandom}, nil
+	case capacityWeightedPlacement:
+		return rankedPolicy{rank: rankCapacityWeighted}, nil
+	case consistentHashingPlacement:
+		return rankedPolicy{rank: rankConsistentHashing}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("placement policy %v should be one of %v, %v, %v and %v", name,
+		leastLoadedPlacement, randomPlacement, capacityWeightedPlacement, consistentHashingPlacement))
+}
+
+// rankedPolicy orders the datanodes by preference for a block, and spreads
+// the replicas across the failure domains in that order
+type rankedPolicy struct {
+	rank func(id uuid.UUID, datanodes []Datanode) []Datanode
+}
+
+func (p rankedPolicy) ChooseWriteTargets(id uuid.UUID, candidates []Datanode, count int) []Datanode {
+	return spreadPick(p.rank(id, candidates), count, nil)
+}
+
+func (p rankedPolicy) ChooseReplicationTargets(id uuid.UUID, candidates []Datanode, count int, placed []Datanode) []Datanode {
+	return spreadPick(p.rank(id, candidates), count, placed)
+}
+
+// ChooseExcessReplicas keeps the replicas the policy would have placed and
+// deletes the others, the least preferred first
+func (p rankedPolicy) ChooseExcessReplicas(id uuid.UUID, replicas []Datanode, count int) []Datanode {
+	ranked := p.rank(id, replicas)
+	kept := make(map[int]bool)
+	for _, d := range spreadPick(ranked, len(ranked)-count, nil) {
+		kept[d.Loc] = true
+	}
+	var excess []Datanode
+	for i := len(ranked) - 1; i >= 0; i-- {
+		if !kept[ranked[i].Loc] {
+			excess = append(excess, ranked[i])
+		}
+	}
+	return excess
+}
+
+// spreadPick picks count of the ranked datanodes one by one, each the first
+// in rank of those in the emptiest domains given the placed datanodes
+func spreadPick(ranked []Datanode, count int, placed []Datanode) []Datanode {
+	sp := newSpread()
+	for _, d := range placed {
+		sp.add(d.topology())
+	}
+
+	left := append([]Datanode(nil), ranked...)
+	var picked []Datanode
+	for len(picked) < count && len(left) != 0 {
+		best := 0
+		for j := 1; j < len(left); j++ {
+			if sp.better(left[j].topology(), left[best].topology()) {
+				best = j
+			}
+		}
+		picked = append(picked, left[best])
+		sp.add(left[best].topology())
+		left = append(left[:best], left[best+1:]...)
+	}
+	return picked
+}
+
+// rankLeastLoaded prefers the datanodes with the least of their space taken,
+// to the percent since the free space of a disk moves with other files, then
+// those holding the fewest blocks
+func rankLeastLoaded(id uuid.UUID, datanodes []Datanode) []Datanode {
+	ranked := append([]Datanode(nil), datanodes...)
+	percent := func(d Datanode) int {
+		return int(100 * d.fill())
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if percent(ranked[i]) != percent(ranked[j]) {
+			return percent(ranked[i]) < percent(ranked[j])
+		}
+		return ranked[i].Blocks < ranked[j].Blocks
+	})
+	return ranked
+}
+
+// rankRandom prefers no datanode over another
+func rankRandom(id uuid.UUID, datanodes []Datanode) []Datanode {
+	ranked := append([]Datanode(nil), datanodes...)
+	rand.Shuffle(len(ranked), func(i, j int) {
+		ranked[i], ranked[j] = ranked[j], ranked[i]
+	})
+	return ranked
+}
+
+// rankCapacityWeighted prefers the datanodes at random in proportion to their
+// free space, those yet to report it count as the freest of the others
+func rankCapacityWeighted(id uuid.UUID, datanodes []Datanode) []Datanode {
+	var freest float64
+	for _, d := range datanodes {
+		if d.reported() && float64(d.Free) > freest {
+			freest = float64(d.Free)
+		}
+	}
+	weight := func(d Datanode) float64 {
+		if !d.reported() || freest == 0 {
+			// without a free byte anywhere any datanode does
+			return math.Max(freest, 1)
+		}
+		return float64(d.Free)
+	}
+
+	left := append([]Datanode(nil), datanodes...)
+	var ranked []Datanode
+	for len(left) != 0 {
+		var total float64
+		for _, d := range left {
+			total += weight(d)
+		}
+		n := rand.Float64() * total
+		i := 0
+		for ; i < len(left)-1 && n >= weight(left[i]); i++ {
+			n -= weight(left[i])
+		}
+		ranked = append(ranked, left[i])
+		left = append(left[:i], left[i+1:]...)
+	}
+	return ranked
+}
+
+// rankConsistentHashing prefers the datanodes met first walking the hash ring
+// from the block, so that a block mostly stays where it is as datanodes come
+// and go
+func rankConsistentHashing(id uuid.UUID, datanodes []Datanode) []Datanode {
+	type point struct {
+		hash     uint64
+		datanode int
+	}
+	var ring []point
+	for i, d := range datanodes {
+		for v := 0; v < virtualNodes; v++ {
+			ring = append(ring, point{hash: hashOf(fmt.Sprintf("%v#%v", d.Addr, v)), datanode: i})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+
+	key := hashOf(id.String())
+	start := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= key
+	})
+	met := make(map[int]bool)
+	var ranked []Datanode
+	for i := 0; i < len(ring) && len(ranked) < len(datanodes); i++ {
+		p := ring[(start+i)%len(ring)]
+		if !met[p.datanode] {
+			met[p.datanode] = true
+			ranked = append(ranked, datanodes[p.datanode])
+		}
+	}
+	return ranked
+}
+
+func hashOf(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
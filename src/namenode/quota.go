@@ -0,0 +1,173 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"simple-distributed-storage-system/src/utils"
+)
+
+// quota limits the subtree of a dir, the usage is only tracked by the dirs
+// having a quota and kept up to date as inodes are linked and unlinked
+type quota struct {
+	Files int64 // 0 for unlimited
+	Bytes int64 // 0 for unlimited, replicas included
+
+	Used usage
+}
+
+type usage struct {
+	Files int64
+	Bytes int64
+}
+
+// spaceConsumed is the size of a file on datanodes with all its replicas
+func spaceConsumed(info *fileInfo) int64 {
+	return spaceOf(info, info.Size)
+}
+
+// spaceOf is the space the file would take up on datanodes with size bytes,
+// quotas are updated by the difference since parity is not linear in size
+func spaceOf(info *fileInfo, size uint64) int64 {
+	if info.EC != nil {
+		return int64(utils.CeilDiv(size, uint64(info.EC.Data)) * info.EC.width())
+	}
+	return int64(size) * int64(info.replication())
+}
+
+// usageOf sums up the files and the space consumed in the subtree
+func (st *namenodeState) usageOf(info *fileInfo) usage {
+	if !info.IsDir {
+		return usage{Files: 1, Bytes: spaceConsumed(info)}
+	}
+	var u usage
+	for _, child := range info.Children {
+		childUsage := st.usageOf(st.Inodes[child])
+		u.Files += childUsage.Files
+		u.Bytes += childUsage.Bytes
+	}
+	return u
+}
+
+// ancestors calls f on dir and all the dirs above it up to the root
+func (st *namenodeState) ancestors(dir *fileInfo, f func(dir *fileInfo) error) error {
+	for {
+		err := f(dir)
+		if err != nil {
+			return err
+		}
+		if dir.Inode == rootInode {
+			return nil
+		}
+		dir = st.Inodes[dir.Parent]
+	}
+}
+
+func (st *namenodeState) isAncestor(dir, info *fileInfo) bool {
+	return st.ancestors(info, func(ancestor *fileInfo) error {
+		if ancestor.Inode == dir.Inode {
+			return errors.New("found")
+		}
+		return nil
+	}) != nil
+}
+
+// addUsage updates the quotas above dir after a subtree is linked (sign 1) or
+// unlinked (sign -1), the subtree is only walked if some quota needs it
+func (st *namenodeState) addUsage(dir *fileInfo, info *fileInfo, sign int64) {
+	var u *usage
+	_ = st.ancestors(dir, func(ancestor *fileInfo) error {
+		if ancestor.Quota == nil {
+			return nil
+		}
+		if u == nil {
+			subtree := st.usageOf(info)
+			u = &subtree
+		}
+		ancestor.Quota.Used.Files += sign * u.Files
+		ancestor.Quota.Used.Bytes += sign * u.Bytes
+		return nil
+	})
+}
+
+// addBytes updates the quotas above dir after a file in it is resized
+func (st *namenodeState) addBytes(dir *fileInfo, bytes int64) {
+	_ = st.ancestors(dir, func(ancestor *fileInfo) error {
+		if ancestor.Quota != nil {
+			ancestor.Quota.Used.Bytes += bytes
+		}
+		return nil
+	})
+}
+
+// checkQuota verifies that the quotas above dir can take delta more, the
+// quotas above from are skipped since the delta moves within them
+func (st *namenodeState) checkQuota(dir *fileInfo, delta usage, from *fileInfo) error {
+	return st.ancestors(dir, func(ancestor *fileInfo) error {
+		if ancestor.Quota == nil || from != nil && st.isAncestor(ancestor, from) {
+			return nil
+		}
+		return st.checkQuotaOf(ancestor, delta)
+	})
+}
+
+// checkQuotaOf verifies that the quota of dir can take delta more
+func (st *namenodeState) checkQuotaOf(dir *fileInfo, delta usage) error {
+	q := dir.Quota
+	if q.Files != 0 && delta.Files > 0 && q.Used.Files+delta.Files > q.Files {
+		return errors.New(fmt.Sprintf("quota exceeded, dir %v allows %v files and %v are in use",
+			st.pathOf(dir), q.Files, q.Used.Files))
+	}
+	if q.Bytes != 0 && delta.Bytes > 0 && q.Used.Bytes+delta.Bytes > q.Bytes {
+		return errors.New(fmt.Sprintf("quota exceeded, dir %v allows %v bytes and %v are in use, %v more are required",
+			st.pathOf(dir), q.Bytes, q.Used.Bytes, delta.Bytes))
+	}
+	return nil
+}
+
+func (st *namenodeState) applySetQuota(c *setQuotaCommand) error {
+	info, err := st.lookup(c.Path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return errors.New(fmt.Sprintf("cannot set quota on file %v", c.Path))
+	}
+	if c.Files < 0 || c.Bytes < 0 {
+		return errors.New(fmt.Sprintf("quota of %v should not be negative", c.Path))
+	}
+
+	if c.Files == 0 && c.Bytes == 0 {
+		info.Quota = nil
+		return nil
+	}
+	if info.Quota == nil {
+		// start tracking the usage
+		info.Quota = &quota{Used: st.usageOf(info)}
+	}
+	info.Quota.Files = c.Files
+	info.Quota.Bytes = c.Bytes
+	return nil
+}
+
+// summary counts the subtree of a dir
+type summary struct {
+	Dirs  int64
+	Files int64
+	Size  int64 // replicas excluded
+	Space int64 // replicas included
+}
+
+func summarize(inodes map[uint64]*fileInfo, info *fileInfo) summary {
+	if !info.IsDir {
+		return summary{Files: 1, Size: int64(info.Size), Space: spaceConsumed(info)}
+	}
+	s := summary{Dirs: 1}
+	for _, child := range info.Children {
+		childSummary := summarize(inodes, inodes[child])
+		s.Dirs += childSummary.Dirs
+		s.Files += childSummary.Files
+		s.Size += childSummary.Size
+		s.Space += childSummary.Space
+	}
+	return s
+}
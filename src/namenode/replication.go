@@ -0,0 +1,374 @@
+package namenode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/consts"
+	"simple-distributed-storage-system/src/protos"
+	"simple-distributed-storage-system/src/utils"
+	"time"
+)
+
+// replication is the number of replicas wanted for the blocks of the file,
+// files stored before it was chosen per file have the default
+func (info *fileInfo) replication() int {
+	if info.Replication == 0 {
+		return replicaFactor
+	}
+	return info.Replication
+}
+
+func checkReplication(path string, replication int) error {
+	if replication < 1 || replication > maxReplication {
+		return errors.New(fmt.Sprintf("replication of %v should be between 1 and %v, not %v", path, maxReplication, replication))
+	}
+	return nil
+}
+
+func (st *namenodeState) checkSetReplication(path string, replication int) (*fileInfo, error) {
+	if utils.IsDir(path) {
+		return nil, errors.New(fmt.Sprintf("cannot set replication of dir %v", path))
+	}
+	err := checkReplication(path, replication)
+	if err != nil {
+		return nil, err
+	}
+	info, _, err := st.resolve(path, resolveOptions{follow: true})
+	if err != nil {
+		return nil, err
+	}
+	if info.EC != nil {
+		return nil, errors.New(fmt.Sprintf("cannot set replication of %v, it is erasure coded with %v", path, info.EC))
+	}
+
+	more := int64(info.Size) * int64(replication-info.replication())
+	err = st.checkQuota(st.Inodes[info.Parent], usage{Bytes: more}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// applySetReplication only records the replication, the leader adds or
+// removes the replicas afterwards, see replicateBlocks
+func (st *namenodeState) applySetReplication(c *setReplicationCommand) error {
+	info, err := st.checkSetReplication(c.Path, c.Replication)
+	if err != nil {
+		return err
+	}
+	st.addBytes(st.Inodes[info.Parent], int64(info.Size)*int64(c.Replication-info.replication()))
+	st.rewant(info, func() {
+		info.Replication = c.Replication
+	})
+	return nil
+}
+
+// applyReplicate records the replicas copied and dropped by the leader, the
+// dropped ones are removed from datanodes along with the reclaimed blocks,
+// the cells rebuilt for erasure coded blocks are recorded the same way
+func (st *namenodeState) applyReplicate(c *replicateCommand) error {
+	for _, replica := range c.Added {
+		block, ok := st.UUIDToLocs[replica.Id]
+		if !ok {
+			// removed while replicating, the copy is reclaimed as well
+			if st.Reclaims[replica.Id] == nil {
+				st.Reclaims[replica.Id] = make(map[int]bool)
+			}
+			st.Reclaims[replica.Id][replica.Loc] = true
+			continue
+		}
+		_, ok = st.LocToInfo[replica.Loc]
+		_, reclaiming := st.Reclaims[replica.Id][replica.Loc]
+		_, taken := block.Locs[replica.Loc]
+		if !ok || reclaiming || block.EC != nil && taken {
+			continue
+		}
+		block.Locs[replica.Loc] = true
+		if block.EC != nil {
+			block.Cells[replica.Loc] = replica.Cell
+		}
+	}
+	for _, replica := range c.Dropped {
+		block, ok := st.UUIDToLocs[replica.Id]
+		if !ok {
+			continue
+		}
+		valid, ok := block.Locs[replica.Loc]
+		if !ok {
+			continue
+		}
+		delete(block.Locs, replica.Loc)
+		delete(block.Cells, replica.Loc)
+		if st.Reclaims[replica.Id] == nil {
+			st.Reclaims[replica.Id] = make(map[int]bool)
+		}
+		st.Reclaims[replica.Id][replica.Loc] = valid
+	}
+	return nil
+}
+
+// want is the number of replicas or cells the file wants of each of its
+// blocks, 0 while it is under construction since its writer takes care of them
+func (info *fileInfo) want() int {
+	if info.UnderConstruction {
+		return 0
+	}
+	return info.width()
+}
+
+// want counts delta more references wanting the block as much
+func (b *blockInfo) want(want int, delta int) {
+	if b.Wants == nil {
+		b.Wants = make(map[int]int)
+	}
+	b.Wants[want] += delta
+	if b.Wants[want] <= 0 {
+		delete(b.Wants, want)
+	}
+}
+
+// wanted is the number of replicas wanted of the block, the most wanted by the
+// files and snapshots referring to it, or the cells of an erasure coded block,
+// -1 if a file under construction refers to it
+func (b *blockInfo) wanted() int {
+	if b.Wants[0] > 0 {
+		return -1
+	}
+	most := 0
+	for want := range b.Wants {
+		if want > most {
+			most = want
+		}
+	}
+	return most
+}
+
+// wantBlocks counts delta more references of the file wanting the blocks
+func (st *namenodeState) wantBlocks(info *fileInfo, ids []uuid.UUID, delta int) {
+	for _, id := range ids {
+		block, ok := st.UUIDToLocs[id]
+		if ok {
+			block.want(info.want(), delta)
+		}
+	}
+}
+
+// referBlocks adds a reference of the file to each of its blocks
+func (st *namenodeState) referBlocks(info *fileInfo) {
+	for _, id := range info.Ids {
+		block, ok := st.UUIDToLocs[id]
+		if ok {
+			block.Refs++
+		}
+	}
+	st.wantBlocks(info, info.Ids, 1)
+}
+
+// rewant applies a change to what the file wants of its blocks
+func (st *namenodeState) rewant(info *fileInfo, change func()) {
+	st.wantBlocks(info, info.Ids, -1)
+	change()
+	st.wantBlocks(info, info.Ids, 1)
+}
+
+func (s *namenodeServer) replicationTicker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("namenode server %v stop replication", s.addr)
+			return
+
+		case <-time.After(replicationDuration * time.Second):
+			if !s.isLeader() {
+				break // not return
+			}
+			s.replicateBlocks()
+		}
+	}
+}
+
+// replicationTask is the copying of a block planned under the lock and
+// carried out without it
+type replicationTask struct {
+	id    uuid.UUID
+	from  string         // addr of a written replica, for replicated blocks
+	ec    *ecPolicy      // for erasure coded blocks
+	cells map[int]string // cell -> addr of the datanode holding it, for erasure coded blocks
+	to    []replicaRef   // replicas or cells to put
+	addrs []string       // addr of the datanode for each of to
+}
+
+// replicateBlocks copies the blocks having fewer replicas than wanted to more
+// datanodes and drops the extra replicas of the others, replicas still being
+// written are waited for, erasure coded blocks get their lost cells rebuilt,
+// the datanodes copy without the namenode locked
+func (s *namenodeServer) replicateBlocks() {
+	s.reportUsage()
+	s.mu.Lock()
+	err := s.catchUp()
+	var tasks []replicationTask
+	var dropped []replicaRef
+	if err == nil {
+		tasks, dropped = s.planReplication()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	var added []replicaRef
+	for _, task := range tasks {
+		added = append(added, task.run()...)
+	}
+	if len(added) == 0 && len(dropped) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.catchUp() != nil {
+		return
+	}
+	dropped = append(dropped, s.state.rebuiltCells(added)...)
+	log.Infof("namenode server %v adds %v replicas and drops %v replicas", s.addr, len(added), len(dropped))
+	_ = s.syncPropose(newReplicateCommand(&replicateCommand{Added: added, Dropped: dropped}))
+}
+
+// planReplication returns the copies to make and the extra or stale replicas
+// to drop
+func (s *namenodeServer) planReplication() ([]replicationTask, []replicaRef) {
+	var tasks []replicationTask
+	var dropped []replicaRef
+	handled := 0
+	for id, block := range s.state.UUIDToLocs {
+		if handled >= replicationBatch {
+			break
+		}
+		want := block.wanted()
+		if want <= 0 {
+			continue
+		}
+		if block.EC != nil {
+			task, ok := s.planRebuild(id, block)
+			if ok {
+				handled++
+				tasks = append(tasks, task)
+			}
+			continue
+		}
+
+		// no writer is left to confirm the invalid replicas of a completed
+		// file, they are reclaimed and only the valid ones count
+		var valid []int
+		for loc, validity := range block.Locs {
+			if validity {
+				valid = append(valid, loc)
+			} else {
+				dropped = append(dropped, replicaRef{Id: id, Loc: loc})
+			}
+		}
+		if len(valid) < want && len(valid) != 0 {
+			handled++
+			task, ok := s.planCopies(id, block, valid[0], want-len(valid))
+			if ok {
+				tasks = append(tasks, task)
+			}
+		} else if len(valid) > want {
+			handled++
+			// keep the replicas where the placement policy puts them
+			excess, err := s.fetchExcessLocs(id, valid, len(valid)-want)
+			if err != nil {
+				log.Warn(err)
+				continue
+			}
+			for _, loc := range excess {
+				dropped = append(dropped, replicaRef{Id: id, Loc: loc})
+			}
+		}
+	}
+	return tasks, dropped
+}
+
+// planCopies plans to copy the block from loc to at most count more datanodes
+func (s *namenodeServer) planCopies(id uuid.UUID, block *blockInfo, from int, count int) (replicationTask, bool) {
+	var candidates, placed []int
+	for _, loc := range s.fetchAllLocs() {
+		_, ok := block.Locs[loc]
+		_, reclaiming := s.state.Reclaims[id][loc]
+		_, registered := s.state.LocToInfo[loc]
+		if !ok && !reclaiming && registered {
+			candidates = append(candidates, loc)
+		}
+	}
+	for loc := range block.Locs {
+		placed = append(placed, loc)
+	}
+	if len(candidates) < count {
+		log.Warnf("uuid %v wants %v more replicas, only %v datanodes can take it", id, count, len(candidates))
+		count = len(candidates)
+	}
+	locs, err := s.fetchReplicationLocs(id, candidates, count, placed)
+	if err != nil {
+		log.Warn(err)
+		return replicationTask{}, false
+	}
+
+	task := replicationTask{id: id, from: s.state.LocToInfo[from].Addr}
+	for _, loc := range locs {
+		task.to = append(task.to, replicaRef{Id: id, Loc: loc})
+		task.addrs = append(task.addrs, s.state.LocToInfo[loc].Addr)
+	}
+	return task, len(locs) != 0
+}
+
+// run makes the copies of the task and returns those made
+func (t replicationTask) run() []replicaRef {
+	if t.ec != nil {
+		return t.rebuild()
+	}
+
+	var added []replicaRef
+	for i, replica := range t.to {
+		err := copyReplica(t.id, t.from, t.addrs[i])
+		if err != nil {
+			log.Warn(err)
+			log.Warnf("unable to replicate uuid %v to loc %v", t.id, replica.Loc)
+			continue
+		}
+		added = append(added, replica)
+	}
+	return added
+}
+
+// copyReplica copies the replica of the block on one datanode to another
+func copyReplica(id uuid.UUID, fromAddr, toAddr string) error {
+	log.Infof("uuid %v -> copy from %v to %v", id, fromAddr, toAddr)
+
+	bin, err := id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// connect to datanode server and read data
+	datanode, conn, err := utils.ConnectToTargetDataNode(fromAddr, consts.ServiceToken)
+	if err != nil {
+		return err
+	}
+	reply, err := datanode.Read(context.Background(), &protos.ReadRequest{Uuid: bin})
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	// connect to datanode server and write data
+	datanode, conn, err = utils.ConnectToTargetDataNode(toAddr, consts.ServiceToken)
+	if err != nil {
+		return err
+	}
+	_, err = datanode.Write(context.Background(), &protos.WriteRequest{Uuid: bin, Data: reply.Data})
+	conn.Close()
+	return err
+}
@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/protos"
 	"simple-distributed-storage-system/src/utils"
-	"strings"
+	"time"
 )
 
 func (s *namenodeServer) FetchBlockAddrs(ctx context.Context, in *protos.FetchBlockAddrsRequest) (*protos.FetchBlockAddrsReply, error) {
@@ -18,177 +19,289 @@ func (s *namenodeServer) FetchBlockAddrs(ctx context.Context, in *protos.FetchBl
 	}()
 
 	// get uuids
-	info, ok := s.state.FileToInfo[in.Path]
-	if !ok {
-		return nil, errors.New(fmt.Sprintf("path %v not exists", in.Path))
-	}
 	if utils.IsDir(in.Path) {
 		return nil, errors.New(fmt.Sprintf("cannot fetch block addr for dir %v", in.Path))
 	}
-	if uint64(len(info.Ids)) <= in.Index {
-		return nil, errors.New(fmt.Sprintf("index %v out of range %v", in.Index, len(info.Ids)))
+	access := permRead
+	if in.Type != protos.FetchBlockAddrsRequestType_OP_GET {
+		access = permWrite
+	}
+	info, err := s.state.checkPermissionFollow(callerFromContext(ctx), in.Path, access)
+	if err != nil {
+		return nil, err
+	}
+	if in.Type == protos.FetchBlockAddrsRequestType_OP_GET {
+		err = checkVisible(in.Path, info)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// writers put the blocks of an append in progress
+	ids := info.Ids
+	if in.Type == protos.FetchBlockAddrsRequestType_OP_PUT {
+		ids = info.writing()
+	}
+	if uint64(len(ids)) <= in.Index {
+		return nil, errors.New(fmt.Sprintf("index %v out of range %v", in.Index, len(ids)))
 	}
 
 	// get uuid
-	id := info.Ids[in.Index]
+	id := ids[in.Index]
 	bin, err := id.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 
 	// get locs
-	locsInfo, ok := s.state.UUIDToLocs[id]
+	block, ok := s.state.UUIDToLocs[id]
 	if !ok {
 		return nil, errors.New(fmt.Sprintf("uuid %v not exists", id))
 	}
 
 	// get addrs
-	var addrs []string
-	for loc, ok := range locsInfo {
+	reply := &protos.FetchBlockAddrsReply{Uuid: bin}
+	for loc, ok := range block.Locs {
 		switch in.Type {
 		// existed
 		case protos.FetchBlockAddrsRequestType_OP_REMOVE:
 			// lazy remove
 			fallthrough
 		case protos.FetchBlockAddrsRequestType_OP_GET:
-			if ok {
-				info, ok := s.state.LocToInfo[loc]
-				if ok {
-					addrs = append(addrs, info.Addr)
-				}
+			if !ok {
+				continue
 			}
 
 		// not existed
 		case protos.FetchBlockAddrsRequestType_OP_PUT:
-			if !ok {
-				info, ok := s.state.LocToInfo[loc]
-				if ok {
-					addrs = append(addrs, info.Addr)
-				}
+			if ok {
+				continue
 			}
 		}
 
+		locInfo, ok := s.state.LocToInfo[loc]
+		if ok {
+			reply.Addrs = append(reply.Addrs, locInfo.Addr)
+			if block.EC != nil {
+				reply.Cells = append(reply.Cells, uint32(block.Cells[loc]))
+			}
+		}
+	}
+	if block.EC != nil {
+		reply.ErasureCoding = newErasureCodingPolicy(block.EC)
+	}
+	switch in.Type {
+	case protos.FetchBlockAddrsRequestType_OP_GET:
+		reply.Size = info.blockLength(in.Index)
+		reply.Token = blockToken(bin, utils.BlockRead)
+	case protos.FetchBlockAddrsRequestType_OP_PUT:
+		reply.Token = blockToken(bin, utils.BlockWrite)
 	}
 
 	log.Infof("namenode server %v get addrs %v for file %v at block #%v",
-		s.addr, addrs, in.Path, in.Index)
+		s.addr, reply.Addrs, in.Path, in.Index)
 
-	return &protos.FetchBlockAddrsReply{
-		Addrs: addrs,
-		Uuid:  bin,
-	}, nil
+	return reply, nil
 }
 
 func (s *namenodeServer) RegisterDataNode(ctx context.Context, in *protos.RegisterDataNodeRequest) (*protos.RegisterDataNodeReply, error) {
 	if !s.isLeader() {
 		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
 	}
+	if !callerFromContext(ctx).isService() {
+		return nil, errors.New(fmt.Sprintf("datanode server %v should register as the service", in.Address))
+	}
+
+	// the datanode serves before registering, its disk space is asked for
+	// without the namenode locked
+	reply, err := heartbeat(in.Address)
+	if err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
 	s.registrationInfo = registrationInfo{
-		context: true,
-		addr:    in.Address,
+		context:  true,
+		addr:     in.Address,
+		topology: newTopology(in.Zone, in.Rack),
+		usage:    usageOf(reply),
 	}
 	defer func() {
 		s.registrationInfo = registrationInfo{
 			context: false,
 			addr:    "",
 		}
-		s.syncPropose()
-		s.mu.Unlock()
 	}()
 
-	targetLoc := s.state.MaxLoc
-	log.Infof("namenode server %v trying to register datanode server %v with loc %v",
-		s.addr, in.Address, targetLoc)
+	log.Infof("namenode server %v trying to register datanode server %v in rack %v",
+		s.addr, in.Address, s.registrationInfo.topology.rackPath())
 
 	loc, err := s.isDataNodeExist(in.Address)
 	if err == nil {
 		// delete outdated datanode server
 		if !s.removeDataNodeServer(loc, in.Address) { // active remove
-			log.Warnf("namenode server %v cannot register datanode server %v with loc %v",
-				s.addr, in.Address, targetLoc)
+			log.Warnf("namenode server %v cannot register datanode server %v", s.addr, in.Address)
 			return nil, errors.New("registration failure")
 		}
 	}
 
-	// update addr <-> loc
-	s.state.LocToInfo[targetLoc] = locInfo{
-		Addr: in.Address,
+	// update addr <-> loc and increase max loc
+	targetLoc := s.state.MaxLoc
+	err = s.syncPropose(newRegisterDataNodeCommand(&registerDataNodeCommand{
+		Loc:      targetLoc,
+		Addr:     in.Address,
+		Topology: s.registrationInfo.topology,
+	}))
+	if err != nil {
+		return nil, err
 	}
+	s.usage[targetLoc] = s.registrationInfo.usage
 
 	log.Infof("namenode server %v successfully registering datanode server %v with loc %v",
 		s.addr, in.Address, targetLoc)
 
-	// increase max loc
-	s.state.MaxLoc++
-
-	return &protos.RegisterDataNodeReply{BlockSize: blockSize}, nil
+	return &protos.RegisterDataNodeReply{}, nil
 }
 
 func (s *namenodeServer) Create(ctx context.Context, in *protos.CreateRequest) (*protos.CreateReply, error) {
 	if !s.isLeader() {
 		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
 	}
+	s.reportUsage()
 	s.mu.Lock()
-	defer func() {
-		s.syncPropose()
-		s.mu.Unlock()
-	}()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
 
 	log.Infof("namenode server %v create path %v", s.addr, in.Path)
 
-	// check path existence
-	_, ok := s.state.FileToInfo[in.Path]
-	if ok {
-		return nil, errors.New(fmt.Sprintf("path %v already exists", in.Path))
+	if in.Streaming && (in.Size != 0 || utils.IsDir(in.Path)) {
+		return nil, errors.New(fmt.Sprintf("streaming create of %v should be file without a size", in.Path))
 	}
-
-	// check dir existence
-	var parentDir string
+	if !utils.IsDir(in.Path) && in.ClientName == "" {
+		return nil, errors.New(fmt.Sprintf("create of file %v should have a client name to lease it to", in.Path))
+	}
+	// dirs and symlinks have no blocks
+	file := &fileInfo{Size: in.Size}
 	if !utils.IsDir(in.Path) {
-		index := strings.LastIndex(in.Path, "/")
-		// include '/'
-		parentDir = in.Path[:index+1]
-	} else {
-		index := strings.LastIndex(in.Path[:len(in.Path)-1], "/") // remove last '/'
-		// include '/'
-		parentDir = in.Path[:index+1]
+		parent, err := s.state.lookupParent(in.Path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%v, create path %v fails", err, in.Path))
+		}
+		// files under an erasure coded dir are coded rather than replicated
+		file.EC = s.state.ecPolicyOf(parent)
+		if file.EC != nil && in.Replication != 0 {
+			return nil, errors.New(fmt.Sprintf("cannot choose replication of %v, it is erasure coded with %v", in.Path, file.EC))
+		}
+		if file.EC == nil {
+			file.Replication = replicaFactor
+			if in.Replication != 0 {
+				file.Replication = int(in.Replication)
+			}
+			err = checkReplication(in.Path, file.Replication)
+			if err != nil {
+				return nil, err
+			}
+		}
+		file.BlockSize = consts.BlockSize
+		if in.BlockSize != 0 {
+			file.BlockSize = in.BlockSize
+		}
+		err = checkBlockSize(in.Path, file.BlockSize)
+		if err != nil {
+			return nil, err
+		}
+		if file.width() > len(s.fetchAllLocs()) {
+			return nil, errors.New(fmt.Sprintf("%v of %v needs %v datanodes, there are %v", file.layout(), in.Path, file.width(), len(s.fetchAllLocs())))
+		}
 	}
-	_, ok = s.state.FileToInfo[parentDir]
-	if !ok {
-		return nil, errors.New(fmt.Sprintf("parent dir %v not exists, create path %v fails", parentDir, in.Path))
+	_, err = s.state.checkCreate(in.Path, file)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotTrash(in.Path)
+	if err != nil {
+		return nil, err
+	}
+	c := callerFromContext(ctx)
+	_, err = s.state.checkPermission(c, parentDir(in.Path), permWrite|permExec)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.Streaming {
+		err = s.syncPropose(newCreateFileCommand(&createFileCommand{
+			Path:              in.Path,
+			Owner:             c.User,
+			Time:              time.Now().UnixNano(),
+			Replication:       file.Replication,
+			BlockSize:         file.BlockSize,
+			EC:                file.EC,
+			UnderConstruction: true,
+			Holder:            leaseHolder(c, in.ClientName),
+		}))
+		if err != nil {
+			return nil, err
+		}
+		s.renewLease(leaseHolder(c, in.ClientName))
+		return &protos.CreateReply{BlockSize: file.BlockSize}, nil
 	}
 
 	// calculate blocks and assign uuids
 	var uuids []uuid.UUID
-	blocks := utils.CeilDiv(in.Size, blockSize)
+	blocks := 0
+	if !utils.IsDir(in.Path) {
+		blocks = utils.CeilDiv(in.Size, file.BlockSize)
+	}
 	for i := 0; i < blocks; i++ {
 		id := uuid.New()
 		uuids = append(uuids, id)
 		log.Infof("block #%v -> uuid %v", i, id)
 	}
-	s.state.FileToInfo[in.Path] = fileInfo{
-		Ids:  uuids,
-		Size: in.Size,
-	}
 
 	// alloc locs for uuid
+	var allLocs [][]int
 	for _, id := range uuids {
-		locs, err := s.fetchLocs(s.fetchAllLocs(), replicaFactor)
+		locs, err := s.fetchLocs(id, file.width())
 		if err != nil {
 			return nil, err
 		}
-
-		locsInfo := make(map[int]bool)
-		for _, locs := range locs {
-			locsInfo[locs] = false // invalid now
-		}
-		s.state.UUIDToLocs[id] = locsInfo
+		allLocs = append(allLocs, locs)
 
 		log.Infof("uuid %v -> locs %v", id, locs)
 	}
 
-	return &protos.CreateReply{BlockSize: blockSize}, nil
+	// files stay under construction until the client has written the blocks
+	err = s.syncPropose(newCreateFileCommand(&createFileCommand{
+		Path:              in.Path,
+		Owner:             c.User,
+		Time:              time.Now().UnixNano(),
+		Size:              in.Size,
+		Ids:               uuids,
+		Locs:              allLocs,
+		Replication:       file.Replication,
+		BlockSize:         file.BlockSize,
+		EC:                file.EC,
+		UnderConstruction: !utils.IsDir(in.Path),
+		Holder:            leaseHolder(c, in.ClientName),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	if !utils.IsDir(in.Path) {
+		s.renewLease(leaseHolder(c, in.ClientName))
+	}
+
+	return &protos.CreateReply{BlockSize: file.BlockSize}, nil
 }
 
 func (s *namenodeServer) Open(ctx context.Context, in *protos.OpenRequest) (*protos.OpenReply, error) {
@@ -199,18 +312,23 @@ func (s *namenodeServer) Open(ctx context.Context, in *protos.OpenRequest) (*pro
 
 	log.Infof("namenode server %v open path %v", s.addr, in.Path)
 
-	// check file existence
-	info, ok := s.state.FileToInfo[in.Path]
-	if !ok {
-		return nil, errors.New(fmt.Sprintf("path %v not exists", in.Path))
-	}
-
 	if utils.IsDir(in.Path) {
 		return nil, errors.New(fmt.Sprintf("cannot open dir %v", in.Path))
 	}
 
+	// check file existence
+	info, err := s.state.checkPermissionFollow(callerFromContext(ctx), in.Path, permRead)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVisible(in.Path, info)
+	if err != nil {
+		return nil, err
+	}
+	s.touchAccessTime(info)
+
 	// return blocks
-	return &protos.OpenReply{BlockSize: blockSize, Blocks: uint64(len(info.Ids))}, nil
+	return &protos.OpenReply{BlockSize: info.blockSize(), Blocks: uint64(len(info.Ids)), Size: info.Size}, nil
 }
 
 func (s *namenodeServer) LocsValidityNotify(ctx context.Context, in *protos.LocsValidityNotifyRequest) (*protos.LocsValidityNotifyReply, error) {
@@ -218,38 +336,53 @@ func (s *namenodeServer) LocsValidityNotify(ctx context.Context, in *protos.Locs
 		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
 	}
 	s.mu.Lock()
-	defer func() {
-		s.syncPropose()
-		s.mu.Unlock()
-	}()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
 
 	id := uuid.New()
-	err := id.UnmarshalBinary(in.Uuid)
+	err = id.UnmarshalBinary(in.Uuid)
 	if err != nil {
 		log.Panic(err)
 	}
 
 	log.Infof("namenode server %v receive locs validity %v for uuid %v", s.addr, in.Validity, id)
 
-	locsInfo, ok := s.state.UUIDToLocs[id]
+	block, ok := s.state.UUIDToLocs[id]
 	if !ok {
 		return nil, errors.New(fmt.Sprintf("uuid %v not exists", id))
 	}
+	err = s.state.checkBlockPermission(callerFromContext(ctx), id)
+	if err != nil {
+		return nil, err
+	}
 
+	validityInfo := make(map[int]bool)
 	for addr, validity := range in.Validity {
 		loc, err := s.isDataNodeExist(addr)
 		if err != nil {
 			log.Warnf("addr %v not exists", addr)
 		} else {
-			_, ok := locsInfo[loc]
+			_, ok := block.Locs[loc]
 			if !ok {
 				log.Warnf("loc %v not exists", addr)
 			} else {
-				s.state.UUIDToLocs[id][loc] = validity
+				validityInfo[loc] = validity
 			}
 		}
 	}
 
+	err = s.syncPropose(newSetReplicaValidityCommand(&setReplicaValidityCommand{
+		Id:       id,
+		Validity: validityInfo,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
 	return &protos.LocsValidityNotifyReply{}, nil
 }
 
@@ -262,29 +395,41 @@ func (s *namenodeServer) FetchFileInfo(ctx context.Context, in *protos.FetchFile
 	log.Infof("namenode server %v stat path %v", s.addr, in.Path)
 
 	// check file existence
-	info, ok := s.state.FileToInfo[in.Path]
-	if !ok {
-		return nil, errors.New(fmt.Sprintf("path %v not exists", in.Path))
+	info, inodes, err := s.state.lookupRead(in.Path)
+	if err != nil {
+		return nil, err
 	}
 
-	var infos []*protos.FileInfo
-	if !utils.IsDir(in.Path) { // is file
-		infos = append(infos, &protos.FileInfo{
-			Name: in.Path,
-			Size: info.Size,
-		})
-	} else { // is directory
-		for file, info := range s.state.FileToInfo {
-			if strings.HasPrefix(file, in.Path) {
-				infos = append(infos, &protos.FileInfo{
-					Name: file,
-					Size: info.Size,
-				})
-			}
+	if !info.IsDir { // is file
+		err = checkVisible(in.Path, info)
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.state.checkPermission(callerFromContext(ctx), in.Path, 0)
+		if err != nil {
+			return nil, err
 		}
+		return &protos.FetchFileInfoReply{Infos: []*protos.FileInfo{newFileInfo(in.Path, info)}}, nil
+	}
+
+	_, err = s.state.checkPermission(callerFromContext(ctx), in.Path, permRead|permExec)
+	if err != nil {
+		return nil, err
+	}
+
+	// is directory, list direct children only, a page is at most listLimit
+	limit := int(in.Limit)
+	if limit == 0 || limit > listLimit {
+		limit = listLimit
+	}
+	children, next := listChildren(inodes, info, in.Cursor, limit)
+
+	var infos []*protos.FileInfo
+	for _, child := range children {
+		infos = append(infos, newFileInfo(childPath(in.Path, child), child))
 	}
 
-	return &protos.FetchFileInfoReply{Infos: infos}, nil
+	return &protos.FetchFileInfoReply{Infos: infos, NextCursor: next}, nil
 }
 
 func (s *namenodeServer) Rename(ctx context.Context, in *protos.RenameRequest) (*protos.RenameReply, error) {
@@ -292,29 +437,1189 @@ func (s *namenodeServer) Rename(ctx context.Context, in *protos.RenameRequest) (
 		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
 	}
 	s.mu.Lock()
-	defer func() {
-		s.syncPropose()
-		s.mu.Unlock()
-	}()
+	defer s.mu.Unlock()
 
-	log.Infof("namenode server %v trying to rename %v -> %v", s.addr, in.OldPath, in.NewPath)
-
-	// check path existence
-	info, ok := s.state.FileToInfo[in.OldPath]
-	if !ok {
-		return nil, errors.New(fmt.Sprintf("path %v not exists", in.OldPath))
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
 	}
 
-	if !utils.IsDir(in.OldPath) && !utils.IsDir(in.NewPath) {
-		delete(s.state.FileToInfo, in.OldPath)
-		s.state.FileToInfo[in.NewPath] = info
+	log.Infof("namenode server %v trying to rename %v -> %v, overwrite %v", s.addr, in.OldPath, in.NewPath, in.Overwrite)
+
+	_, _, replaced, err := s.state.checkRename(in.OldPath, in.NewPath, in.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotTrash(in.NewPath)
+	if err != nil {
+		return nil, err
+	}
+	c := callerFromContext(ctx)
+	err = s.state.checkRemovePermission(c, in.OldPath)
+	if err != nil {
+		return nil, err
+	}
+	if replaced != nil {
+		err = s.state.checkRemovePermission(c, in.NewPath)
 	} else {
-		return nil, errors.New("only support rename from file to file")
+		_, err = s.state.checkPermission(c, parentDir(in.NewPath), permWrite|permExec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newRenameCommand(&renameCommand{
+		OldPath:   in.OldPath,
+		NewPath:   in.NewPath,
+		Overwrite: in.Overwrite,
+		Time:      time.Now().UnixNano(),
+	}))
+	if err != nil {
+		return nil, err
 	}
 
 	return &protos.RenameReply{}, nil
 }
 
+func (s *namenodeServer) Delete(ctx context.Context, in *protos.DeleteRequest) (*protos.DeleteReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v trying to delete %v, recursive %v, skip trash %v", s.addr, in.Path, in.Recursive, in.SkipTrash)
+
+	info, err := s.state.checkDelete(in.Path, in.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	c := callerFromContext(ctx)
+	err = s.state.checkRemovePermission(c, in.Path)
+	if err != nil {
+		return nil, err
+	}
+	err = s.state.checkSubtreePermission(c, in.Path, info)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if !in.SkipTrash && consts.TrashRetention != 0 && !utils.InTrash(in.Path) {
+		err = s.state.checkTrash(c.User)
+		if err != nil {
+			return nil, err
+		}
+		err = s.syncPropose(newMoveToTrashCommand(&moveToTrashCommand{
+			Path:       in.Path,
+			Recursive:  in.Recursive,
+			User:       c.User,
+			Checkpoint: now.Format(trashCheckpointFormat),
+			Time:       now.UnixNano(),
+		}))
+	} else {
+		// blocks are reclaimed in the background
+		err = s.syncPropose(newDeleteCommand(&deleteCommand{
+			Path:      in.Path,
+			Recursive: in.Recursive,
+			Time:      now.UnixNano(),
+		}))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.DeleteReply{}, nil
+}
+
+func (s *namenodeServer) Expunge(ctx context.Context, in *protos.ExpungeRequest) (*protos.ExpungeReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	path := utils.TrashDir(utils.UserFromContext(ctx))
+	log.Infof("namenode server %v trying to expunge %v", s.addr, path)
+
+	_, err = s.state.lookup(path)
+	if err != nil {
+		// nothing in trash
+		return &protos.ExpungeReply{}, nil
+	}
+
+	err = s.syncPropose(newDeleteCommand(&deleteCommand{
+		Path:      path,
+		Recursive: true,
+		Time:      time.Now().UnixNano(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.ExpungeReply{}, nil
+}
+
+func (s *namenodeServer) CreateSnapshot(ctx context.Context, in *protos.CreateSnapshotRequest) (*protos.CreateSnapshotReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	name := in.Name
+	if name == "" {
+		name = now.Format(snapshotNameFormat)
+	}
+
+	log.Infof("namenode server %v trying to create snapshot %v of %v", s.addr, name, in.Path)
+
+	err = s.checkSnapshotOwner(ctx, in.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newCreateSnapshotCommand(&createSnapshotCommand{
+		Path:    in.Path,
+		Name:    name,
+		Created: now.Unix(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.CreateSnapshotReply{Name: name}, nil
+}
+
+func (s *namenodeServer) DeleteSnapshot(ctx context.Context, in *protos.DeleteSnapshotRequest) (*protos.DeleteSnapshotReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v trying to delete snapshot %v of %v", s.addr, in.Name, in.Path)
+
+	err = s.checkSnapshotOwner(ctx, in.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// blocks are reclaimed in the background
+	err = s.syncPropose(newDeleteSnapshotCommand(&deleteSnapshotCommand{
+		Path: in.Path,
+		Name: in.Name,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.DeleteSnapshotReply{}, nil
+}
+
+func (s *namenodeServer) ListSnapshots(ctx context.Context, in *protos.ListSnapshotsRequest) (*protos.ListSnapshotsReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := s.state.checkPermission(callerFromContext(ctx), in.Path, permRead|permExec)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*protos.SnapshotInfo
+	for _, snap := range s.state.listSnapshots(info) {
+		snapshots = append(snapshots, &protos.SnapshotInfo{
+			Name:    snap.Name,
+			Created: snap.Created,
+		})
+	}
+
+	return &protos.ListSnapshotsReply{Snapshots: snapshots}, nil
+}
+
+func (s *namenodeServer) SnapshotDiff(ctx context.Context, in *protos.SnapshotDiffRequest) (*protos.SnapshotDiffReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.state.checkPermission(callerFromContext(ctx), in.Path, permRead|permExec)
+	if err != nil {
+		return nil, err
+	}
+	info, from, err := s.state.lookupSnapshot(in.Path, in.From)
+	if err != nil {
+		return nil, err
+	}
+
+	// compare with the current dir by default
+	toInodes, to := s.state.Inodes, info
+	if in.To != "" {
+		_, snap, err := s.state.lookupSnapshot(in.Path, in.To)
+		if err != nil {
+			return nil, err
+		}
+		toInodes, to = snap.Inodes, snap.Inodes[snap.Root]
+	}
+
+	var entries []*protos.SnapshotDiffEntry
+	for _, diff := range diffSnapshots(from.Inodes, from.Inodes[from.Root], toInodes, to, in.Path) {
+		entries = append(entries, &protos.SnapshotDiffEntry{
+			Type: diff.Type,
+			Path: diff.Path,
+		})
+	}
+
+	return &protos.SnapshotDiffReply{Entries: entries}, nil
+}
+
+// checkSnapshotOwner only lets the owner of the dir manage its snapshots
+func (s *namenodeServer) checkSnapshotOwner(ctx context.Context, path string) error {
+	c := callerFromContext(ctx)
+	info, err := s.state.checkPermission(c, path, 0)
+	if err != nil {
+		return err
+	}
+	if !c.isOwner(info) {
+		return permissionDenied(c, path)
+	}
+	return nil
+}
+
+func (s *namenodeServer) SetPermission(ctx context.Context, in *protos.SetPermissionRequest) (*protos.SetPermissionReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v trying to set mode of %v to %o", s.addr, in.Path, in.Mode)
+
+	// only the owner may change the mode
+	c := callerFromContext(ctx)
+	info, err := s.state.checkPermission(c, in.Path, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !c.isOwner(info) {
+		return nil, permissionDenied(c, in.Path)
+	}
+
+	err = s.syncPropose(newSetPermissionCommand(&setPermissionCommand{
+		Path: in.Path,
+		Mode: in.Mode,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.SetPermissionReply{}, nil
+}
+
+func (s *namenodeServer) SetOwner(ctx context.Context, in *protos.SetOwnerRequest) (*protos.SetOwnerReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v trying to set owner of %v to %v:%v", s.addr, in.Path, in.Owner, in.Group)
+
+	// only the superuser may give away files, the owner may change the group
+	// to one it is a member of
+	c := callerFromContext(ctx)
+	info, err := s.state.checkPermission(c, in.Path, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !c.isSuper() {
+		if in.Owner != "" && in.Owner != info.Owner || !c.isOwner(info) ||
+			in.Group != "" && !c.inGroup(in.Group) {
+			return nil, permissionDenied(c, in.Path)
+		}
+	}
+
+	err = s.syncPropose(newSetOwnerCommand(&setOwnerCommand{
+		Path:  in.Path,
+		Owner: in.Owner,
+		Group: in.Group,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.SetOwnerReply{}, nil
+}
+
+func (s *namenodeServer) SetTimes(ctx context.Context, in *protos.SetTimesRequest) (*protos.SetTimesReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v trying to set times of %v to mtime %v, atime %v", s.addr, in.Path, in.Mtime, in.Atime)
+
+	_, err = s.state.checkPermission(callerFromContext(ctx), in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newSetTimesCommand(&setTimesCommand{
+		Path:  in.Path,
+		Mtime: in.Mtime,
+		Atime: in.Atime,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.SetTimesReply{}, nil
+}
+
+func (s *namenodeServer) SetXAttr(ctx context.Context, in *protos.SetXAttrRequest) (*protos.SetXAttrReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v trying to set xattr %v of %v", s.addr, in.Name, in.Path)
+
+	_, err = s.state.checkSetXAttr(in.Path, in.Name, in.Value)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermission(callerFromContext(ctx), in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newSetXAttrCommand(&setXAttrCommand{
+		Path:  in.Path,
+		Name:  in.Name,
+		Value: in.Value,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.SetXAttrReply{}, nil
+}
+
+func (s *namenodeServer) GetXAttr(ctx context.Context, in *protos.GetXAttrRequest) (*protos.GetXAttrReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := s.state.checkPermission(callerFromContext(ctx), in.Path, permRead)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := info.XAttrs[in.Name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("xattr %v of %v not exists", in.Name, in.Path))
+	}
+
+	return &protos.GetXAttrReply{Value: value}, nil
+}
+
+func (s *namenodeServer) ListXAttrs(ctx context.Context, in *protos.ListXAttrsRequest) (*protos.ListXAttrsReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := s.state.checkPermission(callerFromContext(ctx), in.Path, permRead)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.ListXAttrsReply{Names: xattrNames(info)}, nil
+}
+
+func (s *namenodeServer) RemoveXAttr(ctx context.Context, in *protos.RemoveXAttrRequest) (*protos.RemoveXAttrReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v trying to remove xattr %v of %v", s.addr, in.Name, in.Path)
+
+	_, err = s.state.checkPermission(callerFromContext(ctx), in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newRemoveXAttrCommand(&removeXAttrCommand{
+		Path: in.Path,
+		Name: in.Name,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.RemoveXAttrReply{}, nil
+}
+
+func (s *namenodeServer) Copy(ctx context.Context, in *protos.CopyRequest) (*protos.CopyReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v copy %v to %v", s.addr, in.SrcPath, in.DstPath)
+
+	_, _, err = s.state.checkCopy(in.SrcPath, in.DstPath)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotTrash(in.DstPath)
+	if err != nil {
+		return nil, err
+	}
+	c := callerFromContext(ctx)
+	_, err = s.state.checkPermissionFollow(c, in.SrcPath, permRead)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermission(c, parentDir(in.DstPath), permWrite|permExec)
+	if err != nil {
+		return nil, err
+	}
+
+	// no block is written, the copy shares the blocks of the source
+	err = s.syncPropose(newCopyCommand(&copyCommand{
+		SrcPath: in.SrcPath,
+		DstPath: in.DstPath,
+		Owner:   c.User,
+		Time:    time.Now().UnixNano(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.CopyReply{}, nil
+}
+
+func (s *namenodeServer) Concat(ctx context.Context, in *protos.ConcatRequest) (*protos.ConcatReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v concat %v to %v", s.addr, in.Sources, in.Target)
+
+	_, _, err = s.state.checkConcat(in.Target, in.Sources)
+	if err != nil {
+		return nil, err
+	}
+	c := callerFromContext(ctx)
+	_, err = s.state.checkPermission(c, in.Target, permWrite)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range in.Sources {
+		err = s.state.checkRemovePermission(c, source)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// no block is written, the target takes over the blocks of the sources
+	err = s.syncPropose(newConcatCommand(&concatCommand{
+		Target:  in.Target,
+		Sources: in.Sources,
+		Time:    time.Now().UnixNano(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.ConcatReply{}, nil
+}
+
+func (s *namenodeServer) SetReplication(ctx context.Context, in *protos.SetReplicationRequest) (*protos.SetReplicationReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v set replication of %v to %v", s.addr, in.Path, in.Replication)
+
+	_, err = s.state.checkSetReplication(in.Path, int(in.Replication))
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermissionFollow(callerFromContext(ctx), in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newSetReplicationCommand(&setReplicationCommand{
+		Path:        in.Path,
+		Replication: int(in.Replication),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.SetReplicationReply{}, nil
+}
+
+func (s *namenodeServer) SetErasureCoding(ctx context.Context, in *protos.SetErasureCodingRequest) (*protos.SetErasureCodingReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v set erasure coding of %v to %v", s.addr, in.Path, in.Policy)
+
+	policy, err := parseECPolicy(in.Path, in.Policy)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkSetErasureCoding(in.Path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermission(callerFromContext(ctx), in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newSetErasureCodingCommand(&setErasureCodingCommand{
+		Path: in.Path,
+		EC:   policy,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.SetErasureCodingReply{}, nil
+}
+
+func (s *namenodeServer) Append(ctx context.Context, in *protos.AppendRequest) (*protos.AppendReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.reportUsage()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v append %v bytes to %v at %v", s.addr, in.Size, in.Path, in.Offset)
+
+	if in.ClientName == "" {
+		return nil, errors.New(fmt.Sprintf("append to %v should have a client name to lease it to", in.Path))
+	}
+	tail := uuid.Nil
+	if len(in.Tail) != 0 {
+		err = tail.UnmarshalBinary(in.Tail)
+		if err != nil {
+			return nil, err
+		}
+	}
+	info, err := s.state.checkAppend(in.Path, in.Offset, in.Size, tail)
+	if err != nil {
+		return nil, err
+	}
+	c := callerFromContext(ctx)
+	_, err = s.state.checkPermissionFollow(c, in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	// the partial last block is replaced, then new blocks follow
+	index, blocks := info.writeRange(in.Offset, in.Size)
+	var uuids []uuid.UUID
+	var allLocs [][]int
+	for i := 0; i < blocks; i++ {
+		id := uuid.New()
+		locs, err := s.fetchLocs(id, info.width())
+		if err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, id)
+		allLocs = append(allLocs, locs)
+
+		log.Infof("block #%v -> uuid %v -> locs %v", int(index)+i, id, locs)
+	}
+
+	err = s.syncPropose(newAppendCommand(&appendCommand{
+		Path:   in.Path,
+		Holder: leaseHolder(c, in.ClientName),
+		Time:   time.Now().UnixNano(),
+		Offset: in.Offset,
+		Size:   in.Size,
+		Tail:   tail,
+		Ids:    uuids,
+		Locs:   allLocs,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	s.renewLease(leaseHolder(c, in.ClientName))
+
+	return &protos.AppendReply{Index: index}, nil
+}
+
+func (s *namenodeServer) PrepareWrite(ctx context.Context, in *protos.PrepareWriteRequest) (*protos.PrepareWriteReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.reportUsage()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v prepare writing %v bytes to %v at %v", s.addr, in.Size, in.Path, in.Offset)
+
+	info, _, err := s.state.resolve(in.Path, resolveOptions{follow: true})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkWrite(in.Path, in.Offset, in.Size, info.Size)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermissionFollow(callerFromContext(ctx), in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	// the new blocks are not part of the namespace until the write commits,
+	// those not committed within a lease duration are reclaimed
+	index, blocks := info.writeRange(in.Offset, in.Size)
+	reply := &protos.PrepareWriteReply{
+		BlockSize: info.blockSize(),
+		Index:     index,
+		FileSize:  info.Size,
+	}
+	if info.EC != nil {
+		reply.ErasureCoding = newErasureCodingPolicy(info.EC)
+	}
+	var uuids []uuid.UUID
+	var allLocs [][]int
+	for i := 0; i < blocks; i++ {
+		id := uuid.New()
+		locs, err := s.fetchLocs(id, info.width())
+		if err != nil {
+			return nil, err
+		}
+		bin, err := id.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		block := &protos.BlockAddrs{Uuid: bin, Token: blockToken(bin, utils.BlockWrite)}
+		for cell, loc := range locs {
+			block.Addrs = append(block.Addrs, s.state.LocToInfo[loc].Addr)
+			if info.EC != nil {
+				block.Cells = append(block.Cells, uint32(cell))
+			}
+		}
+		reply.Blocks = append(reply.Blocks, block)
+		uuids = append(uuids, id)
+		allLocs = append(allLocs, locs)
+
+		log.Infof("block #%v -> uuid %v -> locs %v", int(index)+i, id, locs)
+	}
+
+	err = s.syncPropose(newPrepareWriteCommand(&prepareWriteCommand{
+		Path:     in.Path,
+		Time:     time.Now().UnixNano(),
+		Offset:   in.Offset,
+		Size:     in.Size,
+		FileSize: info.Size,
+		Ids:      uuids,
+		Locs:     allLocs,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+func (s *namenodeServer) CommitWrite(ctx context.Context, in *protos.CommitWriteRequest) (*protos.CommitWriteReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v commit writing %v bytes to %v at %v", s.addr, in.Size, in.Path, in.Offset)
+
+	var ids []uuid.UUID
+	for _, block := range in.Blocks {
+		id := uuid.New()
+		err = id.UnmarshalBinary(block.Uuid)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	// the prepared blocks of a rejected write cannot commit later
+	c, err := s.writeCommandOf(ctx, in, ids)
+	if err != nil {
+		s.abortWrite(in.Path, ids)
+		return nil, err
+	}
+	err = s.syncPropose(newWriteCommand(c))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.CommitWriteReply{}, nil
+}
+
+// writeCommandOf checks the write to commit and returns the command for it
+func (s *namenodeServer) writeCommandOf(ctx context.Context, in *protos.CommitWriteRequest, ids []uuid.UUID) (*writeCommand, error) {
+	info, err := s.state.checkWrite(in.Path, in.Offset, in.Size, in.FileSize)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermissionFollow(callerFromContext(ctx), in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	var allLocs [][]int
+	for i, block := range in.Blocks {
+		var locs []int
+		for _, addr := range block.Addrs {
+			loc, err := s.isDataNodeExist(addr)
+			if err != nil {
+				log.Warnf("addr %v not exists", addr)
+				continue
+			}
+			locs = append(locs, loc)
+		}
+		if len(locs) == 0 {
+			return nil, errors.New(fmt.Sprintf("no replica of uuid %v is written", ids[i]))
+		}
+		if info.EC != nil {
+			// the locs are recorded in the order of the cells
+			locs, err = cellLocs(info.EC, locs, block.Cells)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%v, uuid %v is not written", err, ids[i]))
+			}
+		}
+		allLocs = append(allLocs, locs)
+	}
+
+	c := &writeCommand{
+		Path:     in.Path,
+		Time:     time.Now().UnixNano(),
+		Offset:   in.Offset,
+		Size:     in.Size,
+		FileSize: in.FileSize,
+		Ids:      ids,
+		Locs:     allLocs,
+	}
+	_, err = s.state.checkCommitWrite(c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *namenodeServer) Truncate(ctx context.Context, in *protos.TruncateRequest) (*protos.TruncateReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.reportUsage()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v truncate %v to %v bytes", s.addr, in.Path, in.Size)
+
+	info, err := s.state.checkTruncate(in.Path, in.Size)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermissionFollow(callerFromContext(ctx), in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &truncateCommand{Path: in.Path, Size: in.Size}
+	if info.shortensTail(in.Size) {
+		// the last block is copied rather than cut in place since other
+		// files and snapshots may share it, the datanodes are not waited on
+		// under the lock
+		t, err := s.planTailCopy(in.Path, info, in.Size)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Unlock()
+		err = t.run()
+		s.mu.Lock()
+		if err == nil {
+			err = s.catchUp()
+		}
+		if err != nil {
+			s.abortWrite(in.Path, []uuid.UUID{t.id})
+			return nil, err
+		}
+		c.Ids = []uuid.UUID{t.id}
+		c.Locs = [][]int{t.locs}
+	}
+
+	c.Time = time.Now().UnixNano()
+	err = s.syncPropose(newTruncateCommand(c))
+	if err != nil {
+		s.abortWrite(in.Path, c.Ids)
+		return nil, err
+	}
+
+	return &protos.TruncateReply{}, nil
+}
+
+func (s *namenodeServer) AddBlock(ctx context.Context, in *protos.AddBlockRequest) (*protos.AddBlockReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.reportUsage()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v add block to %v", s.addr, in.Path)
+
+	c := callerFromContext(ctx)
+	info, err := s.state.checkAddBlock(in.Path, leaseHolder(c, in.ClientName))
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermission(c, in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	locs, err := s.fetchLocs(id, info.width())
+	if err != nil {
+		return nil, err
+	}
+	index := uint64(len(info.Ids))
+	log.Infof("block #%v -> uuid %v -> locs %v", index, id, locs)
+
+	err = s.syncPropose(newAddBlockCommand(&addBlockCommand{
+		Path:   in.Path,
+		Holder: leaseHolder(c, in.ClientName),
+		Id:     id,
+		Locs:   locs,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	s.renewLease(leaseHolder(c, in.ClientName))
+
+	return &protos.AddBlockReply{Index: index}, nil
+}
+
+func (s *namenodeServer) Complete(ctx context.Context, in *protos.CompleteRequest) (*protos.CompleteReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v complete %v with %v bytes", s.addr, in.Path, in.Size)
+
+	c := callerFromContext(ctx)
+	_, err = s.state.checkComplete(in.Path, in.Size, leaseHolder(c, in.ClientName))
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.state.checkPermission(c, in.Path, permWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newCompleteCommand(&completeCommand{
+		Path:   in.Path,
+		Holder: leaseHolder(c, in.ClientName),
+		Time:   time.Now().UnixNano(),
+		Size:   in.Size,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.CompleteReply{}, nil
+}
+
+func (s *namenodeServer) RenewLease(ctx context.Context, in *protos.RenewLeaseRequest) (*protos.RenewLeaseReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	// a client renews only the leases it holds as the calling user
+	s.renewLease(leaseHolder(callerFromContext(ctx), in.ClientName))
+
+	return &protos.RenewLeaseReply{}, nil
+}
+
+func (s *namenodeServer) CreateSymlink(ctx context.Context, in *protos.CreateSymlinkRequest) (*protos.CreateSymlinkReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v create symlink %v -> %v", s.addr, in.Path, in.Target)
+
+	_, err = s.state.checkCreateSymlink(in.Path, in.Target)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotTrash(in.Path)
+	if err != nil {
+		return nil, err
+	}
+	c := callerFromContext(ctx)
+	_, err = s.state.checkPermission(c, parentDir(in.Path), permWrite|permExec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncPropose(newCreateSymlinkCommand(&createSymlinkCommand{
+		Path:   in.Path,
+		Target: in.Target,
+		Owner:  c.User,
+		Time:   time.Now().UnixNano(),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.CreateSymlinkReply{}, nil
+}
+
+func (s *namenodeServer) SetQuota(ctx context.Context, in *protos.SetQuotaRequest) (*protos.SetQuotaReply, error) {
+	if !s.isLeader() {
+		return nil, errors.New(fmt.Sprintf("namenode server %v is not leader", s.addr))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.catchUp()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("namenode server %v trying to set quota of %v to %v files and %v bytes", s.addr, in.Path, in.Files, in.Bytes)
+
+	// quotas are set by administrators
+	c := callerFromContext(ctx)
+	if !c.isSuper() {
+		return nil, permissionDenied(c, in.Path)
+	}
+
+	err = s.syncPropose(newSetQuotaCommand(&setQuotaCommand{
+		Path:  in.Path,
+		Files: in.Files,
+		Bytes: in.Bytes,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.SetQuotaReply{}, nil
+}
+
+func (s *namenodeServer) Count(ctx context.Context, in *protos.CountRequest) (*protos.CountReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	access := permRead | permExec
+	if !utils.IsDir(in.Path) {
+		access = 0
+	}
+	_, err := s.state.checkPermission(callerFromContext(ctx), in.Path, access)
+	if err != nil {
+		return nil, err
+	}
+	info, inodes, err := s.state.lookupRead(in.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := summarize(inodes, info)
+	reply := &protos.CountReply{
+		Dirs:          sum.Dirs,
+		Files:         sum.Files,
+		Size:          sum.Size,
+		SpaceConsumed: sum.Space,
+	}
+	if info.Quota != nil {
+		reply.FileQuota = info.Quota.Files
+		reply.ByteQuota = info.Quota.Bytes
+	}
+	return reply, nil
+}
+
+func (s *namenodeServer) FetchMisplacedBlocks(ctx context.Context, in *protos.FetchMisplacedBlocksRequest) (*protos.FetchMisplacedBlocksReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the report covers the whole namespace
+	c := callerFromContext(ctx)
+	if !c.isSuper() {
+		return nil, permissionDenied(c, "/")
+	}
+
+	var blocks []*protos.MisplacedBlock
+	for _, block := range s.state.misplacedBlocks() {
+		var addrs []string
+		for _, loc := range block.locs {
+			addrs = append(addrs, s.state.LocToInfo[loc].Addr)
+		}
+		blocks = append(blocks, &protos.MisplacedBlock{
+			Path:   block.path,
+			Index:  uint64(block.index),
+			Addrs:  addrs,
+			Domain: block.domain,
+		})
+	}
+
+	return &protos.FetchMisplacedBlocksReply{Blocks: blocks}, nil
+}
+
+func newFileInfo(path string, info *fileInfo) *protos.FileInfo {
+	reply := &protos.FileInfo{
+		Name:              path,
+		Size:              info.Size,
+		Owner:             info.Owner,
+		Group:             info.Group,
+		Mode:              info.Mode,
+		Ctime:             info.Ctime,
+		Mtime:             info.Mtime,
+		Atime:             info.Atime,
+		Target:            info.Target,
+		UnderConstruction: info.UnderConstruction,
+	}
+	if info.EC != nil {
+		reply.ErasureCoding = info.EC.String()
+	}
+	if !info.IsDir && info.Target == "" {
+		if info.EC == nil {
+			reply.Replication = uint32(info.replication())
+		}
+		reply.BlockSize = info.blockSize()
+	}
+	return reply
+}
+
 func (s *namenodeServer) IsLeader(ctx context.Context, in *protos.IsLeaderRequest) (*protos.IsLeaderReply, error) {
 	return &protos.IsLeaderReply{Res: s.isLeader()}, nil
 }
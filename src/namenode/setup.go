@@ -3,7 +3,6 @@ package namenode
 import (
 	"context"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/lni/dragonboat/v4"
 	"github.com/lni/dragonboat/v4/config"
 	"github.com/lni/dragonboat/v4/logger"
@@ -15,6 +14,7 @@ import (
 	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/protos"
 	"simple-distributed-storage-system/src/utils"
+	"time"
 )
 
 const (
@@ -22,21 +22,19 @@ const (
 )
 
 func NewNameNodeServer(addr string, replicaID uint64) *namenodeServer {
-	res := &namenodeServer{
+	placement, err := newPlacementPolicy(consts.PlacementPolicy)
+	if err != nil {
+		log.Panic(err)
+	}
+	return &namenodeServer{
 		addr:      addr,
 		replicaID: replicaID,
-		state: namenodeState{
-			LocToInfo:  make(map[int]locInfo),
-			FileToInfo: make(map[string]fileInfo),
-			UUIDToLocs: make(map[uuid.UUID]map[int]bool),
-		},
+		state:     newNamenodeState(),
+		leases:    make(map[string]time.Time),
+		usage:     make(map[int]diskUsage),
+		atimes:    make(map[uint64]int64),
+		placement: placement,
 	}
-	// setup root path
-	res.state.FileToInfo["/"] = fileInfo{
-		Ids:  []uuid.UUID{},
-		Size: 0,
-	}
-	return res
 }
 
 func (s *namenodeServer) Setup(ctx context.Context) {
@@ -54,7 +52,11 @@ func (s *namenodeServer) Setup(ctx context.Context) {
 	if err != nil {
 		log.Panic(err)
 	}
-	server := grpc.NewServer(grpc.StatsHandler(zipkingrpc.NewServerHandler(tracer)))
+	auth, err := utils.NewAuthenticator()
+	if err != nil {
+		log.Panic(err)
+	}
+	server := grpc.NewServer(grpc.StatsHandler(zipkingrpc.NewServerHandler(tracer)), grpc.UnaryInterceptor(utils.AuthInterceptor(auth)))
 	protos.RegisterNameNodeServer(server, s)
 
 	go func() {
@@ -70,6 +72,21 @@ func (s *namenodeServer) Setup(ctx context.Context) {
 	// start sync read
 	go s.syncRead(ctx)
 
+	// start reclaim ticker
+	go s.reclaimTicker(ctx)
+
+	// start trash ticker
+	go s.trashTicker(ctx)
+
+	// start lease ticker
+	go s.leaseTicker(ctx)
+
+	// start replication ticker
+	go s.replicationTicker(ctx)
+
+	// start access time ticker
+	go s.accessTimeTicker(ctx)
+
 	// blocked here
 	select {
 	case <-ctx.Done():
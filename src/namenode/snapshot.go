@@ -0,0 +1,223 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"simple-distributed-storage-system/src/protos"
+	"sort"
+)
+
+// snapshotNameFormat names the snapshots taken without a name, after the leader clock in UTC
+const snapshotNameFormat = "s20060102-150405.000"
+
+// snapshot is a frozen copy of a dir subtree, the blocks it refers to are
+// kept until the snapshot is deleted
+type snapshot struct {
+	Name    string
+	Created int64 // unix seconds
+	Root    uint64
+	Inodes  map[uint64]*fileInfo
+}
+
+type snapshotDiff struct {
+	Type protos.SnapshotDiffType
+	Path string
+}
+
+func checkSnapshotName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return errors.New(fmt.Sprintf("snapshot name %v is invalid", name))
+	}
+	for _, r := range name {
+		if r == '/' {
+			return errors.New(fmt.Sprintf("snapshot name %v is invalid", name))
+		}
+	}
+	return nil
+}
+
+// hasSnapshots tells whether any dir in the subtree has been snapshotted
+func (st *namenodeState) hasSnapshots(info *fileInfo) bool {
+	if !info.IsDir {
+		return false
+	}
+	if len(st.Snapshots[info.Inode]) != 0 {
+		return true
+	}
+	for _, child := range info.Children {
+		if st.hasSnapshots(st.Inodes[child]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *namenodeState) checkCreateSnapshot(path, name string) (*fileInfo, error) {
+	info, err := st.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir {
+		return nil, errors.New(fmt.Sprintf("cannot snapshot file %v", path))
+	}
+	err = checkSnapshotName(name)
+	if err != nil {
+		return nil, err
+	}
+	_, ok := st.Snapshots[info.Inode][name]
+	if ok {
+		return nil, errors.New(fmt.Sprintf("snapshot %v of %v already exists", name, path))
+	}
+	return info, nil
+}
+
+func (st *namenodeState) applyCreateSnapshot(c *createSnapshotCommand) error {
+	info, err := st.checkCreateSnapshot(c.Path, c.Name)
+	if err != nil {
+		return err
+	}
+
+	snap := &snapshot{
+		Name:    c.Name,
+		Created: c.Created,
+		Root:    info.Inode,
+		Inodes:  make(map[uint64]*fileInfo),
+	}
+	st.freeze(info, snap.Inodes)
+
+	if st.Snapshots[info.Inode] == nil {
+		st.Snapshots[info.Inode] = make(map[string]*snapshot)
+	}
+	st.Snapshots[info.Inode][c.Name] = snap
+	return nil
+}
+
+// freeze copies the subtree into inodes and refers to its blocks, a file being
+// appended to is kept as written so far, one still being created is left out
+func (st *namenodeState) freeze(info *fileInfo, inodes map[uint64]*fileInfo) {
+	frozen := *info
+	frozen.Quota = nil
+	frozen.XAttrs = make(map[string][]byte, len(info.XAttrs))
+	for name, value := range info.XAttrs {
+		frozen.XAttrs[name] = value
+	}
+	frozen.Ids = append([]uuid.UUID(nil), info.Ids...)
+	frozen.UnderConstruction = false
+	frozen.Holder = ""
+	frozen.Appending = nil
+	frozen.Children = make(map[string]uint64, len(info.Children))
+	frozen.Names = nil
+	for _, name := range info.Names {
+		child := info.Children[name]
+		if st.Inodes[child].Pending {
+			continue
+		}
+		frozen.Children[name] = child
+		frozen.Names = append(frozen.Names, name)
+	}
+	inodes[frozen.Inode] = &frozen
+	st.referBlocks(&frozen)
+	for _, child := range frozen.Children {
+		st.freeze(st.Inodes[child], inodes)
+	}
+}
+
+func (st *namenodeState) lookupSnapshot(path, name string) (*fileInfo, *snapshot, error) {
+	info, err := st.lookup(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	snap, ok := st.Snapshots[info.Inode][name]
+	if !ok {
+		return nil, nil, errors.New(fmt.Sprintf("snapshot %v of %v not exists", name, path))
+	}
+	return info, snap, nil
+}
+
+func (st *namenodeState) applyDeleteSnapshot(c *deleteSnapshotCommand) error {
+	info, snap, err := st.lookupSnapshot(c.Path, c.Name)
+	if err != nil {
+		return err
+	}
+
+	// blocks only kept by the snapshot are reclaimed
+	for _, frozen := range snap.Inodes {
+		for _, id := range frozen.Ids {
+			st.releaseBlock(frozen, id)
+		}
+	}
+
+	delete(st.Snapshots[info.Inode], c.Name)
+	if len(st.Snapshots[info.Inode]) == 0 {
+		delete(st.Snapshots, info.Inode)
+	}
+	return nil
+}
+
+// listSnapshots returns the snapshots of the dir, oldest first
+func (st *namenodeState) listSnapshots(info *fileInfo) []*snapshot {
+	var snaps []*snapshot
+	for _, snap := range st.Snapshots[info.Inode] {
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		if snaps[i].Created != snaps[j].Created {
+			return snaps[i].Created < snaps[j].Created
+		}
+		return snaps[i].Name < snaps[j].Name
+	})
+	return snaps
+}
+
+// diffSnapshots compares two versions of the same dir, renames show up as a
+// deletion and a creation
+func diffSnapshots(fromInodes map[uint64]*fileInfo, from *fileInfo, toInodes map[uint64]*fileInfo, to *fileInfo, prefix string) []snapshotDiff {
+	names := make(map[string]bool)
+	for name := range from.Children {
+		names[name] = true
+	}
+	for name := range to.Children {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var diffs []snapshotDiff
+	for _, name := range sorted {
+		fromId, inFrom := from.Children[name]
+		toId, inTo := to.Children[name]
+		switch {
+		case !inTo:
+			diffs = append(diffs, snapshotDiff{protos.SnapshotDiffType_DIFF_DELETE, childPath(prefix, fromInodes[fromId])})
+		case !inFrom:
+			diffs = append(diffs, snapshotDiff{protos.SnapshotDiffType_DIFF_CREATE, childPath(prefix, toInodes[toId])})
+		default:
+			fromChild, toChild := fromInodes[fromId], toInodes[toId]
+			if fromChild.IsDir != toChild.IsDir || fromChild.Inode != toChild.Inode {
+				diffs = append(diffs, snapshotDiff{protos.SnapshotDiffType_DIFF_DELETE, childPath(prefix, fromChild)})
+				diffs = append(diffs, snapshotDiff{protos.SnapshotDiffType_DIFF_CREATE, childPath(prefix, toChild)})
+			} else if fromChild.IsDir {
+				diffs = append(diffs, diffSnapshots(fromInodes, fromChild, toInodes, toChild, childPath(prefix, toChild))...)
+			} else if !sameBlocks(fromChild, toChild) {
+				diffs = append(diffs, snapshotDiff{protos.SnapshotDiffType_DIFF_MODIFY, childPath(prefix, toChild)})
+			}
+		}
+	}
+	return diffs
+}
+
+func sameBlocks(a, b *fileInfo) bool {
+	if a.Size != b.Size || len(a.Ids) != len(b.Ids) {
+		return false
+	}
+	for i := range a.Ids {
+		if a.Ids[i] != b.Ids[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -3,7 +3,6 @@ package namenode
 import (
 	"bytes"
 	"encoding/gob"
-	"github.com/google/uuid"
 	sm "github.com/lni/dragonboat/v4/statemachine"
 	log "github.com/sirupsen/logrus"
 	"io"
@@ -16,16 +15,14 @@ type StateMachine struct {
 }
 
 func (s *StateMachine) Update(entry sm.Entry) (sm.Result, error) {
-	r := bytes.NewBuffer(entry.Cmd)
-	decoder := gob.NewDecoder(r)
-	var state namenodeState
-	err := decoder.Decode(&state)
+	cmd := decodeCommand(entry.Cmd)
+	err := s.State.apply(cmd)
 	if err != nil {
-		log.Panic(err)
+		log.Infof("replica %v reject command %v at index %v, %v", s.ReplicaID, cmd.Type, entry.Index, err)
+		return sm.Result{Value: resultRejected, Data: []byte(err.Error())}, nil
 	}
-	s.State = state
-	log.Infof("replica %v update state machine %v", s.ReplicaID, s.State)
-	return sm.Result{Value: uint64(len(entry.Cmd))}, nil
+	log.Infof("replica %v apply command %v at index %v", s.ReplicaID, cmd.Type, entry.Index)
+	return sm.Result{Value: resultApplied}, nil
 }
 
 func (s *StateMachine) Lookup(i interface{}) (interface{}, error) {
@@ -62,6 +59,7 @@ func (s *StateMachine) RecoverFromSnapshot(reader io.Reader, files []sm.Snapshot
 	if err != nil {
 		log.Panic(err)
 	}
+	state.makeMaps()
 	s.State = state
 	return nil
 }
@@ -74,10 +72,6 @@ func NewStateMachine(shardID uint64, replicaID uint64) sm.IStateMachine {
 	return &StateMachine{
 		ShardID:   shardID,
 		ReplicaID: replicaID,
-		State: namenodeState{
-			LocToInfo:  make(map[int]locInfo),
-			FileToInfo: make(map[string]fileInfo),
-			UUIDToLocs: make(map[uuid.UUID]map[int]bool),
-		},
+		State:     newNamenodeState(),
 	}
 }
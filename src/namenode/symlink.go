@@ -0,0 +1,34 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"simple-distributed-storage-system/src/utils"
+)
+
+// symlinks carry every permission, access is checked on their targets
+const symlinkMode = 0777
+
+func (st *namenodeState) checkCreateSymlink(path, target string) (*fileInfo, error) {
+	if utils.IsDir(path) {
+		return nil, errors.New(fmt.Sprintf("symlink %v should not end with /", path))
+	}
+	if target == "" {
+		return nil, errors.New(fmt.Sprintf("symlink %v should have a target", path))
+	}
+	return st.checkCreate(path, &fileInfo{})
+}
+
+// applyCreateSymlink links a symlink to the target, which is not required
+// to exist, a relative target is resolved against the dir of the symlink
+func (st *namenodeState) applyCreateSymlink(c *createSymlinkCommand) error {
+	parent, err := st.checkCreateSymlink(c.Path, c.Target)
+	if err != nil {
+		return err
+	}
+
+	info := st.newInode(parent, baseName(c.Path), false, c.Owner, c.Time)
+	info.Target = c.Target
+	info.Mode = symlinkMode
+	return nil
+}
@@ -0,0 +1,102 @@
+package namenode
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/consts"
+	"time"
+)
+
+const accessTimeDuration = 5
+
+func (st *namenodeState) applySetTimes(c *setTimesCommand) error {
+	info, err := st.lookup(c.Path)
+	if err != nil {
+		return err
+	}
+	if c.Mtime >= 0 {
+		info.Mtime = c.Mtime
+	}
+	if c.Atime >= 0 {
+		info.Atime = c.Atime
+	}
+	return nil
+}
+
+// applySetAccessTimes skips the files gone since the reads were batched
+func (st *namenodeState) applySetAccessTimes(c *setAccessTimesCommand) {
+	for path, atime := range c.Atimes {
+		info, err := st.lookup(path)
+		if err != nil || info.Atime >= atime {
+			continue
+		}
+		info.Atime = atime
+	}
+}
+
+// touchAccessTime queues a read of the file on the leader, at most once per
+// consts.AccessTimePrecision, reads are recorded in batches by the ticker to
+// keep them off the raft log
+func (s *namenodeServer) touchAccessTime(info *fileInfo) {
+	now := time.Now()
+	if consts.AccessTimePrecision == 0 || !s.isLeader() ||
+		now.Sub(time.Unix(0, info.Atime)) < consts.AccessTimePrecision {
+		return
+	}
+	// files in snapshots are frozen
+	if s.state.Inodes[info.Inode] != info {
+		return
+	}
+	s.atimes[info.Inode] = now.UnixNano()
+}
+
+// flushAccessTimes records the queued reads of the files still there
+func (s *namenodeServer) flushAccessTimes() {
+	if len(s.atimes) == 0 {
+		return
+	}
+	err := s.catchUp()
+	if err != nil {
+		log.Warnf("namenode server %v cannot update access times, %v", s.addr, err)
+		return
+	}
+
+	// catching up replaces the inodes, the files are looked up again
+	atimes := make(map[string]int64)
+	for inode, atime := range s.atimes {
+		info, ok := s.state.Inodes[inode]
+		if ok && info.Atime < atime {
+			// the file may have been opened through symlinks or renamed since
+			atimes[s.state.pathOf(info)] = atime
+		}
+	}
+	s.atimes = make(map[uint64]int64)
+	if len(atimes) == 0 {
+		return
+	}
+
+	err = s.syncPropose(newSetAccessTimesCommand(&setAccessTimesCommand{Atimes: atimes}))
+	if err != nil {
+		log.Warnf("namenode server %v cannot update access times of %v files, %v", s.addr, len(atimes), err)
+	}
+}
+
+func (s *namenodeServer) accessTimeTicker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("namenode server %v stop access time updates", s.addr)
+			return
+
+		case <-time.After(accessTimeDuration * time.Second):
+			s.mu.Lock()
+			if s.isLeader() {
+				s.flushAccessTimes()
+			} else {
+				// reads queued in an earlier term are left to the new leader
+				s.atimes = make(map[uint64]int64)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
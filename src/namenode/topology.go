@@ -0,0 +1,141 @@
+package namenode
+
+import (
+	"simple-distributed-storage-system/src/utils"
+	"sort"
+)
+
+// datanodes registered without a zone or a rack are put in these
+const (
+	defaultZone = "default-zone"
+	defaultRack = "default-rack"
+)
+
+// failure domains of a datanode, a rack lies in a zone
+type topology struct {
+	Zone string
+	Rack string
+}
+
+func newTopology(zone, rack string) topology {
+	if zone == "" {
+		zone = defaultZone
+	}
+	if rack == "" {
+		rack = defaultRack
+	}
+	return topology{Zone: zone, Rack: rack}
+}
+
+// zonePath and rackPath name the domains as paths, racks of different zones
+// may have the same name
+func (t topology) zonePath() string {
+	return "/" + t.Zone
+}
+
+func (t topology) rackPath() string {
+	return "/" + t.Zone + "/" + t.Rack
+}
+
+// spread counts the replicas in each zone and each rack
+type spread struct {
+	zones map[string]int
+	racks map[string]int
+}
+
+func newSpread() spread {
+	return spread{zones: make(map[string]int), racks: make(map[string]int)}
+}
+
+func (sp spread) add(t topology) {
+	sp.zones[t.zonePath()]++
+	sp.racks[t.rackPath()]++
+}
+
+// better tells if a replica is spread wider in a than in b, zones go first
+func (sp spread) better(a, b topology) bool {
+	if sp.zones[a.zonePath()] != sp.zones[b.zonePath()] {
+		return sp.zones[a.zonePath()] < sp.zones[b.zonePath()]
+	}
+	return sp.racks[a.rackPath()] < sp.racks[b.rackPath()]
+}
+
+// shared returns the domain holding more than one of the replicas although
+// there are other domains to hold them, zones go first, empty if the replicas
+// are spread as wide as the cluster allows
+func (sp spread) shared(cluster spread, replicas int) string {
+	if len(sp.zones) < utils.Min(uint64(replicas), uint64(len(cluster.zones))) {
+		return mostShared(sp.zones)
+	}
+	if len(sp.racks) < utils.Min(uint64(replicas), uint64(len(cluster.racks))) {
+		return mostShared(sp.racks)
+	}
+	return ""
+}
+
+// mostShared returns the domain with the most replicas, the first by name
+// among the ties
+func mostShared(domains map[string]int) string {
+	var names []string
+	for name := range domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	most := ""
+	for _, name := range names {
+		if most == "" || domains[name] > domains[most] {
+			most = name
+		}
+	}
+	return most
+}
+
+// misplacedBlock is a block of a file whose replicas share a domain
+type misplacedBlock struct {
+	path   string
+	index  int
+	locs   []int
+	domain string
+}
+
+// misplacedBlocks returns the blocks of the files in the namespace whose
+// replicas or cells on registered datanodes share a domain although the
+// cluster has more domains, sorted by path and index
+func (st *namenodeState) misplacedBlocks() []misplacedBlock {
+	cluster := newSpread()
+	for _, info := range st.LocToInfo {
+		cluster.add(info.Topology)
+	}
+
+	var misplaced []misplacedBlock
+	for _, info := range st.Inodes {
+		for i, id := range info.Ids {
+			block, ok := st.UUIDToLocs[id]
+			if !ok {
+				continue
+			}
+			sp := newSpread()
+			var locs []int
+			for loc := range block.Locs {
+				locInfo, ok := st.LocToInfo[loc]
+				if ok {
+					sp.add(locInfo.Topology)
+					locs = append(locs, loc)
+				}
+			}
+			domain := sp.shared(cluster, len(locs))
+			if domain != "" {
+				sort.Ints(locs)
+				misplaced = append(misplaced, misplacedBlock{path: st.pathOf(info), index: i, locs: locs, domain: domain})
+			}
+		}
+	}
+
+	sort.Slice(misplaced, func(i, j int) bool {
+		if misplaced[i].path != misplaced[j].path {
+			return misplaced[i].path < misplaced[j].path
+		}
+		return misplaced[i].index < misplaced[j].index
+	})
+	return misplaced
+}
@@ -0,0 +1,149 @@
+package namenode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/consts"
+	"simple-distributed-storage-system/src/utils"
+	"time"
+)
+
+const (
+	trashDuration = 60
+
+	// checkpoints are named after the leader clock in UTC
+	trashCheckpointFormat = "20060102150405"
+)
+
+// checkNotTrash rejects paths in the trash, only the namenode moves entries there
+func checkNotTrash(path string) error {
+	if utils.InTrash(path) || path+"/" == utils.TrashRoot {
+		return errors.New(fmt.Sprintf("path %v is in trash, only deletes move paths there", path))
+	}
+	return nil
+}
+
+// checkTrash checks that the trash root and the trash dir of the user, if they
+// exist, are still the ones the namenode made
+func (st *namenodeState) checkTrash(user string) error {
+	root, err := st.lookup(utils.TrashRoot)
+	if err != nil {
+		return nil
+	}
+	if root.Owner != consts.SuperUser || root.Mode != trashRootMode {
+		return errors.New(fmt.Sprintf("trash root %v should be owned by %v with mode %v", utils.TrashRoot, consts.SuperUser, utils.FormatMode(true, trashRootMode)))
+	}
+	trash, err := st.lookup(utils.TrashDir(user))
+	if err != nil {
+		return nil
+	}
+	if trash.Owner != user || trash.Mode != trashDirMode {
+		return errors.New(fmt.Sprintf("trash dir %v should be owned by %v with mode %v", utils.TrashDir(user), user, utils.FormatMode(true, trashDirMode)))
+	}
+	return nil
+}
+
+func (st *namenodeState) applyMoveToTrash(c *moveToTrashCommand) error {
+	info, err := st.checkDelete(c.Path, c.Recursive)
+	if err != nil {
+		return err
+	}
+	err = st.checkTrash(c.User)
+	if err != nil {
+		return err
+	}
+
+	_, err = st.lookup(utils.TrashRoot)
+	newRoot := err != nil
+	_, err = st.lookup(utils.TrashDir(c.User))
+	newTrash := err != nil
+
+	// keep the original location under the checkpoint for restoring
+	dir, err := st.mkdirAll(utils.TrashDir(c.User)+c.Checkpoint+parentDir(c.Path), c.User, c.Time)
+	if err != nil {
+		return err
+	}
+
+	// every user keeps a private trash dir under the shared trash root
+	if newRoot {
+		root, _ := st.lookup(utils.TrashRoot)
+		root.Owner = consts.SuperUser
+		root.Group = consts.SuperGroup
+		root.Mode = trashRootMode
+	}
+	if newTrash {
+		trash, _ := st.lookup(utils.TrashDir(c.User))
+		trash.Mode = trashDirMode
+	}
+
+	name := info.Name
+	for i := 1; ; i++ {
+		_, ok := dir.Children[name]
+		if !ok {
+			break
+		}
+		name = fmt.Sprintf("%v.%v", info.Name, i)
+	}
+
+	st.Inodes[info.Parent].Mtime = c.Time
+	st.unlink(info)
+	info.Name = name
+	st.link(dir, info)
+	dir.Mtime = c.Time
+	return nil
+}
+
+// expiredCheckpoints returns the trash checkpoints older than the retention
+func (st *namenodeState) expiredCheckpoints(now time.Time, retention time.Duration) []string {
+	root, err := st.lookup(utils.TrashRoot)
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for user, id := range root.Children {
+		dir := st.Inodes[id]
+		for checkpoint := range dir.Children {
+			t, err := time.ParseInLocation(trashCheckpointFormat, checkpoint, time.UTC)
+			if err != nil {
+				continue
+			}
+			if now.Sub(t) > retention {
+				paths = append(paths, utils.TrashDir(user)+childPath("", st.Inodes[dir.Children[checkpoint]]))
+			}
+		}
+	}
+	return paths
+}
+
+func (s *namenodeServer) trashTicker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infof("namenode server %v stop trash purging", s.addr)
+			return
+
+		case <-time.After(trashDuration * time.Second):
+			if !s.isLeader() || consts.TrashRetention == 0 {
+				break // not return
+			}
+
+			s.mu.Lock()
+			if s.catchUp() == nil {
+				now := time.Now().UTC()
+				for _, path := range s.state.expiredCheckpoints(now, consts.TrashRetention) {
+					log.Infof("namenode server %v purge trash checkpoint %v", s.addr, path)
+					// blocks are reclaimed in the background
+					_ = s.syncPropose(newDeleteCommand(&deleteCommand{
+						Path:      path,
+						Recursive: true,
+						Time:      now.UnixNano(),
+					}))
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package namenode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"simple-distributed-storage-system/src/consts"
+	"simple-distributed-storage-system/src/erasure"
+	"simple-distributed-storage-system/src/protos"
+	"simple-distributed-storage-system/src/utils"
+	"time"
+)
+
+// blockSize is the size of every block of the file but the last
+func (info *fileInfo) blockSize() uint64 {
+	if info.BlockSize == 0 {
+		return defaultBlockSize
+	}
+	return info.BlockSize
+}
+
+func checkBlockSize(path string, blockSize uint64) error {
+	if blockSize == 0 || blockSize > utils.MaxBlockSize {
+		return errors.New(fmt.Sprintf("block size of %v should be between 1 and %v, not %v", path, utils.MaxBlockSize, blockSize))
+	}
+	return nil
+}
+
+// writeRange returns the index of the first block covering [offset, offset+size)
+// and the number of blocks, blocks past the end of the file included
+func (info *fileInfo) writeRange(offset, size uint64) (uint64, int) {
+	index := offset / info.blockSize()
+	return index, utils.CeilDiv(offset+size, info.blockSize()) - int(index)
+}
+
+// checkWrite returns the file to overwrite, which must still have the size
+// the write was prepared against, the write may grow the file but not leave
+// a hole in it
+func (st *namenodeState) checkWrite(path string, offset, size, fileSize uint64) (*fileInfo, error) {
+	if utils.IsDir(path) {
+		return nil, errors.New(fmt.Sprintf("cannot write to dir %v", path))
+	}
+	if size == 0 {
+		return nil, errors.New(fmt.Sprintf("nothing to write to %v", path))
+	}
+	info, _, err := st.resolve(path, resolveOptions{follow: true})
+	if err != nil {
+		return nil, err
+	}
+	if info.UnderConstruction {
+		return nil, underConstruction(path)
+	}
+	if info.Size != fileSize {
+		return nil, errors.New(fmt.Sprintf("file %v has %v bytes, not %v, it has changed", path, info.Size, fileSize))
+	}
+	if offset > info.Size {
+		return nil, errors.New(fmt.Sprintf("offset %v is beyond the end of file %v with %v bytes", offset, path, info.Size))
+	}
+
+	if offset+size > info.Size {
+		err = st.checkQuota(st.Inodes[info.Parent], usage{Bytes: spaceOf(info, offset+size) - spaceConsumed(info)}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// preparedBlock is a block issued to a write of the file, placed at Locs
+type preparedBlock struct {
+	Inode    uint64
+	Locs     []int
+	Time     int64     // unix nanoseconds after the leader clock, when it was issued
+	Replaces uuid.UUID // the block of the file it is written in place of, uuid.Nil past the end
+}
+
+func (st *namenodeState) applyPrepareWrite(c *prepareWriteCommand) error {
+	info, err := st.checkWrite(c.Path, c.Offset, c.Size, c.FileSize)
+	if err != nil {
+		return err
+	}
+	index, _ := info.writeRange(c.Offset, c.Size)
+	for i, id := range c.Ids {
+		st.Prepared[id] = &preparedBlock{Inode: info.Inode, Locs: c.Locs[i], Time: c.Time, Replaces: info.blockAt(index + uint64(i))}
+	}
+	return nil
+}
+
+// blockAt returns the block at index of the file, uuid.Nil past the end
+func (info *fileInfo) blockAt(index uint64) uuid.UUID {
+	if index >= uint64(len(info.Ids)) {
+		return uuid.Nil
+	}
+	return info.Ids[index]
+}
+
+// checkCommitWrite returns the file to overwrite, see checkPrepared
+func (st *namenodeState) checkCommitWrite(c *writeCommand) (*fileInfo, error) {
+	info, err := st.checkWrite(c.Path, c.Offset, c.Size, c.FileSize)
+	if err != nil {
+		return nil, err
+	}
+	index, blocks := info.writeRange(c.Offset, c.Size)
+	if len(c.Ids) != blocks || len(c.Locs) != blocks {
+		return nil, errors.New(fmt.Sprintf("write to %v needs %v blocks, got %v", c.Path, blocks, len(c.Ids)))
+	}
+	err = st.checkPrepared(c.Path, info, index, c.Ids, c.Locs)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// checkPrepared checks that every block has been prepared for the file, once,
+// in place of the block from index on the file still has, and is written only
+// where it was placed
+func (st *namenodeState) checkPrepared(path string, info *fileInfo, index uint64, ids []uuid.UUID, locs [][]int) error {
+	seen := make(map[uuid.UUID]bool)
+	for i, id := range ids {
+		if seen[id] {
+			return errors.New(fmt.Sprintf("uuid %v is written twice to %v", id, path))
+		}
+		seen[id] = true
+		if _, ok := st.UUIDToLocs[id]; ok {
+			return errors.New(fmt.Sprintf("uuid %v is already in use", id))
+		}
+		prepared, ok := st.Prepared[id]
+		if !ok || prepared.Inode != info.Inode {
+			return errors.New(fmt.Sprintf("uuid %v is not prepared for %v", id, path))
+		}
+		if prepared.Replaces != info.blockAt(index+uint64(i)) {
+			return errors.New(fmt.Sprintf("block #%v of %v has been replaced since uuid %v was prepared", index+uint64(i), path, id))
+		}
+		for _, loc := range locs[i] {
+			if !containsLoc(prepared.Locs, loc) {
+				return errors.New(fmt.Sprintf("uuid %v is not placed at loc %v", id, loc))
+			}
+		}
+	}
+	return nil
+}
+
+// applyWrite swaps the written blocks in, the replaced blocks are released
+// rather than overwritten since readers, other files and snapshots may still
+// refer to them
+func (st *namenodeState) applyWrite(c *writeCommand) error {
+	info, err := st.checkCommitWrite(c)
+	if err != nil {
+		return err
+	}
+	index, blocks := info.writeRange(c.Offset, c.Size)
+
+	ids := append(info.Ids[:index:index], c.Ids...)
+	end := index + uint64(blocks)
+	if end < uint64(len(info.Ids)) {
+		ids = append(ids, info.Ids[end:]...)
+	} else {
+		end = uint64(len(info.Ids))
+	}
+	for _, id := range info.Ids[index:end] {
+		st.releaseBlock(info, id)
+	}
+	info.Ids = ids
+
+	if c.Offset+c.Size > info.Size {
+		st.addBytes(st.Inodes[info.Parent], spaceOf(info, c.Offset+c.Size)-spaceConsumed(info))
+		info.Size = c.Offset + c.Size
+	}
+	info.Mtime = c.Time
+	for i, id := range c.Ids {
+		st.unprepare(id, c.Locs[i])
+	}
+	st.addBlocks(info, c.Ids, c.Locs, true)
+	return nil
+}
+
+func (st *namenodeState) applyAbortWrite(c *abortWriteCommand) {
+	var inode uint64
+	if c.Path != "" {
+		info, _, err := st.resolve(c.Path, resolveOptions{follow: true})
+		if err != nil {
+			// left to expire
+			return
+		}
+		inode = info.Inode
+	}
+	for _, id := range c.Ids {
+		prepared, ok := st.Prepared[id]
+		if ok && (c.Path == "" || prepared.Inode == inode) {
+			st.unprepare(id, nil)
+		}
+	}
+}
+
+// unprepare forgets the prepared block, the replicas which may have been
+// written anywhere but at the kept locs are handed over to reclaim
+func (st *namenodeState) unprepare(id uuid.UUID, kept []int) {
+	prepared, ok := st.Prepared[id]
+	if !ok {
+		return
+	}
+	delete(st.Prepared, id)
+	for _, loc := range prepared.Locs {
+		if containsLoc(kept, loc) {
+			continue
+		}
+		if st.Reclaims[id] == nil {
+			st.Reclaims[id] = make(map[int]bool)
+		}
+		st.Reclaims[id][loc] = false
+	}
+}
+
+func containsLoc(locs []int, loc int) bool {
+	for _, l := range locs {
+		if l == loc {
+			return true
+		}
+	}
+	return false
+}
+
+func containsId(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// expiredWrites returns the prepared blocks not committed within a lease
+// duration, the writer is taken to have given up
+func (s *namenodeServer) expiredWrites(now time.Time) []uuid.UUID {
+	var ids []uuid.UUID
+	for id, prepared := range s.state.Prepared {
+		if now.Sub(time.Unix(0, prepared.Time)) > consts.LeaseDuration {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// abortWrite reclaims the prepared blocks of a write to the file which
+// cannot commit, or of any file if path is empty
+func (s *namenodeServer) abortWrite(path string, ids []uuid.UUID) {
+	if len(ids) == 0 {
+		return
+	}
+	err := s.syncPropose(newAbortWriteCommand(&abortWriteCommand{Path: path, Ids: ids}))
+	if err != nil {
+		log.Warnf("namenode server %v cannot abort writing %v blocks, %v", s.addr, len(ids), err)
+	}
+}
+
+func (st *namenodeState) checkTruncate(path string, size uint64) (*fileInfo, error) {
+	if utils.IsDir(path) {
+		return nil, errors.New(fmt.Sprintf("cannot truncate dir %v", path))
+	}
+	info, _, err := st.resolve(path, resolveOptions{follow: true})
+	if err != nil {
+		return nil, err
+	}
+	if info.UnderConstruction {
+		return nil, underConstruction(path)
+	}
+	if size > info.Size {
+		return nil, errors.New(fmt.Sprintf("cannot truncate file %v with %v bytes to %v", path, info.Size, size))
+	}
+	return info, nil
+}
+
+// shortensTail tells whether truncating the file to size leaves its new last
+// block shorter than it is
+func (info *fileInfo) shortensTail(size uint64) bool {
+	return size < info.Size && size%info.blockSize() != 0
+}
+
+// applyTruncate drops the blocks past the new end, a shortened last block is
+// replaced by the copy of the bytes kept, written to the prepared Ids, like
+// applyWrite does
+func (st *namenodeState) applyTruncate(c *truncateCommand) error {
+	info, err := st.checkTruncate(c.Path, c.Size)
+	if err != nil {
+		return err
+	}
+
+	keep := utils.CeilDiv(c.Size, info.blockSize())
+	if len(c.Ids) != 0 {
+		if len(c.Ids) != 1 || len(c.Locs) != 1 || !info.shortensTail(c.Size) {
+			return errors.New(fmt.Sprintf("truncate of %v to %v bytes cannot replace its last block with %v blocks", c.Path, c.Size, len(c.Ids)))
+		}
+		keep--
+		err = st.checkPrepared(c.Path, info, uint64(keep), c.Ids, c.Locs)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range info.Ids[keep:] {
+		st.releaseBlock(info, id)
+	}
+	info.Ids = append(info.Ids[:keep:keep], c.Ids...)
+	st.addBytes(st.Inodes[info.Parent], spaceOf(info, c.Size)-spaceConsumed(info))
+	info.Size = c.Size
+	info.Mtime = c.Time
+	for i, id := range c.Ids {
+		st.unprepare(id, c.Locs[i])
+	}
+	st.addBlocks(info, c.Ids, c.Locs, true)
+	return nil
+}
+
+// tailCopy copies the bytes a truncate keeps of the last block to a new block
+type tailCopy struct {
+	from   []byte    // uuid of the old block
+	id     uuid.UUID // the new block
+	length uint64    // bytes of the old block
+	size   uint64    // bytes kept
+	ec     *ecPolicy
+	froms  []string       // addrs of the written replicas of the old block
+	cells  map[int]string // cell -> addr of the old block, erasure coded only
+	locs   []int          // of the new block, by cell if erasure coded
+	addrs  []string
+}
+
+// planTailCopy prepares the new last block of a truncate shortening it, the
+// copy is made outside the lock and reclaimed if not committed in time
+func (s *namenodeServer) planTailCopy(path string, info *fileInfo, size uint64) (*tailCopy, error) {
+	index := size / info.blockSize()
+	old := info.Ids[index]
+	block, ok := s.state.UUIDToLocs[old]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("uuid %v not exists", old))
+	}
+	from, err := old.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	t := &tailCopy{from: from, id: id, length: info.blockLength(index), size: size % info.blockSize(), ec: block.EC}
+	if block.EC != nil {
+		t.cells = make(map[int]string)
+	}
+	for loc, valid := range block.Locs {
+		locInfo, registered := s.state.LocToInfo[loc]
+		if !valid || !registered {
+			continue
+		}
+		if block.EC != nil {
+			t.cells[block.Cells[loc]] = locInfo.Addr
+		} else {
+			t.froms = append(t.froms, locInfo.Addr)
+		}
+	}
+
+	t.locs, err = s.fetchLocs(id, info.width())
+	if err != nil {
+		return nil, err
+	}
+	for _, loc := range t.locs {
+		t.addrs = append(t.addrs, s.state.LocToInfo[loc].Addr)
+	}
+	log.Infof("namenode server %v copy last block %v of %v to uuid %v at locs %v", s.addr, old, path, id, t.locs)
+
+	err = s.syncPropose(newPrepareWriteCommand(&prepareWriteCommand{
+		Path:     path,
+		Time:     time.Now().UnixNano(),
+		Offset:   index * info.blockSize(),
+		Size:     t.size,
+		FileSize: info.Size,
+		Ids:      []uuid.UUID{id},
+		Locs:     [][]int{t.locs},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// run writes the bytes kept of the old block to every loc of the new one
+func (t *tailCopy) run() error {
+	payloads, err := t.read()
+	if err != nil {
+		return err
+	}
+	to, err := t.id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for i, addr := range t.addrs {
+		payload := payloads[0]
+		if t.ec != nil {
+			payload = payloads[i]
+		}
+		datanode, conn, err := utils.ConnectToTargetDataNode(addr, consts.ServiceToken)
+		if err != nil {
+			return err
+		}
+		_, err = datanode.Write(context.Background(), &protos.WriteRequest{Uuid: to, Data: payload})
+		conn.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read returns the bytes kept of the old block, encoded into cells if erasure coded
+func (t *tailCopy) read() ([][]byte, error) {
+	if t.ec == nil {
+		for _, addr := range t.froms {
+			datanode, conn, err := utils.ConnectToTargetDataNode(addr, consts.ServiceToken)
+			if err != nil {
+				log.Warn(err)
+				continue
+			}
+			reply, err := datanode.Read(context.Background(), &protos.ReadRequest{Uuid: t.from})
+			conn.Close()
+			if err != nil || uint64(len(reply.Data)) < t.size {
+				log.Warnf("unable to read %v bytes of the replica at %v, %v", t.size, addr, err)
+				continue
+			}
+			return [][]byte{reply.Data[:t.size]}, nil
+		}
+		return nil, errors.New("data corrupted")
+	}
+
+	coder, err := erasure.NewCoder(t.ec.Data, t.ec.Parity)
+	if err != nil {
+		return nil, err
+	}
+	cells, err := readCells(t.from, t.ec, t.cells)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%v, data corrupted", err))
+	}
+	return coder.Encode(coder.Join(cells, t.length)[:t.size]), nil
+}
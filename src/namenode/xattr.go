@@ -0,0 +1,67 @@
+package namenode
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// limits of the xattrs of an inode, they live in the replicated namespace
+const (
+	xattrMaxNameLen  = 255
+	xattrMaxValueLen = 16384
+	xattrMaxPerInode = 32
+)
+
+func (st *namenodeState) checkSetXAttr(path, name string, value []byte) (*fileInfo, error) {
+	info, err := st.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" || len(name) > xattrMaxNameLen {
+		return nil, errors.New(fmt.Sprintf("xattr name should have 1 to %v bytes", xattrMaxNameLen))
+	}
+	if len(value) > xattrMaxValueLen {
+		return nil, errors.New(fmt.Sprintf("xattr %v has %v bytes, more than %v", name, len(value), xattrMaxValueLen))
+	}
+	_, ok := info.XAttrs[name]
+	if !ok && len(info.XAttrs) >= xattrMaxPerInode {
+		return nil, errors.New(fmt.Sprintf("path %v already has %v xattrs", path, xattrMaxPerInode))
+	}
+	return info, nil
+}
+
+func (st *namenodeState) applySetXAttr(c *setXAttrCommand) error {
+	info, err := st.checkSetXAttr(c.Path, c.Name, c.Value)
+	if err != nil {
+		return err
+	}
+	if info.XAttrs == nil {
+		info.XAttrs = make(map[string][]byte)
+	}
+	info.XAttrs[c.Name] = c.Value
+	return nil
+}
+
+func (st *namenodeState) applyRemoveXAttr(c *removeXAttrCommand) error {
+	info, err := st.lookup(c.Path)
+	if err != nil {
+		return err
+	}
+	_, ok := info.XAttrs[c.Name]
+	if !ok {
+		return errors.New(fmt.Sprintf("xattr %v of %v not exists", c.Name, c.Path))
+	}
+	delete(info.XAttrs, c.Name)
+	return nil
+}
+
+// xattrNames returns the names of the xattrs of the inode in sorted order
+func xattrNames(info *fileInfo) []string {
+	names := make([]string, 0, len(info.XAttrs))
+	for name := range info.XAttrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
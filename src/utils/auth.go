@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"os"
+	"simple-distributed-storage-system/src/consts"
+	"strings"
+	"time"
+)
+
+const (
+	authMetadataKey = "authorization"
+	bearerPrefix    = "Bearer "
+
+	// tokenEnv holds the token of the client if not given explicitly
+	tokenEnv = "SDSS_TOKEN"
+)
+
+// Identity is who an authenticated caller is
+type Identity struct {
+	User   string
+	Groups []string
+}
+
+type identityKey struct{}
+
+// Authenticator tells who the bearer of a token is
+type Authenticator interface {
+	Authenticate(token string) (*Identity, error)
+}
+
+// staticTokenAuthenticator accepts the tokens listed in a token file, whose
+// lines are token,user[,group...], blank lines and lines starting with # are skipped
+type staticTokenAuthenticator struct {
+	tokens map[string]*Identity
+}
+
+func NewStaticTokenAuthenticator(path string) (Authenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &staticTokenAuthenticator{tokens: make(map[string]*Identity)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			return nil, errors.New(fmt.Sprintf("token file %v line %v is invalid", path, line))
+		}
+		a.tokens[fields[0]] = &Identity{
+			User:   fields[1],
+			Groups: fields[2:],
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *staticTokenAuthenticator) Authenticate(token string) (*Identity, error) {
+	identity, ok := a.tokens[token]
+	if !ok {
+		return nil, errors.New("unknown token")
+	}
+	return identity, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Groups    []string `json:"groups,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+}
+
+// jwtAuthenticator accepts JWTs signed with HS256 by the shared secret, the
+// subject is the user and the groups claim lists the groups
+type jwtAuthenticator struct {
+	secret []byte
+}
+
+func NewJWTAuthenticator(secret []byte) Authenticator {
+	return &jwtAuthenticator{secret: secret}
+}
+
+func (a *jwtAuthenticator) Authenticate(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+
+	var header jwtHeader
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+	// never let the token choose how it is verified
+	if header.Alg != "HS256" {
+		return nil, errors.New(fmt.Sprintf("unsupported jwt alg %v", header.Alg))
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed jwt signature")
+	}
+	if !hmac.Equal(signature, signJWT(a.secret, parts[0]+"."+parts[1])) {
+		return nil, errors.New("invalid jwt signature")
+	}
+
+	var claims jwtClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+		return nil, errors.New("jwt expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return nil, errors.New("jwt not valid yet")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("jwt has no subject")
+	}
+	return &Identity{
+		User:   claims.Subject,
+		Groups: claims.Groups,
+	}, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed jwt")
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return errors.New("malformed jwt")
+	}
+	return nil
+}
+
+func signJWT(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// IssueJWT signs a token for the identity valid for ttl, or forever if ttl is 0
+func IssueJWT(secret []byte, identity Identity, ttl time.Duration) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims := jwtClaims{
+		Subject: identity.User,
+		Groups:  identity.Groups,
+	}
+	if ttl != 0 {
+		claims.ExpiresAt = time.Now().Add(ttl).Unix()
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signJWT(secret, signingInput)), nil
+}
+
+// chainAuthenticator accepts a token if any of the authenticators does
+type chainAuthenticator []Authenticator
+
+func (c chainAuthenticator) Authenticate(token string) (*Identity, error) {
+	for _, a := range c {
+		identity, err := a.Authenticate(token)
+		if err == nil {
+			return identity, nil
+		}
+	}
+	return nil, errors.New("invalid token")
+}
+
+// NewAuthenticator builds the authenticator configured in consts, it returns
+// nil if authentication is disabled
+func NewAuthenticator() (Authenticator, error) {
+	var chain chainAuthenticator
+	if consts.AuthTokenFile != "" {
+		a, err := NewStaticTokenAuthenticator(consts.AuthTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, a)
+	}
+	if consts.AuthJWTSecretFile != "" {
+		secret, err := os.ReadFile(consts.AuthJWTSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, NewJWTAuthenticator([]byte(strings.TrimSpace(string(secret)))))
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// AuthInterceptor rejects the callers without a valid bearer token and keeps
+// the identity of the others in the context, every call is allowed if a is nil
+func AuthInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if a == nil {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(authMetadataKey)
+		if len(values) == 0 || !strings.HasPrefix(values[0], bearerPrefix) {
+			log.Warnf("audit: unauthenticated call %v rejected", info.FullMethod)
+			return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+		}
+		identity, err := a.Authenticate(strings.TrimPrefix(values[0], bearerPrefix))
+		if err != nil {
+			log.Warnf("audit: call %v rejected, %v", info.FullMethod, err)
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		log.Infof("audit: user %v calls %v", identity.User, info.FullMethod)
+		return handler(context.WithValue(ctx, identityKey{}, identity), req)
+	}
+}
+
+// CurrentToken returns the token of the client from the environment
+func CurrentToken() string {
+	return os.Getenv(tokenEnv)
+}
+
+// bearerCredentials attaches the token to every call
+type bearerCredentials string
+
+func (b bearerCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{authMetadataKey: bearerPrefix + string(b)}, nil
+}
+
+// RequireTransportSecurity is false since the cluster talks plaintext, the
+// token is as exposed as the data
+func (b bearerCredentials) RequireTransportSecurity() bool {
+	return false
+}
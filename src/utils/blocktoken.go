@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operations on a block a block token grants
+const (
+	BlockRead  = "read"
+	BlockWrite = "write"
+)
+
+// IssueBlockToken lets the bearer do op on the block for ttl, the token is
+// expiry.signature where the signature is an HMAC over the uuid, op and expiry
+func IssueBlockToken(secret []byte, id []byte, op string, ttl time.Duration) string {
+	expiry := time.Now().Add(ttl).Unix()
+	return fmt.Sprintf("%v.%v", expiry, base64.RawURLEncoding.EncodeToString(signBlockToken(secret, id, op, expiry)))
+}
+
+// VerifyBlockToken checks that the token lets its bearer do op on the block now
+func VerifyBlockToken(secret []byte, token string, id []byte, op string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return errors.New("malformed block token")
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errors.New("malformed block token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errors.New("malformed block token signature")
+	}
+	if !hmac.Equal(signature, signBlockToken(secret, id, op, expiry)) {
+		return errors.New(fmt.Sprintf("invalid block token to %v", op))
+	}
+	if time.Now().Unix() >= expiry {
+		return errors.New("block token expired")
+	}
+	return nil
+}
+
+// signBlockToken signs the fixed length uuid followed by op and expiry
+func signBlockToken(secret, id []byte, op string, expiry int64) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(id)
+	mac.Write([]byte(fmt.Sprintf("%v:%v", op, expiry)))
+	return mac.Sum(nil)
+}
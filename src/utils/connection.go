@@ -17,6 +17,13 @@ import (
 
 var opts uint64 = 0
 
+// MaxBlockSize bounds the block size of files, a block goes to and from a
+// datanode in a single message
+const MaxBlockSize uint64 = 64 << 20
+
+// MaxMessageSize leaves room for the rest of the message carrying a block
+const MaxMessageSize = int(MaxBlockSize) + 1<<20
+
 type ConnHandler struct {
 	conn     *grpc.ClientConn
 	reporter reporter.Reporter
@@ -27,7 +34,16 @@ func (h *ConnHandler) Close() {
 	h.reporter.Close()
 }
 
-func ConnectToTargetDataNode(addr string) (protos.DataNodeClient, *ConnHandler, error) {
+// dialOptions carries the token of the caller on every call if set
+func dialOptions(token string, opts ...grpc.DialOption) []grpc.DialOption {
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerCredentials(token)))
+	}
+	return opts
+}
+
+func ConnectToTargetDataNode(addr, token string) (protos.DataNodeClient, *ConnHandler, error) {
 	// zipkin
 	no := atomic.LoadUint64(&opts)
 	tracer, r, err := NewZipkinTracer(ZIPKIN_HTTP_ENDPOINT, fmt.Sprintf("DataNode-Client-%s-%v", addr, no), addr)
@@ -36,7 +52,8 @@ func ConnectToTargetDataNode(addr string) (protos.DataNodeClient, *ConnHandler,
 		r.Close()
 		return nil, nil, err
 	}
-	conn, err := grpc.Dial(addr, grpc.WithStatsHandler(zipkingrpc.NewClientHandler(tracer)), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(addr, dialOptions(token, grpc.WithStatsHandler(zipkingrpc.NewClientHandler(tracer)),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(MaxMessageSize), grpc.MaxCallSendMsgSize(MaxMessageSize)))...)
 	if err != nil {
 		r.Close()
 		return nil, nil, err
@@ -47,7 +64,7 @@ func ConnectToTargetDataNode(addr string) (protos.DataNodeClient, *ConnHandler,
 	}, nil
 }
 
-func ConnectToTargetNameNode(addr string, readonly bool) (protos.NameNodeClient, *ConnHandler, error) {
+func ConnectToTargetNameNode(addr string, readonly bool, token string) (protos.NameNodeClient, *ConnHandler, error) {
 	// zipkin
 	no := atomic.LoadUint64(&opts)
 	tracer, r, err := NewZipkinTracer(ZIPKIN_HTTP_ENDPOINT, fmt.Sprintf("NameNode-Client-%s-%v", addr, no), addr)
@@ -56,7 +73,8 @@ func ConnectToTargetNameNode(addr string, readonly bool) (protos.NameNodeClient,
 		r.Close()
 		return nil, nil, err
 	}
-	conn, err := grpc.Dial(addr, grpc.WithStatsHandler(zipkingrpc.NewClientHandler(tracer)), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(addr, dialOptions(token, grpc.WithStatsHandler(zipkingrpc.NewClientHandler(tracer)),
+		grpc.WithUnaryInterceptor(userInterceptor))...)
 	if err != nil {
 		r.Close()
 		return nil, nil, err
@@ -80,7 +98,7 @@ func ConnectToTargetNameNode(addr string, readonly bool) (protos.NameNodeClient,
 	}, nil
 }
 
-func ConnectToNameNode(readonly bool) (protos.NameNodeClient, *ConnHandler, error) {
+func ConnectToNameNode(readonly bool, token string) (protos.NameNodeClient, *ConnHandler, error) {
 	rounds := 0
 	for {
 		rounds++
@@ -89,7 +107,7 @@ func ConnectToNameNode(readonly bool) (protos.NameNodeClient, *ConnHandler, erro
 		}
 
 		for _, addr := range consts.NameNodeServerAddrs {
-			namenode, conn, err := ConnectToTargetNameNode(addr, readonly)
+			namenode, conn, err := ConnectToTargetNameNode(addr, readonly, token)
 			if err != nil {
 				log.Warn(err)
 				time.Sleep(500 * time.Millisecond)
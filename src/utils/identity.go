@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"os"
+	"os/user"
+	"strings"
+)
+
+const (
+	userMetadataKey   = "sdss-user"
+	groupsMetadataKey = "sdss-groups"
+
+	// AnonymousUser is the identity of callers who do not tell who they are
+	AnonymousUser = "anonymous"
+
+	// the identity can be overridden by the environment, like HADOOP_USER_NAME
+	userEnv   = "SDSS_USER"
+	groupsEnv = "SDSS_GROUPS" // comma separated
+)
+
+var processUser, processGroups = lookupProcessUser()
+
+func lookupProcessUser() (string, []string) {
+	u, err := user.Current()
+	if err != nil || u.Username == "" {
+		return AnonymousUser, nil
+	}
+
+	var groups []string
+	ids, err := u.GroupIds()
+	if err == nil {
+		for _, id := range ids {
+			g, err := user.LookupGroupId(id)
+			if err == nil {
+				groups = append(groups, g.Name)
+			}
+		}
+	}
+	return u.Username, groups
+}
+
+// CurrentUser returns the user the process acts as
+func CurrentUser() string {
+	name := os.Getenv(userEnv)
+	if name != "" {
+		return name
+	}
+	return processUser
+}
+
+// CurrentGroups returns the groups of the user the process acts as
+func CurrentGroups() []string {
+	groups := os.Getenv(groupsEnv)
+	if groups != "" {
+		return strings.Split(groups, ",")
+	}
+	if os.Getenv(userEnv) != "" {
+		// groups of the local user do not belong to the overriding one
+		return nil
+	}
+	return processGroups
+}
+
+// UserFromContext returns the authenticated user, or the user the caller
+// claims to be in the rpc metadata if authentication is disabled
+func UserFromContext(ctx context.Context) string {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	if ok {
+		return identity.User
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return AnonymousUser
+	}
+	values := md.Get(userMetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return AnonymousUser
+	}
+	return values[0]
+}
+
+// GroupsFromContext returns the groups of the caller like UserFromContext
+func GroupsFromContext(ctx context.Context) []string {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	if ok {
+		return identity.Groups
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	return md.Get(groupsMetadataKey)
+}
+
+// userInterceptor tells the server which user is calling
+func userInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, userMetadataKey, CurrentUser())
+	for _, group := range CurrentGroups() {
+		ctx = metadata.AppendToOutgoingContext(ctx, groupsMetadataKey, group)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
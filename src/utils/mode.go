@@ -0,0 +1,28 @@
+package utils
+
+const (
+	// ModeSticky only lets the owners remove or rename the entries of a dir
+	ModeSticky uint32 = 01000
+	ModePerm   uint32 = 0777
+)
+
+// FormatMode renders the permission bits like ls -l does, e.g. drwxr-xr-x
+func FormatMode(isDir bool, mode uint32) string {
+	buf := []byte("-rwxrwxrwx")
+	if isDir {
+		buf[0] = 'd'
+	}
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) == 0 {
+			buf[i+1] = '-'
+		}
+	}
+	if mode&ModeSticky != 0 {
+		if mode&01 != 0 {
+			buf[9] = 't'
+		} else {
+			buf[9] = 'T'
+		}
+	}
+	return string(buf)
+}
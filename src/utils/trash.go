@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"strings"
+)
+
+// TrashRoot holds the deleted paths of every user, as /.Trash/<user>/<checkpoint>/<original path>
+const TrashRoot = "/.Trash/"
+
+// TrashDir returns the trash dir of the user
+func TrashDir(user string) string {
+	return TrashRoot + user + "/"
+}
+
+// InTrash tells whether the path is the trash root or inside it
+func InTrash(path string) bool {
+	return strings.HasPrefix(path, TrashRoot)
+}
+
+// TrashOrigin returns the path a trash entry was deleted from
+func TrashOrigin(path string) (string, bool) {
+	if !InTrash(path) {
+		return "", false
+	}
+	// skip user and checkpoint
+	parts := strings.SplitN(strings.TrimPrefix(path, TrashRoot), "/", 3)
+	if len(parts) < 3 || parts[2] == "" {
+		return "", false
+	}
+	return "/" + parts[2], true
+}
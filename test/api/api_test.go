@@ -3,15 +3,21 @@ package test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"simple-distributed-storage-system/src/client"
 	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/datanode"
 	"simple-distributed-storage-system/src/namenode"
+	"simple-distributed-storage-system/src/protos"
+	"simple-distributed-storage-system/src/utils"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -20,6 +26,44 @@ func TestAPI(t *testing.T) {
 	RunSpecs(t, "API TESTS")
 }
 
+// countBlocks counts the blocks stored by the given datanode servers
+func countBlocks(addrs ...string) int {
+	count := 0
+	for _, addr := range addrs {
+		entries, err := os.ReadDir("/tmp/gfs/chunks/" + addr + "/")
+		Expect(err).To(BeNil())
+		count += len(entries)
+	}
+	return count
+}
+
+// writeBlock writes the block at index of a file under construction to all
+// its replicas, like a client does before completing the file
+func writeBlock(nameNode protos.NameNodeClient, path string, index uint64, data []byte) {
+	reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+		Path:  path,
+		Index: index,
+		Type:  protos.FetchBlockAddrsRequestType_OP_PUT,
+	})
+	Expect(err).To(BeNil())
+
+	validity := make(map[string]bool)
+	for _, addr := range reply.Addrs {
+		dataNode, conn, err := utils.ConnectToTargetDataNode(addr, "")
+		Expect(err).To(BeNil())
+		_, err = dataNode.Write(context.Background(), &protos.WriteRequest{Uuid: reply.Uuid, Data: data})
+		Expect(err).To(BeNil())
+		conn.Close()
+		validity[addr] = true
+	}
+
+	_, err = nameNode.LocsValidityNotify(context.Background(), &protos.LocsValidityNotifyRequest{
+		Uuid:     reply.Uuid,
+		Validity: validity,
+	})
+	Expect(err).To(BeNil())
+}
+
 var _ = Describe("API TESTS", func() {
 	BeforeEach(func() {
 		err := os.RemoveAll(consts.RaftPersistenceDataDir)
@@ -39,6 +83,8 @@ var _ = Describe("API TESTS", func() {
 	remoteDir := "/doc/"
 	remoteNewPath := "/LICENSE_NEW"
 	remotePathWithDir := "/doc/LICENSE"
+	remoteSubDir := "/doc/sub/"
+	remotePathWithSubDir := "/doc/sub/LICENSE"
 
 	It("Put", func() {
 		ctx, cancelFunc := context.WithCancel(context.Background())
@@ -123,7 +169,7 @@ var _ = Describe("API TESTS", func() {
 		Expect(err).ToNot(BeNil())
 	})
 
-	It("Stat", func() {
+	It("Remove dir", func() {
 		ctx, cancelFunc := context.WithCancel(context.Background())
 		defer cancelFunc()
 
@@ -141,22 +187,145 @@ var _ = Describe("API TESTS", func() {
 		c := client.NewClient(false)
 		defer c.CloseClient()
 
-		err := c.Put(localPath, remotePath)
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		err := c.Mkdir(remoteDir)
 		Expect(err).To(BeNil())
 
-		fileInfo, err := c.Stat(remotePath)
+		err = c.Put(localPath, remotePathWithDir)
 		Expect(err).To(BeNil())
 
-		// calculate the size of local file
+		// should be error, dir not empty
+		err = c.Remove(remoteDir)
+		Expect(err).ToNot(BeNil())
+
+		err = c.RemoveAll(remoteDir)
+		Expect(err).To(BeNil())
+
+		_, err = c.Stat(remotePathWithDir)
+		Expect(err).ToNot(BeNil())
+
+		// moved to trash
+		files, err := c.List("/")
+		Expect(err).To(BeNil())
+		Expect(len(files)).To(Equal(1))
+		Expect(files[0].Name).To(Equal(utils.TrashRoot))
+
+		err = c.Expunge()
+		Expect(err).To(BeNil())
+
+		// wait for blocks to be reclaimed
+		time.Sleep(5 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks))
+	})
+
+	It("Reclaim unconfirmed replicas", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		// the writer goes away before confirming the replicas it wrote
+		_, err = nameNode.Create(context.Background(), &protos.CreateRequest{Path: remotePath, Streaming: true, ClientName: "writer"})
+		Expect(err).To(BeNil())
+		_, err = nameNode.AddBlock(context.Background(), &protos.AddBlockRequest{Path: remotePath, ClientName: "writer"})
+		Expect(err).To(BeNil())
+		reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path:  remotePath,
+			Index: 0,
+			Type:  protos.FetchBlockAddrsRequestType_OP_PUT,
+		})
+		Expect(err).To(BeNil())
+		for _, addr := range reply.Addrs {
+			dataNode, conn, err := utils.ConnectToTargetDataNode(addr, "")
+			Expect(err).To(BeNil())
+			_, err = dataNode.Write(context.Background(), &protos.WriteRequest{Uuid: reply.Uuid, Data: []byte("unconfirmed")})
+			Expect(err).To(BeNil())
+			conn.Close()
+		}
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 3))
+
+		err = c.Delete(remotePath, false, true)
+		Expect(err).To(BeNil())
+
+		// wait for blocks to be reclaimed
+		time.Sleep(5 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks))
+	})
+
+	It("Restore", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
 		data, err := os.ReadFile(localPath)
 		Expect(err).To(BeNil())
 
-		// compare file name and size
-		Expect(fileInfo.Name).To(Equal(remotePath))
-		Expect(fileInfo.Size).To(Equal(uint64(len(data))))
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		err = c.Remove(remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		// find the file in trash checkpoint
+		checkpoints, err := c.List(utils.TrashDir(utils.CurrentUser()))
+		Expect(err).To(BeNil())
+		Expect(len(checkpoints)).To(Equal(1))
+		trashPath := checkpoints[0].Name + remotePathWithDir[1:]
+
+		err = c.Restore(trashPath)
+		Expect(err).To(BeNil())
+
+		err = c.Get(remotePathWithDir, localCopyPath)
+		Expect(err).To(BeNil())
+
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
 	})
 
-	It("Mkdir", func() {
+	It("Trash ownership", func() {
+		// act as the superuser unless SDSS_USER is set
+		superUser := consts.SuperUser
+		consts.SuperUser = utils.CurrentUser()
+		defer func() {
+			consts.SuperUser = superUser
+			os.Unsetenv("SDSS_USER")
+		}()
+
 		ctx, cancelFunc := context.WithCancel(context.Background())
 		defer cancelFunc()
 
@@ -174,19 +343,48 @@ var _ = Describe("API TESTS", func() {
 		c := client.NewClient(false)
 		defer c.CloseClient()
 
-		err := c.Mkdir(remoteDir)
+		// nobody makes entries in the trash but deletes, not even the superuser
+		err := c.Put(localPath, remotePath)
 		Expect(err).To(BeNil())
+		err = c.Mkdir(utils.TrashRoot)
+		Expect(err).ToNot(BeNil())
+		err = c.Put(localPath, utils.TrashRoot+"LICENSE")
+		Expect(err).ToNot(BeNil())
+		err = c.Rename(remotePath, utils.TrashRoot+"LICENSE")
+		Expect(err).ToNot(BeNil())
 
-		files, err := c.List(remoteDir)
+		// others cannot make the trash dir of alice ahead of her
+		os.Setenv("SDSS_USER", "bob")
+		err = c.Mkdir(utils.TrashDir("alice"))
+		Expect(err).ToNot(BeNil())
+
+		os.Setenv("SDSS_USER", "alice")
+		err = c.Put(localPath, "/alice")
+		Expect(err).To(BeNil())
+		err = c.Remove("/alice")
 		Expect(err).To(BeNil())
 
-		// the length of new directory is 1，and size is 0
+		files, err := c.List(utils.TrashRoot)
+		Expect(err).To(BeNil())
 		Expect(len(files)).To(Equal(1))
-		Expect(files[0].Size).To(Equal(uint64(0)))
-		Expect(files[0].Name).To(Equal(remoteDir))
+		Expect(files[0].Owner).To(Equal("alice"))
+		Expect(files[0].Mode).To(Equal(uint32(0700)))
+
+		// deletes fail once the trash dir is opened up
+		os.Unsetenv("SDSS_USER")
+		err = c.Chmod(utils.TrashDir("alice"), 0755)
+		Expect(err).To(BeNil())
+
+		os.Setenv("SDSS_USER", "alice")
+		err = c.Put(localPath, "/alice")
+		Expect(err).To(BeNil())
+		err = c.Remove("/alice")
+		Expect(err).ToNot(BeNil())
+		_, err = c.Stat("/alice")
+		Expect(err).To(BeNil())
 	})
 
-	It("Rename", func() {
+	It("Snapshot", func() {
 		ctx, cancelFunc := context.WithCancel(context.Background())
 		defer cancelFunc()
 
@@ -204,24 +402,151 @@ var _ = Describe("API TESTS", func() {
 		c := client.NewClient(false)
 		defer c.CloseClient()
 
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
 		data, err := os.ReadFile(localPath)
 		Expect(err).To(BeNil())
 
-		err = c.Put(localPath, remotePath)
+		err = c.Mkdir(remoteDir)
 		Expect(err).To(BeNil())
 
-		err = c.Rename(remotePath, remoteNewPath)
+		err = c.Put(localPath, remotePathWithDir)
 		Expect(err).To(BeNil())
 
-		err = c.Get(remoteNewPath, localCopyPath)
+		name, err := c.CreateSnapshot(remoteDir, "s1")
+		Expect(err).To(BeNil())
+		Expect(name).To(Equal("s1"))
+
+		// should be error, snapshot exists
+		_, err = c.CreateSnapshot(remoteDir, "s1")
+		Expect(err).ToNot(BeNil())
+
+		err = c.Delete(remotePathWithDir, false, true)
+		Expect(err).To(BeNil())
+
+		// should be error, dir has snapshots
+		err = c.RemoveAll(remoteDir)
+		Expect(err).ToNot(BeNil())
+
+		// still readable from the snapshot
+		err = c.Get(remoteDir+".snapshot/s1/LICENSE", localCopyPath)
 		Expect(err).To(BeNil())
 
 		dataCopy, err := os.ReadFile(localCopyPath)
 		Expect(err).To(BeNil())
 		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
+
+		snapshots, err := c.ListSnapshots(remoteDir)
+		Expect(err).To(BeNil())
+		Expect(len(snapshots)).To(Equal(1))
+		Expect(snapshots[0].Name).To(Equal("s1"))
+
+		entries, err := c.SnapshotDiff(remoteDir, "s1", "")
+		Expect(err).To(BeNil())
+		Expect(len(entries)).To(Equal(1))
+		Expect(entries[0].Type).To(Equal(protos.SnapshotDiffType_DIFF_DELETE))
+		Expect(entries[0].Path).To(Equal(remotePathWithDir))
+
+		err = c.DeleteSnapshot(remoteDir, "s1")
+		Expect(err).To(BeNil())
+
+		// wait for blocks to be reclaimed
+		time.Sleep(5 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks))
 	})
 
-	It("List", func() {
+	It("Snapshot during append", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9003").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		// the snapshot is taken while the partial last block is rewritten
+		tail, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path: remotePathWithDir,
+			Type: protos.FetchBlockAddrsRequestType_OP_GET,
+		})
+		Expect(err).To(BeNil())
+		Expect(tail.Addrs).To(HaveLen(3))
+		reply, err := nameNode.Append(context.Background(), &protos.AppendRequest{
+			Path:       remotePathWithDir,
+			Offset:     uint64(len(data)),
+			Size:       uint64(len(data)),
+			ClientName: "writer",
+			Tail:       tail.Uuid,
+		})
+		Expect(err).To(BeNil())
+		writeBlock(nameNode, remotePathWithDir, reply.Index, append(append([]byte(nil), data...), data...))
+		_, err = c.CreateSnapshot(remoteDir, "s1")
+		Expect(err).To(BeNil())
+		_, err = nameNode.Complete(context.Background(), &protos.CompleteRequest{Path: remotePathWithDir, Size: uint64(2 * len(data)), ClientName: "writer"})
+		Expect(err).To(BeNil())
+
+		// the old last block is only kept by the snapshot, a replica of it goes bad
+		os.Setenv("SDSS_USER", consts.ServiceUser)
+		_, err = nameNode.LocsValidityNotify(context.Background(), &protos.LocsValidityNotifyRequest{
+			Uuid:     tail.Uuid,
+			Validity: map[string]bool{tail.Addrs[0]: false},
+		})
+		os.Unsetenv("SDSS_USER")
+		Expect(err).To(BeNil())
+
+		// wait for re-replication
+		time.Sleep(5 * time.Second)
+
+		snapshotPath := remoteDir + ".snapshot/s1/LICENSE"
+		frozen, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path: snapshotPath,
+			Type: protos.FetchBlockAddrsRequestType_OP_GET,
+		})
+		Expect(err).To(BeNil())
+		Expect(frozen.Uuid).To(Equal(tail.Uuid))
+		Expect(frozen.Addrs).To(HaveLen(3))
+		Expect(frozen.Addrs).ToNot(ContainElement(tail.Addrs[0]))
+
+		info, err := c.Stat(snapshotPath)
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(len(data))))
+		Expect(info.UnderConstruction).To(BeFalse())
+		err = c.Get(snapshotPath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
+	})
+
+	It("Permission", func() {
+		// act as the superuser unless SDSS_USER is set
+		superUser := consts.SuperUser
+		consts.SuperUser = utils.CurrentUser()
+		defer func() {
+			consts.SuperUser = superUser
+			os.Unsetenv("SDSS_USER")
+		}()
+
 		ctx, cancelFunc := context.WithCancel(context.Background())
 		defer cancelFunc()
 
@@ -245,21 +570,1959 @@ var _ = Describe("API TESTS", func() {
 		err = c.Put(localPath, remotePathWithDir)
 		Expect(err).To(BeNil())
 
-		fileInfos, err := c.List(remoteDir)
+		info, err := c.Stat(remotePathWithDir)
+		Expect(err).To(BeNil())
+		Expect(info.Owner).To(Equal(consts.SuperUser))
+		Expect(info.Mode).To(Equal(uint32(0644)))
+
+		// others can read but not write
+		os.Setenv("SDSS_USER", "alice")
+		err = c.Get(remotePathWithDir, localCopyPath)
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remoteDir+"alice")
+		Expect(err).ToNot(BeNil())
+		err = c.Remove(remotePathWithDir)
+		Expect(err).ToNot(BeNil())
+		err = c.Chmod(remotePathWithDir, 0666)
+		Expect(err).ToNot(BeNil())
+
+		os.Unsetenv("SDSS_USER")
+		err = c.Chmod(remotePathWithDir, 0600)
+		Expect(err).To(BeNil())
+
+		os.Setenv("SDSS_USER", "alice")
+		err = c.Get(remotePathWithDir, localCopyPath)
+		Expect(err).ToNot(BeNil())
+
+		os.Unsetenv("SDSS_USER")
+		err = c.Chown(remotePathWithDir, "alice", "")
+		Expect(err).To(BeNil())
+
+		// the new owner can read and change the mode, but cannot give the file away
+		os.Setenv("SDSS_USER", "alice")
+		err = c.Get(remotePathWithDir, localCopyPath)
+		Expect(err).To(BeNil())
+		err = c.Chmod(remotePathWithDir, 0644)
+		Expect(err).To(BeNil())
+		err = c.Chown(remotePathWithDir, "bob", "")
+		Expect(err).ToNot(BeNil())
+
+		files, err := c.List(remoteDir)
+		Expect(err).To(BeNil())
+		Expect(len(files)).To(Equal(1))
+		Expect(files[0].Owner).To(Equal("alice"))
+		Expect(files[0].Mode).To(Equal(uint32(0644)))
+
+		// only the servers register datanodes, only writers report replicas
+		os.Unsetenv("SDSS_USER")
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path: remotePath,
+			Type: protos.FetchBlockAddrsRequestType_OP_GET,
+		})
+		Expect(err).To(BeNil())
+
+		os.Setenv("SDSS_USER", "bob")
+		_, err = nameNode.RegisterDataNode(context.Background(), &protos.RegisterDataNodeRequest{Address: "localhost:9003"})
+		Expect(err).ToNot(BeNil())
+		_, err = nameNode.LocsValidityNotify(context.Background(), &protos.LocsValidityNotifyRequest{
+			Uuid:     reply.Uuid,
+			Validity: map[string]bool{reply.Addrs[0]: false},
+		})
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Authentication", func() {
+		dir, err := os.MkdirTemp("", "sdss-auth")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		tokenFile := dir + "/tokens.csv"
+		err = os.WriteFile(tokenFile, []byte("# token,user[,group...]\nservice-token,sdss\nadmin-token,"+consts.SuperUser+"\n"), 0600)
+		Expect(err).To(BeNil())
+		secret := []byte("jwt-secret")
+		secretFile := dir + "/jwt.secret"
+		err = os.WriteFile(secretFile, secret, 0600)
+		Expect(err).To(BeNil())
+
+		consts.AuthTokenFile, consts.AuthJWTSecretFile, consts.ServiceToken = tokenFile, secretFile, "service-token"
+		defer func() {
+			consts.AuthTokenFile, consts.AuthJWTSecretFile, consts.ServiceToken = "", "", ""
+		}()
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		// should be error, no token or unknown token
+		_, _, err = utils.ConnectToTargetNameNode(consts.NameNodeServerAddrs[0], true, "")
+		Expect(err).ToNot(BeNil())
+		_, _, err = utils.ConnectToTargetNameNode(consts.NameNodeServerAddrs[0], true, "unknown-token")
+		Expect(err).ToNot(BeNil())
+		dataNode, conn, err := utils.ConnectToTargetDataNode("localhost:9000", "")
 		Expect(err).To(BeNil())
+		_, err = dataNode.Read(context.Background(), &protos.ReadRequest{})
+		Expect(err).ToNot(BeNil())
+		conn.Close()
+
+		c := client.NewClientWithToken(false, "admin-token")
+		defer c.CloseClient()
 
 		data, err := os.ReadFile(localPath)
 		Expect(err).To(BeNil())
 
-		Expect(len(fileInfos)).To(Equal(2))
-		if fileInfos[0].Size == uint64(0) && fileInfos[0].Name == remoteDir &&
-			fileInfos[1].Size == uint64(len(data)) && fileInfos[1].Name == remotePathWithDir {
-			return
-		}
-		if fileInfos[1].Size == uint64(0) && fileInfos[1].Name == remoteDir &&
-			fileInfos[0].Size == uint64(len(data)) && fileInfos[0].Name == remotePathWithDir {
-			return
-		}
-		Fail("the result of LIST is mismatching")
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		// the identity comes from the token, not from the claimed user
+		jwt, err := utils.IssueJWT(secret, utils.Identity{User: "alice"}, time.Minute)
+		Expect(err).To(BeNil())
+		alice := client.NewClientWithToken(false, jwt)
+		defer alice.CloseClient()
+
+		err = alice.Get(remotePathWithDir, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
+
+		err = alice.Remove(remotePathWithDir)
+		Expect(err).ToNot(BeNil())
+
+		// datanodes take the block tokens of namenodes for the block and op only
+		nameNode, conn, err := utils.ConnectToNameNode(false, jwt)
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path: remotePathWithDir,
+			Type: protos.FetchBlockAddrsRequestType_OP_GET,
+		})
+		Expect(err).To(BeNil())
+		Expect(reply.Token).ToNot(BeEmpty())
+		dataNode, conn, err = utils.ConnectToTargetDataNode(reply.Addrs[0], jwt)
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		block, err := dataNode.Read(context.Background(), &protos.ReadRequest{Uuid: reply.Uuid, Token: reply.Token})
+		Expect(err).To(BeNil())
+		Expect(bytes.Equal(block.Data, data)).To(BeTrue())
+		_, err = dataNode.Read(context.Background(), &protos.ReadRequest{Uuid: reply.Uuid})
+		Expect(err).ToNot(BeNil())
+		_, err = dataNode.Write(context.Background(), &protos.WriteRequest{Uuid: reply.Uuid, Data: []byte("forged"), Token: reply.Token})
+		Expect(err).ToNot(BeNil())
+		_, err = dataNode.Remove(context.Background(), &protos.RemoveRequest{Uuid: reply.Uuid})
+		Expect(err).ToNot(BeNil())
+		forgedToken := utils.IssueBlockToken([]byte("another-secret"), reply.Uuid, utils.BlockRead, time.Minute)
+		_, err = dataNode.Read(context.Background(), &protos.ReadRequest{Uuid: reply.Uuid, Token: forgedToken})
+		Expect(err).ToNot(BeNil())
+
+		// should be error, expired or signed by another secret
+		expired, err := utils.IssueJWT(secret, utils.Identity{User: "alice"}, -time.Minute)
+		Expect(err).To(BeNil())
+		_, _, err = utils.ConnectToTargetNameNode(consts.NameNodeServerAddrs[0], true, expired)
+		Expect(err).ToNot(BeNil())
+		forged, err := utils.IssueJWT([]byte("another-secret"), utils.Identity{User: consts.SuperUser}, time.Minute)
+		Expect(err).To(BeNil())
+		_, _, err = utils.ConnectToTargetNameNode(consts.NameNodeServerAddrs[0], true, forged)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Quota", func() {
+		superUser := consts.SuperUser
+		consts.SuperUser = utils.CurrentUser()
+		defer func() {
+			consts.SuperUser = superUser
+		}()
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		err := c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+
+		err = c.SetQuota(remoteDir, 1, 0)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		// should be error, file quota exceeded
+		err = c.Put(localPath, remotePathWithDir+"2")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("quota exceeded"))
+
+		count, err := c.Count(remoteDir)
+		Expect(err).To(BeNil())
+		Expect(count.Files).To(Equal(int64(1)))
+		Expect(count.FileQuota).To(Equal(int64(1)))
+		Expect(count.SpaceConsumed).To(Equal(3 * count.Size))
+
+		// the space quota counts replicas
+		err = c.SetQuota(remoteDir, 0, count.SpaceConsumed+1)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+
+		// should be error, byte quota exceeded
+		err = c.Rename(remotePath, remotePathWithDir+"2")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("quota exceeded"))
+
+		// usage goes down as files leave the dir
+		err = c.Remove(remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		count, err = c.Count(remoteDir)
+		Expect(err).To(BeNil())
+		Expect(count.Files).To(Equal(int64(0)))
+
+		err = c.Rename(remotePath, remotePathWithDir+"2")
+		Expect(err).To(BeNil())
+
+		err = c.ClearQuota(remoteDir)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		// every new file counts once, dirs do not count
+		err = c.Mkdir(remoteDir + "sub/")
+		Expect(err).To(BeNil())
+
+		err = c.SetQuota(remoteDir, 4, 0)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir+"3")
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir+"4")
+		Expect(err).To(BeNil())
+
+		// should be error, the file quota is filled
+		err = c.Put(localPath, remotePathWithDir+"5")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("quota exceeded"))
+
+		// a copy counts once as well
+		err = c.SetQuota(remoteDir, 6, 0)
+		Expect(err).To(BeNil())
+
+		err = c.Copy(remotePathWithDir+"3", remotePathWithDir+"5")
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir+"6")
+		Expect(err).To(BeNil())
+
+		// should be error, the file quota is filled
+		err = c.Copy(remotePathWithDir+"3", remotePathWithDir+"7")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("quota exceeded"))
+	})
+
+	It("Stat", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		err := c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+
+		fileInfo, err := c.Stat(remotePath)
+		Expect(err).To(BeNil())
+
+		// calculate the size of local file
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		// compare file name and size
+		Expect(fileInfo.Name).To(Equal(remotePath))
+		Expect(fileInfo.Size).To(Equal(uint64(len(data))))
+	})
+
+	It("Times", func() {
+		// record every read
+		precision := consts.AccessTimePrecision
+		consts.AccessTimePrecision = time.Nanosecond
+		defer func() {
+			consts.AccessTimePrecision = precision
+		}()
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		err := c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+
+		before := time.Now().UnixNano()
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+		after := time.Now().UnixNano()
+
+		info, err := c.Stat(remotePathWithDir)
+		Expect(err).To(BeNil())
+		Expect(info.Ctime).To(BeNumerically(">=", before))
+		Expect(info.Ctime).To(BeNumerically("<=", after))
+		// modified again when the put completes
+		Expect(info.Mtime).To(BeNumerically(">=", info.Ctime))
+		Expect(info.Mtime).To(BeNumerically("<=", after))
+
+		// the dir is modified along with its entries
+		dirs, err := c.List("/")
+		Expect(err).To(BeNil())
+		Expect(dirs[0].Mtime).To(Equal(info.Ctime))
+
+		err = c.Get(remotePathWithDir, localCopyPath)
+		Expect(err).To(BeNil())
+		// reads are recorded in batches
+		time.Sleep(10 * time.Second)
+		info, err = c.Stat(remotePathWithDir)
+		Expect(err).To(BeNil())
+		Expect(info.Atime).To(BeNumerically(">", after))
+
+		mtime := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+		err = c.SetTimes(remotePathWithDir, mtime, time.Time{})
+		Expect(err).To(BeNil())
+		files, err := c.List(remoteDir)
+		Expect(err).To(BeNil())
+		Expect(files[0].Mtime).To(Equal(mtime.UnixNano()))
+		Expect(files[0].Ctime).To(Equal(info.Ctime))
+		Expect(files[0].Atime).To(Equal(info.Atime))
+	})
+
+	It("XAttr", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		err := c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		err = c.SetXAttr(remotePathWithDir, "user.content-type", []byte("text/plain"))
+		Expect(err).To(BeNil())
+		err = c.SetXAttr(remotePathWithDir, "user.run-id", []byte("42"))
+		Expect(err).To(BeNil())
+		err = c.SetXAttr(remoteDir, "user.source", []byte("github"))
+		Expect(err).To(BeNil())
+
+		// should be error, value too large
+		err = c.SetXAttr(remotePathWithDir, "user.large", make([]byte, 16385))
+		Expect(err).ToNot(BeNil())
+
+		value, err := c.GetXAttr(remotePathWithDir, "user.content-type")
+		Expect(err).To(BeNil())
+		Expect(string(value)).To(Equal("text/plain"))
+
+		names, err := c.ListXAttrs(remotePathWithDir)
+		Expect(err).To(BeNil())
+		Expect(names).To(Equal([]string{"user.content-type", "user.run-id"}))
+
+		err = c.RemoveXAttr(remotePathWithDir, "user.run-id")
+		Expect(err).To(BeNil())
+
+		// should be error, already removed
+		_, err = c.GetXAttr(remotePathWithDir, "user.run-id")
+		Expect(err).ToNot(BeNil())
+		err = c.RemoveXAttr(remotePathWithDir, "user.run-id")
+		Expect(err).ToNot(BeNil())
+
+		// xattrs move along with the path
+		err = c.Rename(remoteDir, "/moved/")
+		Expect(err).To(BeNil())
+		value, err = c.GetXAttr("/moved/", "user.source")
+		Expect(err).To(BeNil())
+		Expect(string(value)).To(Equal("github"))
+	})
+
+	It("Symlink", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		err = c.Mkdir("/releases/")
+		Expect(err).To(BeNil())
+		err = c.Mkdir("/releases/v1/")
+		Expect(err).To(BeNil())
+		err = c.Mkdir("/releases/v2/")
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, "/releases/v1/LICENSE")
+		Expect(err).To(BeNil())
+
+		// relative target, resolved against /releases/
+		err = c.CreateSymlink("v1", "/releases/current")
+		Expect(err).To(BeNil())
+
+		// should be error, already exists
+		err = c.CreateSymlink("v2", "/releases/current")
+		Expect(err).ToNot(BeNil())
+
+		info, err := c.Stat("/releases/current")
+		Expect(err).To(BeNil())
+		Expect(info.Target).To(Equal("v1"))
+
+		err = c.Get("/releases/current/LICENSE", localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(data, dataCopy)).To(Equal(0))
+
+		infos, err := c.List("/releases/current/")
+		Expect(err).To(BeNil())
+		Expect(len(infos)).To(Equal(1))
+
+		// files can be created through the symlink
+		err = c.Put(localPath, "/releases/current/LICENSE_NEW")
+		Expect(err).To(BeNil())
+		_, err = c.Stat("/releases/v1/LICENSE_NEW")
+		Expect(err).To(BeNil())
+
+		// absolute target, a symlink to a file is followed on reads
+		err = c.CreateSymlink("/releases/v1/LICENSE", "/LICENSE")
+		Expect(err).To(BeNil())
+		err = c.Get("/LICENSE", localCopyPath)
+		Expect(err).To(BeNil())
+
+		// roll current forward atomically
+		err = c.Put(localPath, "/releases/v2/LICENSE")
+		Expect(err).To(BeNil())
+		err = c.CreateSymlink("v2", "/releases/next")
+		Expect(err).To(BeNil())
+		err = c.RenameOverwrite("/releases/next", "/releases/current")
+		Expect(err).To(BeNil())
+		info, err = c.Stat("/releases/current")
+		Expect(err).To(BeNil())
+		Expect(info.Target).To(Equal("v2"))
+		// should be error, v2 has no LICENSE_NEW
+		_, err = c.Stat("/releases/current/LICENSE_NEW")
+		Expect(err).ToNot(BeNil())
+
+		// removing the symlink leaves the target
+		err = c.Remove("/releases/current")
+		Expect(err).To(BeNil())
+		_, err = c.Stat("/releases/v2/LICENSE")
+		Expect(err).To(BeNil())
+
+		// dangling symlink, should be error on reads
+		err = c.CreateSymlink("/missing", "/dangling")
+		Expect(err).To(BeNil())
+		err = c.Get("/dangling", localCopyPath)
+		Expect(err).ToNot(BeNil())
+
+		// loop, should be error
+		err = c.CreateSymlink("/loop2", "/loop1")
+		Expect(err).To(BeNil())
+		err = c.CreateSymlink("/loop1", "/loop2")
+		Expect(err).To(BeNil())
+		err = c.Get("/loop1", localCopyPath)
+		Expect(err).ToNot(BeNil())
+		_, err = c.List("/loop1/")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Copy", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+		written := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+		err = c.Copy(remotePath, remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		// should be error, dest exists
+		err = c.Copy(remotePath, remotePathWithDir)
+		Expect(err).ToNot(BeNil())
+		// should be error, cannot copy dir
+		err = c.Copy(remoteDir, "/copy/")
+		Expect(err).ToNot(BeNil())
+
+		// no block is written for the copy
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(written))
+
+		info, err := c.Stat(remotePathWithDir)
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(len(data))))
+
+		// blocks stay alive as long as one file refers to them
+		err = c.Delete(remotePath, false, true)
+		Expect(err).To(BeNil())
+		time.Sleep(5 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(written))
+
+		err = c.Get(remotePathWithDir, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(data, dataCopy)).To(Equal(0))
+
+		// the last reference reclaims the blocks
+		err = c.Delete(remotePathWithDir, false, true)
+		Expect(err).To(BeNil())
+		time.Sleep(5 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks))
+	})
+
+	It("Concat", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 100)
+
+		// every part but the last fills its blocks
+		err = c.PutStream(bytes.NewReader(content[:40960]), remotePath)
+		Expect(err).To(BeNil())
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+		err = c.PutStream(bytes.NewReader(content[40960:2*40960]), remotePathWithDir)
+		Expect(err).To(BeNil())
+		err = c.PutStream(bytes.NewReader(content[2*40960:]), remoteNewPath)
+		Expect(err).To(BeNil())
+		written := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		// should be error, a partial block in the middle
+		err = c.Concat(remotePath, []string{remoteNewPath, remotePathWithDir})
+		Expect(err).ToNot(BeNil())
+		// should be error, the target cannot be a source
+		err = c.Concat(remotePath, []string{remotePath})
+		Expect(err).ToNot(BeNil())
+		// should be error, cannot concat dir
+		err = c.Concat(remotePath, []string{remoteDir})
+		Expect(err).ToNot(BeNil())
+
+		err = c.Concat(remotePath, []string{remotePathWithDir, remoteNewPath})
+		Expect(err).To(BeNil())
+
+		// the sources are gone and no block is written
+		_, err = c.Stat(remotePathWithDir)
+		Expect(err).ToNot(BeNil())
+		_, err = c.Stat(remoteNewPath)
+		Expect(err).ToNot(BeNil())
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(written))
+
+		info, err := c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(len(content))))
+
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+	})
+
+	It("Replication", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 100)
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		// should be error, more replicas than datanodes
+		err = c.PutStreamWithReplication(bytes.NewReader(content), remotePath, 4)
+		Expect(err).ToNot(BeNil())
+
+		err = c.PutStreamWithReplication(bytes.NewReader(content), remotePath, 1)
+		Expect(err).To(BeNil())
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 3))
+		info, err := c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.Replication).To(Equal(uint32(1)))
+
+		// should be error, a file needs a replica
+		err = c.SetReplication(remotePath, 0)
+		Expect(err).ToNot(BeNil())
+
+		// replicas are added in the background
+		err = c.SetReplication(remotePath, 3)
+		Expect(err).To(BeNil())
+		info, err = c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.Replication).To(Equal(uint32(3)))
+		time.Sleep(5 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 9))
+
+		// and removed along with the reclaimed blocks
+		err = c.SetReplication(remotePath, 2)
+		Expect(err).To(BeNil())
+		time.Sleep(8 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 6))
+
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+
+		// a copy keeps the replication of the source
+		err = c.Copy(remotePath, remoteNewPath)
+		Expect(err).To(BeNil())
+		info, err = c.Stat(remoteNewPath)
+		Expect(err).To(BeNil())
+		Expect(info.Replication).To(Equal(uint32(2)))
+	})
+
+	It("Block size", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 100)
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		// should be error, a block must fit in a message
+		err = c.PutStreamWithOptions(bytes.NewReader(content), remotePath, client.PutOptions{BlockSize: 1 << 30})
+		Expect(err).ToNot(BeNil())
+
+		err = c.PutStreamWithOptions(bytes.NewReader(content), remotePath, client.PutOptions{BlockSize: 10000})
+		Expect(err).To(BeNil())
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 3*11))
+		info, err := c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.BlockSize).To(Equal(uint64(10000)))
+
+		// writes and appends keep to the block size of the file
+		err = c.WriteAt(remotePath, 9990, data[:20])
+		Expect(err).To(BeNil())
+		copy(content[9990:], data[:20])
+		err = c.Append(localPath, remotePath)
+		Expect(err).To(BeNil())
+		content = append(content, data...)
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+
+		// should be error, blocks of different sizes cannot be chained
+		err = c.Put(localPath, remoteNewPath)
+		Expect(err).To(BeNil())
+		err = c.Concat(remoteNewPath, []string{remotePath})
+		Expect(err).ToNot(BeNil())
+
+		// blocks larger than the default message size of grpc
+		large := bytes.Repeat(data, 5000)
+		err = c.PutStreamWithOptions(bytes.NewReader(large), "/LARGE", client.PutOptions{BlockSize: 8 << 20})
+		Expect(err).To(BeNil())
+		err = c.Get("/LARGE", localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err = os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(large, dataCopy)).To(Equal(0))
+	})
+
+	It("Erasure coding", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 100)
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+		err = c.Mkdir(remoteSubDir)
+		Expect(err).To(BeNil())
+
+		// should be error, not a policy
+		err = c.SetErasureCoding(remoteDir, "RS-0-1")
+		Expect(err).ToNot(BeNil())
+		err = c.SetErasureCoding(remoteDir, "XOR-2-1")
+		Expect(err).ToNot(BeNil())
+
+		err = c.SetErasureCoding(remoteDir, "RS-2-1")
+		Expect(err).To(BeNil())
+
+		// each block is stored as 2 data cells and 1 parity cell, files in
+		// sub dirs inherit the policy
+		err = c.PutStreamWithOptions(bytes.NewReader(content), remotePathWithSubDir, client.PutOptions{BlockSize: 10000})
+		Expect(err).To(BeNil())
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 3*11))
+		info, err := c.Stat(remotePathWithSubDir)
+		Expect(err).To(BeNil())
+		Expect(info.ErasureCoding).To(Equal("RS-2-1"))
+		Expect(info.Replication).To(Equal(uint32(0)))
+		count, err := c.Count(remoteSubDir)
+		Expect(err).To(BeNil())
+		Expect(count.SpaceConsumed).To(Equal(int64(utils.CeilDiv(uint64(count.Size), 2) * 3)))
+
+		// writes and appends are coded as well
+		err = c.WriteAt(remotePathWithSubDir, 9990, data[:20])
+		Expect(err).To(BeNil())
+		copy(content[9990:], data[:20])
+		err = c.Append(localPath, remotePathWithSubDir)
+		Expect(err).To(BeNil())
+		content = append(content, data...)
+		err = c.Truncate(remotePathWithSubDir, uint64(len(content)-5000))
+		Expect(err).To(BeNil())
+		content = content[:len(content)-5000]
+		err = c.Get(remotePathWithSubDir, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+		err = c.Get(remotePathWithDir, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err = os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(data, dataCopy)).To(Equal(0))
+
+		// should be error, erasure coded files have no replicas
+		err = c.SetReplication(remotePathWithDir, 2)
+		Expect(err).ToNot(BeNil())
+		err = c.PutWithOptions(localPath, remotePathWithDir+"2", client.PutOptions{Replication: 2})
+		Expect(err).ToNot(BeNil())
+
+		// should be error, replicated and coded blocks cannot be chained
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+		err = c.Concat(remotePath, []string{remotePathWithDir})
+		Expect(err).ToNot(BeNil())
+
+		// should be error, more cells than datanodes
+		err = c.SetErasureCoding(remoteSubDir, "RS-3-1")
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remotePathWithSubDir+"2")
+		Expect(err).ToNot(BeNil())
+
+		// files created afterwards are replicated again
+		err = c.SetErasureCoding(remoteDir, "")
+		Expect(err).To(BeNil())
+		err = c.SetErasureCoding(remoteSubDir, "")
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remotePathWithDir+"2")
+		Expect(err).To(BeNil())
+		info, err = c.Stat(remotePathWithDir + "2")
+		Expect(err).To(BeNil())
+		Expect(info.ErasureCoding).To(Equal(""))
+		Expect(info.Replication).To(Equal(uint32(3)))
+		info, err = c.Stat(remotePathWithDir)
+		Expect(err).To(BeNil())
+		Expect(info.ErasureCoding).To(Equal("RS-2-1"))
+	})
+
+	It("Rack awareness", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		ctxTarget, cancelFuncTarget := context.WithCancel(context.Background())
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServerWithTopology("localhost:9000", "zone-a", "rack-1").Setup(ctx)
+		go datanode.NewDataNodeServerWithTopology("localhost:9001", "zone-a", "rack-1").Setup(ctx)
+		go datanode.NewDataNodeServerWithTopology("localhost:9002", "zone-b", "rack-2").Setup(ctxTarget)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 100)
+		zoneA := countBlocks("localhost:9000", "localhost:9001")
+		zoneB := countBlocks("localhost:9002")
+
+		// one replica of each block goes to the only datanode of zone-b
+		err = c.PutStreamWithOptions(bytes.NewReader(content), remotePath, client.PutOptions{Replication: 2})
+		Expect(err).To(BeNil())
+		Expect(countBlocks("localhost:9000", "localhost:9001")).To(Equal(zoneA + 3))
+		Expect(countBlocks("localhost:9002")).To(Equal(zoneB + 3))
+		misplaced, err := c.FetchMisplacedBlocks()
+		Expect(err).To(BeNil())
+		Expect(misplaced).To(BeEmpty())
+
+		// the replicas of zone-b move to zone-a, the only zone left
+		cancelFuncTarget()
+		time.Sleep(5 * time.Second) // for data migration
+		misplaced, err = c.FetchMisplacedBlocks()
+		Expect(err).To(BeNil())
+		Expect(misplaced).To(BeEmpty())
+
+		// and share it once another zone comes up
+		go datanode.NewDataNodeServerWithTopology("localhost:9003", "zone-c", "rack-3").Setup(ctx)
+		time.Sleep(5 * time.Second) // for registration
+		misplaced, err = c.FetchMisplacedBlocks()
+		Expect(err).To(BeNil())
+		Expect(misplaced).To(HaveLen(3))
+		for i, block := range misplaced {
+			Expect(block.Path).To(Equal(remotePath))
+			Expect(block.Index).To(Equal(uint64(i)))
+			Expect(block.Domain).To(Equal("/zone-a"))
+			Expect(block.Addrs).To(ConsistOf("localhost:9000", "localhost:9001"))
+		}
+
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+	})
+
+	It("Placement policy", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		placement := consts.PlacementPolicy
+		consts.PlacementPolicy = "consistent-hashing"
+		defer func() {
+			consts.PlacementPolicy = placement
+		}()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 100)
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		err = c.PutStreamWithOptions(bytes.NewReader(content), remotePath, client.PutOptions{Replication: 1})
+		Expect(err).To(BeNil())
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 3))
+		fetchAddrs := func() [][]string {
+			var addrs [][]string
+			for index := uint64(0); index < 3; index++ {
+				reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+					Path:  remotePath,
+					Index: index,
+					Type:  protos.FetchBlockAddrsRequestType_OP_GET,
+				})
+				Expect(err).To(BeNil())
+				addrs = append(addrs, reply.Addrs)
+			}
+			return addrs
+		}
+		addrs := fetchAddrs()
+
+		err = c.SetReplication(remotePath, 3)
+		Expect(err).To(BeNil())
+		time.Sleep(5 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 9))
+
+		// the replicas kept are on the datanodes the blocks hash to
+		err = c.SetReplication(remotePath, 1)
+		Expect(err).To(BeNil())
+		time.Sleep(8 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks + 3))
+		Expect(fetchAddrs()).To(Equal(addrs))
+
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+	})
+
+	It("Capacity-aware placement", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		// all of its space is reserved
+		go datanode.NewDataNodeServerWithOptions("localhost:9002", datanode.Options{Capacity: 1 << 20, Reserved: 1 << 20}).Setup(ctx)
+
+		// wait for setup and heartbeats
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 100)
+		blocks := countBlocks("localhost:9000", "localhost:9001")
+		full := countBlocks("localhost:9002")
+
+		// should be error, the full datanode takes no replicas
+		err = c.PutStreamWithOptions(bytes.NewReader(content), remoteNewPath, client.PutOptions{Replication: 3})
+		Expect(err).ToNot(BeNil())
+
+		err = c.PutStreamWithOptions(bytes.NewReader(content), remotePath, client.PutOptions{Replication: 2})
+		Expect(err).To(BeNil())
+		Expect(countBlocks("localhost:9000", "localhost:9001")).To(Equal(blocks + 6))
+		Expect(countBlocks("localhost:9002")).To(Equal(full))
+
+		// nor more replicas later
+		err = c.SetReplication(remotePath, 3)
+		Expect(err).To(BeNil())
+		time.Sleep(5 * time.Second)
+		Expect(countBlocks("localhost:9002")).To(Equal(full))
+
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+	})
+
+	It("Append", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+		err = c.Copy(remotePathWithDir, remotePath)
+		Expect(err).To(BeNil())
+		_, err = c.CreateSnapshot(remoteDir, "s1")
+		Expect(err).To(BeNil())
+
+		// each append rewrites the partial last block
+		expected := data
+		for i := 0; i < 5; i++ {
+			err = c.Append(localPath, remotePathWithDir)
+			Expect(err).To(BeNil())
+			expected = append(expected, data...)
+		}
+
+		info, err := c.Stat(remotePathWithDir)
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(len(expected))))
+
+		err = c.Get(remotePathWithDir, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(expected, dataCopy)).To(Equal(0))
+
+		// the copy and the snapshot sharing the old last block are untouched
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err = os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(data, dataCopy)).To(Equal(0))
+
+		err = c.Get(remoteDir+".snapshot/s1/LICENSE", localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err = os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(data, dataCopy)).To(Equal(0))
+
+		// should be error, not exists
+		err = c.Append(localPath, remoteNewPath)
+		Expect(err).ToNot(BeNil())
+
+		// should be error, the tail read has been rewritten since
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path: remotePath,
+			Type: protos.FetchBlockAddrsRequestType_OP_GET,
+		})
+		Expect(err).To(BeNil())
+		err = c.WriteAt(remotePath, 0, data[:10])
+		Expect(err).To(BeNil())
+		_, err = nameNode.Append(context.Background(), &protos.AppendRequest{
+			Path:       remotePath,
+			Offset:     uint64(len(data)),
+			Size:       uint64(len(data)),
+			ClientName: "writer",
+			Tail:       reply.Uuid,
+		})
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Abandoned append", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		leaseDuration := consts.LeaseDuration
+		consts.LeaseDuration = 2 * time.Second
+		defer func() {
+			consts.LeaseDuration = leaseDuration
+		}()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		// the writer rewrites the partial last block, then goes away
+		tail, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path: remotePath,
+			Type: protos.FetchBlockAddrsRequestType_OP_GET,
+		})
+		Expect(err).To(BeNil())
+		reply, err := nameNode.Append(context.Background(), &protos.AppendRequest{
+			Path:       remotePath,
+			Offset:     uint64(len(data)),
+			Size:       uint64(len(data)),
+			ClientName: "writer",
+			Tail:       tail.Uuid,
+		})
+		Expect(err).To(BeNil())
+		writeBlock(nameNode, remotePath, reply.Index, append(append([]byte(nil), data...), data...))
+
+		// readers see the old content while the append is in progress
+		info, err := c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(len(data))))
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(data, dataCopy)).To(Equal(0))
+
+		// wait for lease recovery
+		time.Sleep(15 * time.Second)
+
+		// the append is dropped as a whole and its blocks are reclaimed
+		info, err = c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(len(data))))
+		Expect(info.UnderConstruction).To(BeFalse())
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err = os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(data, dataCopy)).To(Equal(0))
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks))
+
+		// the file can be appended to again
+		err = c.Append(localPath, remotePath)
+		Expect(err).To(BeNil())
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err = os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(append(append([]byte(nil), data...), data...), dataCopy)).To(Equal(0))
+	})
+
+	It("WriteAt and Truncate", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		// more than one block
+		expected := bytes.Repeat(data, 50)
+		largePath := "/tmp/LICENSE_LARGE"
+		err = os.WriteFile(largePath, expected, 0644)
+		Expect(err).To(BeNil())
+		defer os.Remove(largePath)
+
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+		err = c.Put(largePath, remotePathWithDir)
+		Expect(err).To(BeNil())
+		_, err = c.CreateSnapshot(remoteDir, "s1")
+		Expect(err).To(BeNil())
+		original := append([]byte(nil), expected...)
+
+		expectContent := func(path string, content []byte) {
+			err := c.Get(path, localCopyPath)
+			Expect(err).To(BeNil())
+			dataCopy, err := os.ReadFile(localCopyPath)
+			Expect(err).To(BeNil())
+			Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+		}
+
+		// overwrite across a block boundary
+		patch := bytes.Repeat([]byte("x"), 2000)
+		err = c.WriteAt(remotePathWithDir, 40000, patch)
+		Expect(err).To(BeNil())
+		copy(expected[40000:], patch)
+		expectContent(remotePathWithDir, expected)
+
+		// the snapshot keeps the old blocks
+		expectContent(remoteDir+".snapshot/s1/LICENSE", original)
+
+		// write at the end grows the file
+		err = c.WriteAt(remotePathWithDir, uint64(len(expected)), patch)
+		Expect(err).To(BeNil())
+		expected = append(expected, patch...)
+		expectContent(remotePathWithDir, expected)
+
+		// should be error, leaves a hole
+		err = c.WriteAt(remotePathWithDir, uint64(len(expected))+1, patch)
+		Expect(err).ToNot(BeNil())
+
+		err = c.Truncate(remotePathWithDir, 30000)
+		Expect(err).To(BeNil())
+		expected = expected[:30000]
+		expectContent(remotePathWithDir, expected)
+		info, err := c.Stat(remotePathWithDir)
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(30000)))
+
+		// the last block is copied short rather than cut in place
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path: remotePathWithDir,
+			Type: protos.FetchBlockAddrsRequestType_OP_GET,
+		})
+		Expect(err).To(BeNil())
+		Expect(len(reply.Addrs)).To(Equal(3))
+		for _, addr := range reply.Addrs {
+			dataNode, conn, err := utils.ConnectToTargetDataNode(addr, "")
+			Expect(err).To(BeNil())
+			block, err := dataNode.Read(context.Background(), &protos.ReadRequest{Uuid: reply.Uuid})
+			Expect(err).To(BeNil())
+			Expect(block.Data).To(Equal(expected))
+			conn.Close()
+		}
+
+		// should be error, cannot grow
+		err = c.Truncate(remotePathWithDir, 40000)
+		Expect(err).ToNot(BeNil())
+
+		// the cut off bytes of the last block do not come back
+		err = c.Append(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+		expected = append(expected, data...)
+		expectContent(remotePathWithDir, expected)
+
+		err = c.Truncate(remotePathWithDir, 0)
+		Expect(err).To(BeNil())
+		expectContent(remotePathWithDir, []byte{})
+		expectContent(remoteDir+".snapshot/s1/LICENSE", original)
+	})
+
+	It("Commit write", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		leaseDuration := consts.LeaseDuration
+		consts.LeaseDuration = 2 * time.Second
+		defer func() {
+			consts.LeaseDuration = leaseDuration
+		}()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remoteNewPath)
+		Expect(err).To(BeNil())
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		patch := bytes.Repeat([]byte("x"), len(data))
+		prepare := func(size uint64) *protos.PrepareWriteReply {
+			reply, err := nameNode.PrepareWrite(context.Background(), &protos.PrepareWriteRequest{
+				Path: remotePath,
+				Size: size,
+			})
+			Expect(err).To(BeNil())
+			return reply
+		}
+		write := func(block *protos.BlockAddrs) {
+			for _, addr := range block.Addrs {
+				dataNode, conn, err := utils.ConnectToTargetDataNode(addr, "")
+				Expect(err).To(BeNil())
+				_, err = dataNode.Write(context.Background(), &protos.WriteRequest{Uuid: block.Uuid, Data: patch})
+				Expect(err).To(BeNil())
+				conn.Close()
+			}
+		}
+		commit := func(path string, size uint64, blocks ...*protos.BlockAddrs) error {
+			_, err := nameNode.CommitWrite(context.Background(), &protos.CommitWriteRequest{
+				Path:     path,
+				Size:     size,
+				FileSize: uint64(len(data)),
+				Blocks:   blocks,
+			})
+			return err
+		}
+
+		prepared := prepare(uint64(len(patch)))
+		write(prepared.Blocks[0])
+
+		// should be error, never issued
+		forged, err := uuid.New().MarshalBinary()
+		Expect(err).To(BeNil())
+		err = commit(remotePath, uint64(len(patch)), &protos.BlockAddrs{Uuid: forged, Addrs: prepared.Blocks[0].Addrs})
+		Expect(err).ToNot(BeNil())
+
+		// should be error, a block of another file
+		reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{Path: remoteNewPath})
+		Expect(err).To(BeNil())
+		err = commit(remotePath, uint64(len(patch)), &protos.BlockAddrs{Uuid: reply.Uuid, Addrs: reply.Addrs})
+		Expect(err).ToNot(BeNil())
+
+		// should be error, prepared for another file
+		err = commit(remoteNewPath, uint64(len(patch)), prepared.Blocks[0])
+		Expect(err).ToNot(BeNil())
+
+		// should be error, the same block twice, and the write cannot commit later
+		twice := prepare(40960 + 1)
+		Expect(len(twice.Blocks)).To(Equal(2))
+		err = commit(remotePath, 40960+1, twice.Blocks[0], twice.Blocks[0])
+		Expect(err).ToNot(BeNil())
+		err = commit(remotePath, 40960+1, twice.Blocks...)
+		Expect(err).ToNot(BeNil())
+
+		err = commit(remotePath, uint64(len(patch)), prepared.Blocks[0])
+		Expect(err).To(BeNil())
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(patch, dataCopy)).To(Equal(0))
+
+		// should be error, committed already
+		err = commit(remotePath, uint64(len(patch)), prepared.Blocks[0])
+		Expect(err).ToNot(BeNil())
+
+		// should be error, the block has been replaced by another write since
+		first, second := prepare(uint64(len(patch))), prepare(uint64(len(patch)))
+		write(first.Blocks[0])
+		write(second.Blocks[0])
+		err = commit(remotePath, uint64(len(patch)), first.Blocks[0])
+		Expect(err).To(BeNil())
+		err = commit(remotePath, uint64(len(patch)), second.Blocks[0])
+		Expect(err).ToNot(BeNil())
+
+		// the writer goes away before committing
+		abandoned := prepare(uint64(len(patch)))
+		write(abandoned.Blocks[0])
+
+		// wait for the prepared blocks to expire and be reclaimed
+		time.Sleep(15 * time.Second)
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks))
+		err = commit(remotePath, uint64(len(patch)), abandoned.Blocks[0])
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Streaming put", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		// the size is never told to the namenode
+		for _, content := range [][]byte{bytes.Repeat(data, 50), make([]byte, 2*40960), {}} {
+			err = c.PutStream(bytes.NewReader(content), remotePath)
+			Expect(err).To(BeNil())
+
+			info, err := c.Stat(remotePath)
+			Expect(err).To(BeNil())
+			Expect(info.Size).To(Equal(uint64(len(content))))
+
+			err = c.Get(remotePath, localCopyPath)
+			Expect(err).To(BeNil())
+			dataCopy, err := os.ReadFile(localCopyPath)
+			Expect(err).To(BeNil())
+			Expect(bytes.Compare(content, dataCopy)).To(Equal(0))
+
+			err = c.Delete(remotePath, false, true)
+			Expect(err).To(BeNil())
+		}
+
+		// a file under construction only takes blocks and the final size
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		_, err = nameNode.Create(context.Background(), &protos.CreateRequest{Path: remoteNewPath, Streaming: true, ClientName: "writer"})
+		Expect(err).To(BeNil())
+
+		// should be error, under construction
+		err = c.Append(localPath, remoteNewPath)
+		Expect(err).ToNot(BeNil())
+		err = c.Copy(remoteNewPath, remotePath)
+		Expect(err).ToNot(BeNil())
+
+		_, err = nameNode.AddBlock(context.Background(), &protos.AddBlockRequest{Path: remoteNewPath, ClientName: "writer"})
+		Expect(err).To(BeNil())
+		// should be error, one block cannot hold the size
+		_, err = nameNode.Complete(context.Background(), &protos.CompleteRequest{Path: remoteNewPath, Size: 40961, ClientName: "writer"})
+		Expect(err).ToNot(BeNil())
+		// should be error, the block is not written yet
+		_, err = nameNode.Complete(context.Background(), &protos.CompleteRequest{Path: remoteNewPath, Size: 100, ClientName: "writer"})
+		Expect(err).ToNot(BeNil())
+		writeBlock(nameNode, remoteNewPath, 0, data[:100])
+		_, err = nameNode.Complete(context.Background(), &protos.CompleteRequest{Path: remoteNewPath, Size: 100, ClientName: "writer"})
+		Expect(err).To(BeNil())
+		// should be error, already completed
+		_, err = nameNode.AddBlock(context.Background(), &protos.AddBlockRequest{Path: remoteNewPath, ClientName: "writer"})
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Lease", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		leaseDuration := consts.LeaseDuration
+		consts.LeaseDuration = 2 * time.Second
+		defer func() {
+			consts.LeaseDuration = leaseDuration
+		}()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 200)
+
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		_, err = nameNode.Create(context.Background(), &protos.CreateRequest{Path: remoteNewPath, Streaming: true, ClientName: "w1"})
+		Expect(err).To(BeNil())
+
+		// should be error, leased to w1
+		_, err = nameNode.AddBlock(context.Background(), &protos.AddBlockRequest{Path: remoteNewPath, ClientName: "w2"})
+		Expect(err).ToNot(BeNil())
+		_, err = nameNode.Complete(context.Background(), &protos.CompleteRequest{Path: remoteNewPath, ClientName: "w2"})
+		Expect(err).ToNot(BeNil())
+		err = c.Append(localPath, remoteNewPath)
+		Expect(err).ToNot(BeNil())
+
+		// the writer crashes halfway, after two full blocks
+		err = c.PutStream(io.MultiReader(bytes.NewReader(content[:2*40960]), iotest.ErrReader(errors.New("crashed"))), remotePath)
+		Expect(err).ToNot(BeNil())
+
+		// a writer renewing its lease may take longer than the lease duration
+		r, w := io.Pipe()
+		go func() {
+			for i := 0; i < 4; i++ {
+				time.Sleep(time.Second)
+				_, _ = w.Write(content[i*40960 : (i+1)*40960])
+			}
+			_ = w.Close()
+		}()
+		err = c.PutStream(r, "/SLOW")
+		Expect(err).To(BeNil())
+		info, err := c.Stat("/SLOW")
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(4 * 40960)))
+
+		// others cannot keep the lease of w1 alive, wait for lease recovery
+		os.Setenv("SDSS_USER", "bob")
+		defer os.Unsetenv("SDSS_USER")
+		for i := 0; i < 15; i++ {
+			_, err = nameNode.RenewLease(context.Background(), &protos.RenewLeaseRequest{ClientName: "w1"})
+			Expect(err).To(BeNil())
+			time.Sleep(time.Second)
+		}
+		os.Unsetenv("SDSS_USER")
+
+		// nothing written, abandoned
+		_, err = c.Stat(remoteNewPath)
+		Expect(err).ToNot(BeNil())
+
+		// the length of the last block is unknown, recovered with the first block
+		info, err = c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.Size).To(Equal(uint64(40960)))
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(content[:40960], dataCopy)).To(Equal(0))
+
+		// recovered files can be written again
+		err = c.Append(localPath, remotePath)
+		Expect(err).To(BeNil())
+	})
+
+	It("Visibility", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		// a put in progress, blocks are allocated but not written
+		_, err = nameNode.Create(context.Background(), &protos.CreateRequest{
+			Path:       remotePath,
+			Size:       uint64(len(data)),
+			ClientName: "writer",
+		})
+		Expect(err).To(BeNil())
+
+		// should be error, hidden from readers
+		_, err = c.Stat(remotePath)
+		Expect(err).ToNot(BeNil())
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).ToNot(BeNil())
+		infos, err := c.List("/")
+		Expect(err).To(BeNil())
+		Expect(len(infos)).To(Equal(0))
+		// should be error, the name is taken
+		err = c.Put(localPath, remotePath)
+		Expect(err).ToNot(BeNil())
+
+		writeBlock(nameNode, remotePath, 0, data)
+		// still hidden until completed
+		_, err = c.Stat(remotePath)
+		Expect(err).ToNot(BeNil())
+		_, err = nameNode.Complete(context.Background(), &protos.CompleteRequest{
+			Path:       remotePath,
+			Size:       uint64(len(data)),
+			ClientName: "writer",
+		})
+		Expect(err).To(BeNil())
+
+		info, err := c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.UnderConstruction).To(BeFalse())
+		err = c.Get(remotePath, localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Compare(data, dataCopy)).To(Equal(0))
+
+		// an append in progress is flagged
+		tail, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path: remotePath,
+			Type: protos.FetchBlockAddrsRequestType_OP_GET,
+		})
+		Expect(err).To(BeNil())
+		_, err = nameNode.Append(context.Background(), &protos.AppendRequest{
+			Path:       remotePath,
+			Offset:     uint64(len(data)),
+			Size:       uint64(len(data)),
+			ClientName: "writer",
+			Tail:       tail.Uuid,
+		})
+		Expect(err).To(BeNil())
+		info, err = c.Stat(remotePath)
+		Expect(err).To(BeNil())
+		Expect(info.UnderConstruction).To(BeTrue())
+		infos, err = c.List("/")
+		Expect(err).To(BeNil())
+		Expect(len(infos)).To(Equal(1))
+	})
+
+	It("Mkdir", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		err := c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+
+		files, err := c.List("/")
+		Expect(err).To(BeNil())
+
+		// the new directory is the only child of root，and size is 0
+		Expect(len(files)).To(Equal(1))
+		Expect(files[0].Size).To(Equal(uint64(0)))
+		Expect(files[0].Name).To(Equal(remoteDir))
+
+		files, err = c.List(remoteDir)
+		Expect(err).To(BeNil())
+		Expect(len(files)).To(Equal(0))
+	})
+
+	It("Rename", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+
+		err = c.Rename(remotePath, remoteNewPath)
+		Expect(err).To(BeNil())
+
+		err = c.Get(remoteNewPath, localCopyPath)
+		Expect(err).To(BeNil())
+
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
+	})
+
+	It("Rename dir", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		err = c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+		err = c.Mkdir(remoteSubDir)
+		Expect(err).To(BeNil())
+		err = c.Put(localPath, remotePathWithSubDir)
+		Expect(err).To(BeNil())
+
+		// should be error, no parent dir or moving into itself
+		err = c.Rename(remoteDir, "/none/doc/")
+		Expect(err).ToNot(BeNil())
+		err = c.Rename(remoteDir, remoteSubDir+"doc/")
+		Expect(err).ToNot(BeNil())
+
+		// move the whole dir
+		err = c.Rename(remoteDir, "/doc_new/")
+		Expect(err).To(BeNil())
+		_, err = c.List(remoteDir)
+		Expect(err).ToNot(BeNil())
+		err = c.Get("/doc_new/sub/LICENSE", localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
+
+		// should be error, dest exists
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+		err = c.Rename(remotePath, "/doc_new/sub/LICENSE")
+		Expect(err).ToNot(BeNil())
+
+		err = c.RenameOverwrite(remotePath, "/doc_new/sub/LICENSE")
+		Expect(err).To(BeNil())
+		files, err := c.List("/")
+		Expect(err).To(BeNil())
+		Expect(len(files)).To(Equal(1))
+		Expect(files[0].Name).To(Equal("/doc_new/"))
+	})
+
+	It("List", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		err := c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithDir)
+		Expect(err).To(BeNil())
+
+		err = c.Mkdir(remoteSubDir)
+		Expect(err).To(BeNil())
+
+		err = c.Put(localPath, remotePathWithSubDir)
+		Expect(err).To(BeNil())
+
+		fileInfos, err := c.List(remoteDir)
+		Expect(err).To(BeNil())
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+
+		// only direct children, sorted by name
+		Expect(len(fileInfos)).To(Equal(2))
+		Expect(fileInfos[0].Name).To(Equal(remotePathWithDir))
+		Expect(fileInfos[0].Size).To(Equal(uint64(len(data))))
+		Expect(fileInfos[1].Name).To(Equal(remoteSubDir))
+		Expect(fileInfos[1].Size).To(Equal(uint64(0)))
+	})
+
+	It("List with pagination", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		err := c.Mkdir(remoteDir)
+		Expect(err).To(BeNil())
+
+		names := []string{"e/", "a/", "d/", "b/", "c/"}
+		for _, name := range names {
+			err = c.Mkdir(remoteDir + name)
+			Expect(err).To(BeNil())
+		}
+
+		var listed []string
+		cursor := ""
+		for {
+			fileInfos, next, err := c.ListPage(remoteDir, cursor, 2)
+			Expect(err).To(BeNil())
+			Expect(len(fileInfos)).To(BeNumerically("<=", 2))
+			for _, info := range fileInfos {
+				listed = append(listed, info.Name)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		Expect(listed).To(Equal([]string{
+			remoteDir + "a/", remoteDir + "b/", remoteDir + "c/", remoteDir + "d/", remoteDir + "e/",
+		}))
+
+		// the order holds as children leave, and a page is capped by the server
+		err = c.Remove(remoteDir + "c/")
+		Expect(err).To(BeNil())
+		fileInfos, next, err := c.ListPage(remoteDir, "", 1<<31)
+		Expect(err).To(BeNil())
+		Expect(next).To(BeEmpty())
+		Expect(fileInfos).To(HaveLen(4))
+		Expect(fileInfos[2].Name).To(Equal(remoteDir + "d/"))
 	})
 })
@@ -11,6 +11,8 @@ import (
 	"simple-distributed-storage-system/src/consts"
 	"simple-distributed-storage-system/src/datanode"
 	"simple-distributed-storage-system/src/namenode"
+	"simple-distributed-storage-system/src/protos"
+	"simple-distributed-storage-system/src/utils"
 	"testing"
 	"time"
 )
@@ -20,6 +22,16 @@ func TestCrash(t *testing.T) {
 	RunSpecs(t, "CRASH TESTS")
 }
 
+func countBlocks(addrs ...string) int {
+	count := 0
+	for _, addr := range addrs {
+		entries, err := os.ReadDir("/tmp/gfs/chunks/" + addr + "/")
+		Expect(err).To(BeNil())
+		count += len(entries)
+	}
+	return count
+}
+
 var _ = Describe("CRASH TESTS", func() {
 	BeforeEach(func() {
 		err := os.RemoveAll(consts.RaftPersistenceDataDir)
@@ -74,6 +86,68 @@ var _ = Describe("CRASH TESTS", func() {
 		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
 	})
 
+	It("Crash the writer halfway through a put", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		leaseDuration := consts.LeaseDuration
+		consts.LeaseDuration = 2 * time.Second
+		defer func() {
+			consts.LeaseDuration = leaseDuration
+		}()
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		blocks := countBlocks("localhost:9000", "localhost:9001", "localhost:9002")
+
+		// the writer creates a file of three blocks, writes the first one and crashes
+		nameNode, conn, err := utils.ConnectToNameNode(false, "")
+		Expect(err).To(BeNil())
+		_, err = nameNode.Create(context.Background(), &protos.CreateRequest{Path: remotePath, Size: 3 * consts.BlockSize, ClientName: "w1"})
+		Expect(err).To(BeNil())
+		reply, err := nameNode.FetchBlockAddrs(context.Background(), &protos.FetchBlockAddrsRequest{
+			Path:  remotePath,
+			Index: 0,
+			Type:  protos.FetchBlockAddrsRequestType_OP_PUT,
+		})
+		Expect(err).To(BeNil())
+		validity := make(map[string]bool)
+		for _, addr := range reply.Addrs {
+			dataNode, dataConn, err := utils.ConnectToTargetDataNode(addr, "")
+			Expect(err).To(BeNil())
+			_, err = dataNode.Write(context.Background(), &protos.WriteRequest{Uuid: reply.Uuid, Data: make([]byte, consts.BlockSize), Token: reply.Token})
+			Expect(err).To(BeNil())
+			dataConn.Close()
+			validity[addr] = true
+		}
+		_, err = nameNode.LocsValidityNotify(context.Background(), &protos.LocsValidityNotifyRequest{Uuid: reply.Uuid, Validity: validity})
+		Expect(err).To(BeNil())
+		conn.Close()
+
+		// wait for lease recovery, the put is abandoned as a whole
+		time.Sleep(15 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		_, err = c.Stat(remotePath)
+		Expect(err).ToNot(BeNil())
+		Expect(countBlocks("localhost:9000", "localhost:9001", "localhost:9002")).To(Equal(blocks))
+
+		// the path is free again
+		err = c.Put(localPath, remotePath)
+		Expect(err).To(BeNil())
+	})
+
 	It("Crash one datanode server and reconnect", func() {
 		ctx, cancelFunc := context.WithCancel(context.Background())
 		defer cancelFunc()
@@ -112,6 +186,61 @@ var _ = Describe("CRASH TESTS", func() {
 		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
 	})
 
+	It("Crash one datanode server with erasure coding", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		ctxTarget, cancelFuncTarget := context.WithCancel(context.Background())
+
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[0], 1).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[1], 2).Setup(ctx)
+		go namenode.NewNameNodeServer(consts.NameNodeServerAddrs[2], 3).Setup(ctx)
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9002").Setup(ctxTarget)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		data, err := os.ReadFile(localPath)
+		Expect(err).To(BeNil())
+		content := bytes.Repeat(data, 100)
+
+		// every datanode holds one cell of each block
+		err = c.Mkdir("/ec/")
+		Expect(err).To(BeNil())
+		err = c.SetErasureCoding("/ec/", "RS-2-1")
+		Expect(err).To(BeNil())
+		err = c.PutStreamWithOptions(bytes.NewReader(content), "/ec/LICENSE", client.PutOptions{BlockSize: 10000})
+		Expect(err).To(BeNil())
+
+		// missing cells are rebuilt on the fly
+		cancelFuncTarget()
+		err = c.Get("/ec/LICENSE", localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err := os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Equal(dataCopy, content)).To(BeTrue())
+
+		// and re-encoded on the new datanode in the background
+		entries, _ := os.ReadDir("/tmp/gfs/chunks/localhost:9003/")
+		cells := len(entries)
+		go datanode.NewDataNodeServer("localhost:9003").Setup(ctx)
+		time.Sleep(10 * time.Second)
+		entries, err = os.ReadDir("/tmp/gfs/chunks/localhost:9003/")
+		Expect(err).To(BeNil())
+		Expect(len(entries)).To(Equal(cells + 11))
+
+		err = c.Get("/ec/LICENSE", localCopyPath)
+		Expect(err).To(BeNil())
+		dataCopy, err = os.ReadFile(localCopyPath)
+		Expect(err).To(BeNil())
+		Expect(bytes.Equal(dataCopy, content)).To(BeTrue())
+	})
+
 	It("Crash one namenode server", func() {
 		ctx, cancelFunc := context.WithCancel(context.Background())
 		defer cancelFunc()
@@ -147,4 +276,44 @@ var _ = Describe("CRASH TESTS", func() {
 		Expect(err).To(BeNil())
 		Expect(bytes.Equal(dataCopy, data)).To(BeTrue())
 	})
+
+	It("Crash the leader namenode server with a full datanode", func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+
+		var cancelFuncs []context.CancelFunc
+		for i, addr := range consts.NameNodeServerAddrs {
+			ctxTarget, cancelFuncTarget := context.WithCancel(context.Background())
+			defer cancelFuncTarget()
+			cancelFuncs = append(cancelFuncs, cancelFuncTarget)
+			go namenode.NewNameNodeServer(addr, uint64(i+1)).Setup(ctxTarget)
+		}
+
+		go datanode.NewDataNodeServer("localhost:9000").Setup(ctx)
+		go datanode.NewDataNodeServer("localhost:9001").Setup(ctx)
+		// all of its space is reserved
+		go datanode.NewDataNodeServerWithOptions("localhost:9002", datanode.Options{Capacity: 1 << 20, Reserved: 1 << 20}).Setup(ctx)
+
+		// wait for setup
+		time.Sleep(5 * time.Second)
+
+		for i, addr := range consts.NameNodeServerAddrs {
+			_, conn, err := utils.ConnectToTargetNameNode(addr, false, "")
+			if err == nil {
+				conn.Close()
+				cancelFuncs[i]()
+				break
+			}
+		}
+		time.Sleep(5 * time.Second) // wait for election
+
+		c := client.NewClient(false)
+		defer c.CloseClient()
+
+		// the new leader keeps the full datanode out as well
+		err := c.PutWithReplication(localPath, remotePath, 3)
+		Expect(err).ToNot(BeNil())
+		err = c.PutWithReplication(localPath, remotePath, 2)
+		Expect(err).To(BeNil())
+	})
 })